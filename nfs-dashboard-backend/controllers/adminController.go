@@ -56,9 +56,20 @@ func (ac *AdminController) ManageRoles(w http.ResponseWriter, r *http.Request) {
     }
 }
 
-// SystemSettings handles system settings logic.
+// SystemSettings handles GET and PUT /api/admin/settings.
 func (ac *AdminController) SystemSettings(w http.ResponseWriter, r *http.Request) {
-    // Example: Fetch system settings from the service
+    if r.Method == http.MethodPut {
+        var update models.SystemSettings
+        if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+            respondWithError(w, http.StatusBadRequest, "Invalid request")
+            return
+        }
+        if err := ac.adminService.UpdateSystemSettings(update, currentUserID(r)); err != nil {
+            respondWithError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+    }
+
     settings, err := ac.adminService.GetSystemSettings()
     if err != nil {
         respondWithError(w, http.StatusInternalServerError, "Failed to fetch system settings")
@@ -62,35 +62,24 @@ func (ac *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 }
 
 func (ac *AuthController) Profile(w http.ResponseWriter, r *http.Request) {
-    token := r.Header.Get("Authorization")
-    if token == "" {
-        log.Println("Authorization header missing")
+    profile := utils.UserFromContext(r.Context())
+    if profile == nil {
+        log.Println("No authenticated user in request context")
         utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
         return
     }
 
-    profile, err := ac.authService.GetProfile(token)
-    if err != nil {
-        log.Printf("Failed to retrieve profile: %v", err)
-        utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
-        return
-    }
-
     utils.RespondWithJSON(w, http.StatusOK, profile)
 }
 
 // POST /api/generate-2fa-secret
 func (ac *AuthController) Generate2FASecret(w http.ResponseWriter, r *http.Request) {
-    token := r.Header.Get("Authorization")
-    if token == "" {
+    caller := utils.UserFromContext(r.Context())
+    if caller == nil {
         utils.RespondWithError(w, http.StatusUnauthorized, "Missing token")
         return
     }
-    email, err := ac.authService.GetEmailFromToken(token)
-    if err != nil {
-        utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
-        return
-    }
+    email := caller.Email
 
     // Find user
     var user *models.User
@@ -163,16 +152,12 @@ func (ac *AuthController) Verify2FA(w http.ResponseWriter, r *http.Request) {
 
 // POST /api/disable-2fa
 func (ac *AuthController) Disable2FA(w http.ResponseWriter, r *http.Request) {
-    token := r.Header.Get("Authorization")
-    if token == "" {
+    caller := utils.UserFromContext(r.Context())
+    if caller == nil {
         utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
         return
     }
-    email, err := ac.authService.GetEmailFromToken(token)
-    if err != nil {
-        utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
-        return
-    }
+    email := caller.Email
     var user *models.User
     for i := range ac.authService.Users {
         if ac.authService.Users[i].Email == email {
@@ -198,8 +183,8 @@ func (ac *AuthController) Logout(w http.ResponseWriter, r *http.Request) {
 
 // POST /api/change-password
 func (ac *AuthController) ChangePassword(w http.ResponseWriter, r *http.Request) {
-    token := r.Header.Get("Authorization")
-    if token == "" {
+    caller := utils.UserFromContext(r.Context())
+    if caller == nil {
         utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
         return
     }
@@ -211,12 +196,7 @@ func (ac *AuthController) ChangePassword(w http.ResponseWriter, r *http.Request)
         utils.RespondWithError(w, http.StatusBadRequest, "Invalid payload")
         return
     }
-    email, err := ac.authService.GetEmailFromToken(token)
-    if err != nil {
-        utils.RespondWithError(w, http.StatusUnauthorized, "Invalid token")
-        return
-    }
-    changed, err := ac.authService.ChangePassword(email, req.OldPassword, req.NewPassword)
+    changed, err := ac.authService.ChangePassword(caller.Email, req.OldPassword, req.NewPassword)
     if err != nil {
         utils.RespondWithError(w, http.StatusBadRequest, err.Error())
         return
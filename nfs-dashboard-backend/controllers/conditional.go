@@ -0,0 +1,96 @@
+package controllers
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// setValidators sets the ETag and Last-Modified of a response. Clients may
+// keep the response but must revalidate it before every use, since files
+// change without notice.
+func setValidators(w http.ResponseWriter, etag string, modTime time.Time) {
+    w.Header().Set("Cache-Control", "private, no-cache")
+    w.Header().Set("ETag", etag)
+    if !modTime.IsZero() && modTime.Unix() > 0 {
+        w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+    }
+}
+
+// notModified sets the validators of a response and answers 304 when the
+// request's If-None-Match, or failing that its If-Modified-Since, shows the
+// client's copy is current.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+    setValidators(w, etag, modTime)
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        return false
+    }
+    if header := r.Header.Get("If-None-Match"); header != "" {
+        if !etagMatches(header, etag) {
+            return false
+        }
+    } else if !modifiedSinceMatches(r.Header.Get("If-Modified-Since"), modTime) {
+        return false
+    }
+    w.Header().Del("Content-Type")
+    w.Header().Del("Content-Length")
+    w.WriteHeader(http.StatusNotModified)
+    return true
+}
+
+// etagMatches reports whether an If-None-Match header lists etag. Weak
+// validators match too, as RFC 7232 requires for this header.
+func etagMatches(header, etag string) bool {
+    etag = strings.TrimPrefix(etag, "W/")
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+        if candidate == "*" || candidate == etag {
+            return true
+        }
+    }
+    return false
+}
+
+// modifiedSinceMatches reports whether an If-Modified-Since header shows
+// content last modified at modTime is unchanged. Headers only have second
+// precision.
+func modifiedSinceMatches(header string, modTime time.Time) bool {
+    if header == "" || modTime.IsZero() {
+        return false
+    }
+    since, err := http.ParseTime(header)
+    if err != nil {
+        return false
+    }
+    return !modTime.Truncate(time.Second).After(since)
+}
+
+// respondCachedJSON writes data as JSON with a weak ETag of its encoding,
+// answering 304 when the client already has it. Other response headers set
+// before, like X-Next-Cursor, are part of the ETag. Listings and metadata
+// also change in ways their modification time does not show (locks,
+// permissions), so modTime is only informational: revalidation goes by ETag.
+func respondCachedJSON(w http.ResponseWriter, r *http.Request, data interface{}, modTime time.Time) {
+    var body bytes.Buffer
+    if err := json.NewEncoder(&body).Encode(data); err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return
+    }
+    sum := sha256.New()
+    sum.Write([]byte(w.Header().Get("X-Next-Cursor") + "\n"))
+    sum.Write(body.Bytes())
+    etag := `W/"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+
+    setValidators(w, etag, modTime)
+    if (r.Method == http.MethodGet || r.Method == http.MethodHead) && etagMatches(r.Header.Get("If-None-Match"), etag) {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusOK)
+    w.Write(body.Bytes())
+}
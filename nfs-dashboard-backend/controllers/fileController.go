@@ -1,24 +1,44 @@
 package controllers
 
 import (
+    "context"
     "encoding/json"
+    "errors"
     "net/http"
     "os"
+    "path"
     "path/filepath"
-    "mime"
     "io"
-    "nfs-dashboard-backend/services"
+    "log"
+    "mime"
+    "strconv"
+    "strings"
     "time"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+const (
+    // maxMultipartOverhead allows for form fields and part headers on top of
+    // the file itself when limiting upload request bodies.
+    maxMultipartOverhead = 1 << 20
+    // maxPathField bounds the "path" form field of an upload.
+    maxPathField = 4096
 )
 
 type FileController struct {
-    fileService services.FileService
+    fileService *services.FileService
+    permissions *services.PermissionService
+    jobs        *services.JobService
 }
 
 // NewFileController creates a new FileController with the provided FileService.
-func NewFileController(fileService services.FileService) *FileController {
+func NewFileController(fileService *services.FileService, permissions *services.PermissionService, jobs *services.JobService) *FileController {
     return &FileController{
         fileService: fileService,
+        permissions: permissions,
+        jobs:        jobs,
     }
 }
 
@@ -31,25 +51,245 @@ func respondJSON(w http.ResponseWriter, status int, data interface{}) {
     }
 }
 
-// handleError handles errors by sending an appropriate HTTP response.
+// handleError sends err as a JSON error response, in the shape every
+// other controller uses. Headers already set for a file being sent are
+// dropped so they do not describe the error instead.
 func handleError(w http.ResponseWriter, err error, status int) {
-    http.Error(w, err.Error(), status)
+    w.Header().Del("Content-Length")
+    w.Header().Del("Content-Disposition")
+    utils.RespondWithError(w, status, err.Error())
 }
 
+// currentUserID returns the ID of the authenticated user, or "" if none.
+func currentUserID(r *http.Request) string {
+    if user := utils.UserFromContext(r.Context()); user != nil {
+        return user.ID
+    }
+    return ""
+}
+
+// statusForError maps service errors onto HTTP status codes, falling back to
+// the given status for anything unrecognised.
+func statusForError(err error, fallback int) int {
+    switch {
+    case errors.Is(err, services.ErrPathOutsideRoot), errors.Is(err, services.ErrArchiveReadOnly),
+        errors.Is(err, services.ErrSpecialModeBits):
+        return http.StatusForbidden
+    case errors.Is(err, services.ErrShareNotFound), errors.Is(err, services.ErrTrashItemNotFound),
+        errors.Is(err, services.ErrVersionNotFound), errors.Is(err, os.ErrNotExist),
+        errors.Is(err, services.ErrShareLinkNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, services.ErrShareLinkExpired), errors.Is(err, services.ErrShareLinkExhausted):
+        return http.StatusGone
+    case errors.Is(err, services.ErrSharePassword):
+        return http.StatusUnauthorized
+    case errors.Is(err, services.ErrShareLinkMode):
+        return http.StatusForbidden
+    case errors.Is(err, services.ErrInvalidName), errors.Is(err, services.ErrInvalidCursor),
+        errors.Is(err, services.ErrInvalidPattern), errors.Is(err, services.ErrInvalidMode),
+        errors.Is(err, services.ErrUnknownAccount), errors.Is(err, services.ErrSubmitterRequired),
+        errors.Is(err, services.ErrInvalidEmail), errors.Is(err, services.ErrFlockUnsupported):
+        return http.StatusBadRequest
+    case errors.Is(err, services.ErrLocked):
+        return http.StatusLocked
+    case errors.Is(err, services.ErrLockNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, services.ErrDestinationExists):
+        return http.StatusConflict
+    case errors.Is(err, services.ErrFileTooLarge), errors.Is(err, services.ErrTextTooLarge):
+        return http.StatusRequestEntityTooLarge
+    case errors.Is(err, services.ErrPreconditionFailed):
+        return http.StatusPreconditionFailed
+    case errors.Is(err, services.ErrFileTypeNotAllowed), errors.Is(err, services.ErrThumbnailUnsupported),
+        errors.Is(err, services.ErrNotText):
+        return http.StatusUnsupportedMediaType
+    case errors.Is(err, services.ErrQuotaExceeded):
+        return http.StatusInsufficientStorage
+    case errors.Is(err, services.ErrArchiveTooLarge):
+        return http.StatusRequestEntityTooLarge
+    case errors.Is(err, services.ErrNothingToArchive), errors.Is(err, services.ErrJobNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, services.ErrExtractLimit), errors.Is(err, services.ErrArchiveTooManyMembers),
+        errors.Is(err, services.ErrImageTooLarge):
+        return http.StatusRequestEntityTooLarge
+    case errors.Is(err, services.ErrUnsupportedAlgorithm), errors.Is(err, services.ErrInvalidChecksum),
+        errors.Is(err, services.ErrChecksumOfFolder):
+        return http.StatusBadRequest
+    case errors.Is(err, services.ErrUnsupportedArchive), errors.Is(err, services.ErrUnsafeArchivePath),
+        errors.Is(err, services.ErrChecksumMismatch):
+        return http.StatusUnprocessableEntity
+    // http.MaxBytesReader reports an exceeded limit only through its message.
+    case err != nil && err.Error() == "http: request body too large":
+        return http.StatusRequestEntityTooLarge
+    }
+    return fallback
+}
+
+// ListFiles lists a folder. Listings can be sorted, filtered by name and
+// paged with limit and cursor; the cursor of the next page is returned in
+// the X-Next-Cursor header. With format=ndjson the whole folder is streamed
+// instead, one JSON record per line.
 func (fc *FileController) ListFiles(w http.ResponseWriter, r *http.Request) {
     path := r.URL.Query().Get("path")
     if path == "" {
         handleError(w, http.ErrMissingFile, http.StatusBadRequest)
         return
     }
+    opts, err := fc.listOptions(r)
+    if err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+    if wantsNDJSON(r) {
+        fc.streamFiles(w, r, path, opts)
+        return
+    }
 
-    files, err := fc.fileService.ListFiles(path)
+    page, err := fc.fileService.ListDirectory(path, opts)
     if err != nil {
-        handleError(w, err, http.StatusInternalServerError)
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
         return
     }
+    if page.NextCursor != "" {
+        w.Header().Set("X-Next-Cursor", page.NextCursor)
+    }
 
-    respondJSON(w, http.StatusOK, files)
+    // The listing changed last when the folder or its newest entry did.
+    var modTime time.Time
+    if folder, err := fc.fileService.StatFile(path); err == nil {
+        modTime = folder.LastModified
+    }
+    for _, file := range page.Files {
+        if file.LastModified.After(modTime) {
+            modTime = file.LastModified
+        }
+    }
+    respondCachedJSON(w, r, page.Files, modTime)
+}
+
+// ndjsonFlushEvery is how many records are written between flushes of a
+// streamed listing.
+const ndjsonFlushEvery = 500
+
+// wantsNDJSON reports whether a listing should be streamed as NDJSON.
+func wantsNDJSON(r *http.Request) bool {
+    return r.URL.Query().Get("format") == "ndjson" ||
+        strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// streamFiles writes a folder as newline-delimited JSON while it is read.
+// Errors after the first record can no longer change the status, so they
+// end the stream with an {"error": ...} record instead.
+func (fc *FileController) streamFiles(w http.ResponseWriter, r *http.Request, path string, opts services.ListOptions) {
+    query := r.URL.Query()
+    if query.Get("sort") != "" || query.Get("order") != "" || query.Get("limit") != "" || query.Get("cursor") != "" {
+        handleError(w, errors.New("streamed listings cannot be sorted or paged"), http.StatusBadRequest)
+        return
+    }
+
+    flusher, _ := w.(http.Flusher)
+    encoder := json.NewEncoder(w)
+    written := 0
+    err := fc.fileService.StreamDirectory(r.Context(), path, opts, func(file models.File) error {
+        if written == 0 {
+            w.Header().Set("Content-Type", "application/x-ndjson")
+            w.WriteHeader(http.StatusOK)
+        }
+        if err := encoder.Encode(file); err != nil {
+            return err
+        }
+        written++
+        if flusher != nil && written%ndjsonFlushEvery == 0 {
+            flusher.Flush()
+        }
+        return nil
+    })
+    if r.Context().Err() != nil {
+        // The client went away; nobody is listening for the rest.
+        return
+    }
+    if err != nil {
+        if written == 0 {
+            handleError(w, err, statusForError(err, http.StatusInternalServerError))
+            return
+        }
+        log.Printf("Streaming %s failed after %d entries: %v", path, written, err)
+        encoder.Encode(map[string]string{"error": err.Error()})
+        return
+    }
+    if written == 0 {
+        w.Header().Set("Content-Type", "application/x-ndjson")
+        w.WriteHeader(http.StatusOK)
+    }
+}
+
+// CountFiles returns how many entries a listing with the same filters has.
+func (fc *FileController) CountFiles(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    if path == "" {
+        handleError(w, http.ErrMissingFile, http.StatusBadRequest)
+        return
+    }
+    opts, err := fc.listOptions(r)
+    if err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+
+    count, err := fc.fileService.CountFiles(path, opts)
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return
+    }
+
+    respondCachedJSON(w, r, count, time.Time{})
+}
+
+// listOptions parses the sort, filter and paging parameters of a listing
+// and limits it to what the caller may see.
+func (fc *FileController) listOptions(r *http.Request) (services.ListOptions, error) {
+    query := r.URL.Query()
+    opts := services.ListOptions{
+        Query:     query.Get("q"),
+        Glob:      query.Get("glob"),
+        Cursor:    query.Get("cursor"),
+        DirsFirst: true,
+    }
+    var err error
+    if opts.Sort, err = services.ParseListSort(query.Get("sort")); err != nil {
+        return opts, err
+    }
+    switch query.Get("order") {
+    case "", "asc":
+    case "desc":
+        opts.Descending = true
+    default:
+        return opts, errors.New("order must be asc or desc")
+    }
+    if v := query.Get("dirsFirst"); v != "" {
+        if opts.DirsFirst, err = strconv.ParseBool(v); err != nil {
+            return opts, errors.New("invalid dirsFirst")
+        }
+    }
+    switch query.Get("detail") {
+    case "", "basic":
+    case "full":
+        opts.Detail = true
+    default:
+        return opts, errors.New("detail must be basic or full")
+    }
+    limit, err := parseInt64Param(query.Get("limit"))
+    if err != nil || limit < 0 {
+        return opts, errors.New("invalid limit")
+    }
+    opts.Limit = int(limit)
+
+    if user := utils.UserFromContext(r.Context()); user != nil {
+        if opts.Scope, err = fc.permissions.ScopeFor(user); err != nil {
+            return opts, err
+        }
+    }
+    return opts, nil
 }
 
 func (fc *FileController) CreateFolder(w http.ResponseWriter, r *http.Request) {
@@ -68,47 +308,126 @@ func (fc *FileController) CreateFolder(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    folder, err := fc.fileService.CreateFolder(folderData.Path, folderData.Name)
+    folder, err := fc.fileService.CreateFolder(folderData.Path, folderData.Name, currentUserID(r))
     if err != nil {
-        handleError(w, err, http.StatusInternalServerError)
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
         return
     }
 
     respondJSON(w, http.StatusCreated, folder)
 }
 
+// UploadFile streams a multipart upload straight to disk. The target folder
+// comes from the "path" query parameter or a "path" form field sent before the
+// "file" part, since the body is not buffered.
 func (fc *FileController) UploadFile(w http.ResponseWriter, r *http.Request) {
-    // Parse the multipart form
-    err := r.ParseMultipartForm(10 << 20) // Limit upload size to 10MB
+    policy, err := fc.fileService.UploadPolicy()
+    if err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return
+    }
+    if policy.MaxBytes > 0 {
+        if r.ContentLength > policy.MaxBytes+maxMultipartOverhead {
+            handleError(w, services.ErrFileTooLarge, http.StatusRequestEntityTooLarge)
+            return
+        }
+        r.Body = http.MaxBytesReader(w, r.Body, policy.MaxBytes+maxMultipartOverhead)
+    }
+
+    expected, err := services.ParseExpectedChecksum(r.Header.Get("X-Expected-Checksum"))
     if err != nil {
         handleError(w, err, http.StatusBadRequest)
         return
     }
 
-    // Retrieve the file from the form data
-    file, handler, err := r.FormFile("file")
+    reader, err := r.MultipartReader()
     if err != nil {
         handleError(w, err, http.StatusBadRequest)
         return
     }
-    defer file.Close()
 
-    // Retrieve the target path from the form data
-    targetPath := r.FormValue("path")
-    if targetPath == "" {
+    targetPath := r.URL.Query().Get("path")
+    for {
+        part, err := reader.NextPart()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            handleError(w, err, http.StatusBadRequest)
+            return
+        }
+
+        switch part.FormName() {
+        case "path":
+            if targetPath == "" {
+                value, err := io.ReadAll(io.LimitReader(part, maxPathField))
+                if err != nil {
+                    handleError(w, err, http.StatusBadRequest)
+                    return
+                }
+                targetPath = string(value)
+            }
+        case "file":
+            if targetPath == "" {
+                handleError(w, errors.New("path must be sent before the file"), http.StatusBadRequest)
+                return
+            }
+            if !authorize(w, r, fc.permissions, services.ActionWrite, targetPath) {
+                return
+            }
+            uploadedFile, err := fc.fileService.UploadFile(targetPath, part.FileName(), currentUserID(r), part, expected)
+            if err != nil {
+                handleError(w, err, statusForError(err, http.StatusInternalServerError))
+                return
+            }
+            respondJSON(w, http.StatusCreated, uploadedFile)
+            return
+        }
+        part.Close()
+    }
+    handleError(w, http.ErrMissingFile, http.StatusBadRequest)
+}
+
+// GetChecksum returns the md5, sha1, sha256 or blake2b checksum of a file.
+func (fc *FileController) GetChecksum(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    if path == "" {
         handleError(w, http.ErrMissingFile, http.StatusBadRequest)
         return
     }
 
-    // Call the file service to handle the file upload
-    uploadedFile, err := fc.fileService.UploadFile(targetPath, handler.Filename, file)
+    checksum, err := fc.fileService.Checksum(r.Context(), path, r.URL.Query().Get("algo"))
     if err != nil {
-        handleError(w, err, http.StatusInternalServerError)
+        if r.Context().Err() != nil {
+            // The client went away; nobody is listening for a response.
+            return
+        }
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
         return
     }
 
-    // Respond with the uploaded file details
-    respondJSON(w, http.StatusCreated, uploadedFile)
+    respondCachedJSON(w, r, checksum, time.Time{})
+}
+
+// authorize checks a permission that could not be checked by the route
+// middleware, writing a 403 response when it is denied. Responses have the
+// JSON shape of the middleware's.
+func authorize(w http.ResponseWriter, r *http.Request, permissions *services.PermissionService, action services.Action, p string) bool {
+    user := utils.UserFromContext(r.Context())
+    if user == nil {
+        utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
+        return false
+    }
+    if err := permissions.Check(user, action, p); err != nil {
+        var permErr *services.PermissionError
+        if errors.As(err, &permErr) {
+            utils.RespondWithError(w, http.StatusForbidden, permErr.Error())
+            return false
+        }
+        utils.RespondWithError(w, http.StatusInternalServerError, "Failed to evaluate permissions")
+        return false
+    }
+    return true
 }
 
 func (fc *FileController) RenameItem(w http.ResponseWriter, r *http.Request) {
@@ -127,18 +446,81 @@ func (fc *FileController) RenameItem(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    item, err := fc.fileService.RenameItem(renameData.Path, renameData.NewName)
+    item, err := fc.fileService.RenameItem(renameData.Path, renameData.NewName, currentUserID(r))
     if err != nil {
-        handleError(w, err, http.StatusInternalServerError)
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
         return
     }
 
     respondJSON(w, http.StatusOK, item)
 }
 
+// ChangeMode changes the permission bits of a file or folder tree.
+func (fc *FileController) ChangeMode(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Path      string `json:"path"`
+        Mode      string `json:"mode"`
+        Recursive bool   `json:"recursive"`
+        DryRun    bool   `json:"dryRun"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+    if req.Path == "" || req.Mode == "" {
+        handleError(w, errors.New("path and mode are required"), http.StatusBadRequest)
+        return
+    }
+
+    // Only admins may create setuid executables and the like.
+    opts := services.AttributeOptions{Recursive: req.Recursive, DryRun: req.DryRun, Actor: currentUserID(r),
+        SpecialBits: services.IsAdmin(utils.UserFromContext(r.Context()))}
+    result, err := fc.fileService.ChangeMode(req.Path, req.Mode, opts)
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return
+    }
+
+    respondJSON(w, http.StatusOK, result)
+}
+
+// ChangeOwner changes the owner and group of a file or folder tree. Only
+// admins may do this.
+func (fc *FileController) ChangeOwner(w http.ResponseWriter, r *http.Request) {
+    if !services.IsAdmin(utils.UserFromContext(r.Context())) {
+        handleError(w, errors.New("only admins can change ownership"), http.StatusForbidden)
+        return
+    }
+    var req struct {
+        Path      string `json:"path"`
+        Owner     string `json:"owner"`
+        Group     string `json:"group"`
+        Recursive bool   `json:"recursive"`
+        DryRun    bool   `json:"dryRun"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+    if req.Path == "" || (req.Owner == "" && req.Group == "") {
+        handleError(w, errors.New("path and owner or group are required"), http.StatusBadRequest)
+        return
+    }
+
+    opts := services.AttributeOptions{Recursive: req.Recursive, DryRun: req.DryRun, Actor: currentUserID(r)}
+    result, err := fc.fileService.ChangeOwner(req.Path, req.Owner, req.Group, opts)
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return
+    }
+
+    respondJSON(w, http.StatusOK, result)
+}
+
 func (fc *FileController) DeleteItem(w http.ResponseWriter, r *http.Request) {
     var deleteData struct {
-        Path string `json:"path"`
+        Path      string `json:"path"`
+        Permanent bool   `json:"permanent"`
     }
 
     if err := json.NewDecoder(r.Body).Decode(&deleteData); err != nil {
@@ -151,47 +533,175 @@ func (fc *FileController) DeleteItem(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    err := fc.fileService.DeleteItem(deleteData.Path)
+    // Deletes go to the trash; only admins may skip it.
+    if deleteData.Permanent {
+        if !services.IsAdmin(utils.UserFromContext(r.Context())) {
+            handleError(w, errors.New("permanent delete requires admin"), http.StatusForbidden)
+            return
+        }
+        if err := fc.fileService.DeletePermanently(deleteData.Path, currentUserID(r)); err != nil {
+            handleError(w, err, statusForError(err, http.StatusInternalServerError))
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    trashed, err := fc.fileService.DeleteItem(deleteData.Path, currentUserID(r))
     if err != nil {
-        handleError(w, err, http.StatusInternalServerError)
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
         return
     }
 
-    w.WriteHeader(http.StatusNoContent)
+    respondJSON(w, http.StatusOK, trashed)
 }
 
-// DownloadFile serves a file for preview or download, supporting preview and download modes.
-func (fc *FileController) DownloadFile(w http.ResponseWriter, r *http.Request) {
-    path := r.URL.Query().Get("path")
-    mode := r.URL.Query().Get("mode") // "preview" or "download"
-    if path == "" {
+// SearchFiles recursively searches a folder by name, extension, size and date.
+func (fc *FileController) SearchFiles(w http.ResponseWriter, r *http.Request) {
+    query := r.URL.Query()
+    opts := services.SearchOptions{
+        Path:  query.Get("path"),
+        Query: query.Get("q"),
+        Glob:  query.Get("glob"),
+        Regex: query.Get("regex"),
+    }
+    if opts.Path == "" {
         handleError(w, http.ErrMissingFile, http.StatusBadRequest)
         return
     }
+    if ext := query.Get("ext"); ext != "" {
+        opts.Extensions = strings.Split(ext, ",")
+    }
 
-    file, err := os.Open(path)
+    var err error
+    if opts.MinSize, err = parseInt64Param(query.Get("minSize")); err != nil {
+        handleError(w, errors.New("invalid minSize"), http.StatusBadRequest)
+        return
+    }
+    if opts.MaxSize, err = parseInt64Param(query.Get("maxSize")); err != nil {
+        handleError(w, errors.New("invalid maxSize"), http.StatusBadRequest)
+        return
+    }
+    if opts.ModifiedAfter, err = parseTimeParam(query.Get("modifiedAfter")); err != nil {
+        handleError(w, errors.New("invalid modifiedAfter"), http.StatusBadRequest)
+        return
+    }
+    if opts.ModifiedBefore, err = parseTimeParam(query.Get("modifiedBefore")); err != nil {
+        handleError(w, errors.New("invalid modifiedBefore"), http.StatusBadRequest)
+        return
+    }
+    offset, err := parseInt64Param(query.Get("offset"))
     if err != nil {
-        handleError(w, err, http.StatusNotFound)
+        handleError(w, errors.New("invalid offset"), http.StatusBadRequest)
         return
     }
-    defer file.Close()
+    limit, err := parseInt64Param(query.Get("limit"))
+    if err != nil {
+        handleError(w, errors.New("invalid limit"), http.StatusBadRequest)
+        return
+    }
+    opts.Offset, opts.Limit = int(offset), int(limit)
 
-    ext := filepath.Ext(path)
-    mimeType := mime.TypeByExtension(ext)
-    if mimeType == "" {
-        mimeType = "application/octet-stream"
+    if user := utils.UserFromContext(r.Context()); user != nil {
+        if opts.Scope, err = fc.permissions.ScopeFor(user); err != nil {
+            handleError(w, err, http.StatusInternalServerError)
+            return
+        }
+    }
+
+    result, err := fc.fileService.Search(r.Context(), opts)
+    if err != nil {
+        if r.Context().Err() != nil {
+            // The client went away; nobody is listening for a response.
+            return
+        }
+        handleError(w, err, statusForError(err, http.StatusBadRequest))
+        return
+    }
+
+    respondCachedJSON(w, r, result, time.Time{})
+}
+
+// parseInt64Param parses an optional integer query parameter.
+func parseInt64Param(v string) (int64, error) {
+    if v == "" {
+        return 0, nil
+    }
+    return strconv.ParseInt(v, 10, 64)
+}
+
+// parseTimeParam parses an optional RFC 3339 timestamp or YYYY-MM-DD date.
+func parseTimeParam(v string) (time.Time, error) {
+    if v == "" {
+        return time.Time{}, nil
+    }
+    if t, err := time.Parse(time.RFC3339, v); err == nil {
+        return t, nil
+    }
+    return time.Parse("2006-01-02", v)
+}
+
+// transferRequest is the payload shared by CopyItem and MoveItem.
+type transferRequest struct {
+    SourcePath      string `json:"sourcePath"`
+    DestinationPath string `json:"destinationPath"`
+    Conflict        string `json:"conflict"`
+}
+
+// CopyItem copies a file or folder into a destination folder.
+func (fc *FileController) CopyItem(w http.ResponseWriter, r *http.Request) {
+    fc.transfer(w, r, fc.fileService.CopyItem)
+}
+
+// MoveItem moves a file or folder into a destination folder, across shares if needed.
+func (fc *FileController) MoveItem(w http.ResponseWriter, r *http.Request) {
+    fc.transfer(w, r, fc.fileService.MoveItem)
+}
+
+// transfer runs a copy or move on behalf of the caller, whose locks the
+// file service lets through.
+func (fc *FileController) transfer(w http.ResponseWriter, r *http.Request, op func(string, string, services.ConflictPolicy, string) (*models.File, error)) {
+    var req transferRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+
+    if req.SourcePath == "" || req.DestinationPath == "" {
+        handleError(w, http.ErrMissingFile, http.StatusBadRequest)
+        return
+    }
+
+    policy, err := services.ParseConflictPolicy(req.Conflict)
+    if err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+
+    item, err := op(req.SourcePath, req.DestinationPath, policy, currentUserID(r))
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return
+    }
+
+    respondJSON(w, http.StatusOK, item)
+}
+
+// DownloadFile serves a file for preview or download, supporting preview and
+// download modes, conditional requests and ranges.
+func (fc *FileController) DownloadFile(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    mode := r.URL.Query().Get("mode") // "preview" or "download"
+    if path == "" {
+        handleError(w, http.ErrMissingFile, http.StatusBadRequest)
+        return
     }
-    w.Header().Set("Content-Type", mimeType)
 
     disposition := "inline"
     if mode == "download" {
         disposition = "attachment"
     }
-    w.Header().Set("Content-Disposition", disposition+"; filename=\""+filepath.Base(path)+"\"")
-
-    if _, err := io.Copy(w, file); err != nil {
-        handleError(w, err, http.StatusInternalServerError)
-    }
+    serveFile(w, r, fc.fileService, path, disposition)
 }
 
 // PreviewFile serves a file for browser preview (images, pdf, text, etc.).
@@ -202,37 +712,29 @@ func (fc *FileController) PreviewFile(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    file, err := os.Open(path)
+    file, info, err := fc.fileService.OpenFile(path)
     if err != nil {
-        handleError(w, err, http.StatusNotFound)
+        handleError(w, err, statusForError(err, http.StatusNotFound))
         return
     }
     defer file.Close()
-
-    ext := filepath.Ext(path)
-    mimeType := mime.TypeByExtension(ext)
-
-    // Fallback for common text files and PDF
-    switch ext {
-    case ".txt", ".log", ".md", ".json", ".yaml", ".yml", ".csv", ".tsv", ".xml", ".ini", ".conf":
-        mimeType = "text/plain"
-    case ".pdf":
-        mimeType = "application/pdf"
+    if notModified(w, r, services.FileETag(info.ModTime(), info.Size()), info.ModTime()) {
+        return
     }
 
-    if mimeType == "" {
-        mimeType = "application/octet-stream"
+    mimeType, content, err := fc.fileService.ContentTypes().Sniff(info.Name(), file)
+    if err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return
     }
 
-    w.Header().Set("Content-Type", mimeType)
-    w.Header().Set("Content-Disposition", "inline; filename=\""+filepath.Base(path)+"\"")
-
-    // Optional: limit preview for large text files
-    if mimeType == "text/plain" {
-        const maxPreviewSize = 2 << 20 // 2 MB
-        buf := make([]byte, maxPreviewSize)
-        n, err := file.Read(buf)
-        if err != nil && err != io.EOF {
+    // Text of any kind is shown as plain text, limited for large files. The
+    // ETag is what saving an edit of it expects in If-Match.
+    if services.IsTextType(mimeType) {
+        setFileHeaders(w, "text/plain; charset=utf-8", info.Name(), "inline")
+        buf := make([]byte, services.MaxTextSize)
+        n, err := io.ReadFull(content, buf)
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
             handleError(w, err, http.StatusInternalServerError)
             return
         }
@@ -241,9 +743,36 @@ func (fc *FileController) PreviewFile(w http.ResponseWriter, r *http.Request) {
     }
 
     // For PDFs or anything else
-    if _, err := io.Copy(w, file); err != nil {
-        handleError(w, err, http.StatusInternalServerError)
+    setFileHeaders(w, mimeType, info.Name(), "inline")
+    sendContent(w, r, file, info, content)
+}
+
+// SaveFileContent handles PUT /api/files/content?path=, replacing a text
+// file with the request body. If-Match must carry the ETag the edited text
+// was previewed with, so edits based on an outdated version fail with 412.
+func (fc *FileController) SaveFileContent(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    if path == "" {
+        handleError(w, http.ErrMissingFile, http.StatusBadRequest)
+        return
     }
+    etag := strings.TrimSpace(r.Header.Get("If-Match"))
+    if etag == "" {
+        handleError(w, errors.New("If-Match header is required"), http.StatusPreconditionRequired)
+        return
+    }
+    if r.ContentLength > services.MaxTextSize {
+        handleError(w, services.ErrTextTooLarge, http.StatusRequestEntityTooLarge)
+        return
+    }
+
+    saved, err := fc.fileService.SaveContent(path, etag, currentUserID(r), r.Body)
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return
+    }
+    w.Header().Set("ETag", services.FileETag(saved.LastModified, saved.Size))
+    respondJSON(w, http.StatusOK, saved)
 }
 
 // GetFileInfo returns metadata for a single file.
@@ -254,20 +783,12 @@ func (fc *FileController) GetFileInfo(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    info, err := os.Stat(path)
+    fileInfo, err := fc.fileService.GetFileInfo(path)
     if err != nil {
-        handleError(w, err, http.StatusNotFound)
+        handleError(w, err, statusForError(err, http.StatusNotFound))
         return
     }
-
-    fileInfo := map[string]interface{}{
-        "name":         info.Name(),
-        "path":         path,
-        "is_dir":       info.IsDir(),
-        "size":         info.Size(),
-        "lastModified": info.ModTime(),
-    }
-    respondJSON(w, http.StatusOK, fileInfo)
+    respondCachedJSON(w, r, fileInfo, fileInfo.LastModified)
 }
 
 // StreamFile supports HTTP range requests for large files (video/audio streaming).
@@ -278,21 +799,196 @@ func (fc *FileController) StreamFile(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    file, err := os.Open(path)
+    serveFile(w, r, fc.fileService, path, "")
+}
+
+// serveFile sends a file with HTTP range and conditional request support. A
+// non-empty disposition ("inline" or "attachment") sets Content-Disposition.
+func serveFile(w http.ResponseWriter, r *http.Request, fileService *services.FileService, path, disposition string) {
+    file, info, err := fileService.OpenFile(path)
     if err != nil {
-        handleError(w, err, http.StatusNotFound)
+        handleError(w, err, statusForError(err, http.StatusNotFound))
         return
     }
     defer file.Close()
+    if notModified(w, r, services.FileETag(info.ModTime(), info.Size()), info.ModTime()) {
+        return
+    }
+
+    mimeType, content, err := fileService.ContentTypes().Sniff(info.Name(), file)
+    if err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return
+    }
+    setFileHeaders(w, mimeType, info.Name(), disposition)
+    sendContent(w, r, file, info, content)
+}
+
+// setFileHeaders sets the type and, unless disposition is empty, the
+// disposition of file content. Browsers may not second-guess the type, and
+// content that could run scripts on the app's origin is never rendered:
+// inline it is shown as plain text, downloads keep their type but are
+// sandboxed.
+func setFileHeaders(w http.ResponseWriter, mimeType, name, disposition string) {
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    if activeContent(mimeType) {
+        w.Header().Set("Content-Security-Policy", "sandbox")
+        if disposition != "attachment" {
+            mimeType = "text/plain; charset=utf-8"
+        }
+    }
+    w.Header().Set("Content-Type", mimeType)
+    if disposition != "" {
+        w.Header().Set("Content-Disposition", disposition+"; filename=\""+name+"\"")
+    }
+}
+
+// activeContent reports whether browsers may run scripts in content of the
+// given type when displaying it: HTML, SVG and XML of any kind.
+func activeContent(mimeType string) bool {
+    mediaType, _, err := mime.ParseMediaType(mimeType)
+    if err != nil {
+        return true
+    }
+    switch mediaType {
+    case "text/html", "text/xml", "text/xsl", "application/xml":
+        return true
+    }
+    return strings.HasSuffix(mediaType, "+xml")
+}
+
+// sendContent sends an opened file whose headers are set. content is what is
+// left of file after sniffing its type.
+func sendContent(w http.ResponseWriter, r *http.Request, file io.Reader, info os.FileInfo, content io.Reader) {
+    // Members of compressed archives cannot seek and are sent whole.
+    seeker, ok := file.(io.ReadSeeker)
+    if !ok {
+        w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+        io.Copy(w, content)
+        return
+    }
+    if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return
+    }
+    http.ServeContent(w, r, info.Name(), info.ModTime(), seeker)
+}
+
+// ArchiveFiles streams the selected files and folders as a ZIP or tar.gz
+// built on the fly. Every selected path must be readable or lead to readable
+// entries; unreadable entries inside folders are left out of the archive.
+func (fc *FileController) ArchiveFiles(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Paths  []string `json:"paths"`
+        Format string   `json:"format"`
+        Name   string   `json:"name"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Paths) == 0 {
+        handleError(w, errors.New("paths are required"), http.StatusBadRequest)
+        return
+    }
+    format, err := services.ParseArchiveFormat(req.Format)
+    if err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
 
-    http.ServeContent(w, r, filepath.Base(path), fileStatModTime(file), file)
+    user := utils.UserFromContext(r.Context())
+    if user == nil {
+        utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
+        return
+    }
+    for _, p := range req.Paths {
+        if err := fc.permissions.CheckList(user, p); err != nil {
+            var permErr *services.PermissionError
+            if errors.As(err, &permErr) {
+                utils.RespondWithError(w, http.StatusForbidden, permErr.Error())
+                return
+            }
+            status, message := statusForError(err, http.StatusInternalServerError), err.Error()
+            if status == http.StatusInternalServerError {
+                message = "Failed to evaluate permissions"
+            }
+            utils.RespondWithError(w, status, message)
+            return
+        }
+    }
+    scope, err := fc.permissions.ScopeFor(user)
+    if err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return
+    }
+
+    writeArchive(w, r, fc.fileService, req.Paths, scope, format, archiveName(req.Name, req.Paths))
 }
 
-// Helper to get file mod time for ServeContent
-func fileStatModTime(file *os.File) (modTime time.Time) {
-    fi, err := file.Stat()
-    if err == nil {
-        modTime = fi.ModTime()
+// writeArchive streams the entries beneath paths that scope may read as an
+// archive download named name.
+func writeArchive(w http.ResponseWriter, r *http.Request, fileService *services.FileService, paths []string, scope *services.AccessScope, format services.ArchiveFormat, name string) {
+    plan, err := fileService.PlanArchive(r.Context(), paths, scope, services.DefaultArchiveLimits)
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return
     }
-    return
-}
\ No newline at end of file
+
+    w.Header().Set("Content-Type", format.ContentType())
+    w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"."+format.Extension()+"\"")
+    if err := plan.Write(r.Context(), w, format); err != nil && r.Context().Err() == nil {
+        log.Printf("Archive download of %v failed: %v", paths, err)
+    }
+}
+
+// archiveName picks the download name: the requested one, the single
+// selected item's name, or "download".
+func archiveName(requested string, paths []string) string {
+    name := requested
+    if name == "" && len(paths) == 1 {
+        name = filepath.Base(filepath.FromSlash(paths[0]))
+    }
+    name = strings.Map(func(r rune) rune {
+        if r == '"' || r == '/' || r == '\\' || r < ' ' {
+            return -1
+        }
+        return r
+    }, name)
+    if name == "" || name == "." {
+        name = "download"
+    }
+    return name
+}
+
+// ExtractArchive starts extracting an archive into a folder, by default the
+// one containing it, and answers 202 with the job to poll for progress.
+func (fc *FileController) ExtractArchive(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Path        string `json:"path"`
+        Destination string `json:"destination"`
+        Conflict    string `json:"conflict"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+        handleError(w, errors.New("path is required"), http.StatusBadRequest)
+        return
+    }
+    policy, err := services.ParseConflictPolicy(req.Conflict)
+    if err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+    if req.Destination == "" {
+        req.Destination = path.Dir(req.Path)
+    }
+    if !authorize(w, r, fc.permissions, services.ActionWrite, req.Destination) {
+        return
+    }
+
+    owner := currentUserID(r)
+    job := fc.jobs.Start("extract", owner, func(ctx context.Context, progress services.ProgressReporter) (interface{}, error) {
+        result, err := fc.fileService.ExtractArchive(ctx, req.Path, req.Destination, policy, owner, services.DefaultExtractLimits, progress)
+        if err != nil {
+            log.Printf("Extracting %s failed: %v", req.Path, err)
+            return nil, err
+        }
+        return result, nil
+    })
+    respondJSON(w, http.StatusAccepted, job)
+}
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+    "net/http"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+
+    "github.com/gorilla/mux"
+)
+
+// JobController reports on and cancels background jobs. Users see their own
+// jobs; admins see everyone's.
+type JobController struct {
+    jobs *services.JobService
+}
+
+// NewJobController creates a new JobController.
+func NewJobController(jobs *services.JobService) *JobController {
+    return &JobController{jobs: jobs}
+}
+
+// visibleJob loads the job named in the URL if the caller started it.
+// Jobs of other users are reported as missing.
+func (jc *JobController) visibleJob(w http.ResponseWriter, r *http.Request) (*models.Job, bool) {
+    user := utils.UserFromContext(r.Context())
+    job, err := jc.jobs.Get(mux.Vars(r)["id"])
+    if err == nil && (user == nil || (job.Owner != user.ID && !services.IsAdmin(user))) {
+        err = services.ErrJobNotFound
+    }
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return nil, false
+    }
+    return &job, true
+}
+
+// ListJobs handles GET /api/jobs.
+func (jc *JobController) ListJobs(w http.ResponseWriter, r *http.Request) {
+    user := utils.UserFromContext(r.Context())
+    owner := currentUserID(r)
+    if services.IsAdmin(user) {
+        owner = ""
+    }
+    utils.RespondWithJSON(w, http.StatusOK, jc.jobs.List(owner))
+}
+
+// GetJob handles GET /api/jobs/{id}.
+func (jc *JobController) GetJob(w http.ResponseWriter, r *http.Request) {
+    job, ok := jc.visibleJob(w, r)
+    if !ok {
+        return
+    }
+    utils.RespondWithJSON(w, http.StatusOK, job)
+}
+
+// CancelJob handles DELETE /api/jobs/{id}. The job stops at its next check
+// and is then reported as cancelled.
+func (jc *JobController) CancelJob(w http.ResponseWriter, r *http.Request) {
+    job, ok := jc.visibleJob(w, r)
+    if !ok {
+        return
+    }
+    if err := jc.jobs.Cancel(job.ID); err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+// LockController takes and releases advisory locks on files and folders.
+// Locked paths cannot be uploaded to, renamed, moved or deleted by anyone
+// but the lock's owner and admins.
+type LockController struct {
+    fileService *services.FileService
+}
+
+// NewLockController creates a new LockController.
+func NewLockController(fileService *services.FileService) *LockController {
+    return &LockController{
+        fileService: fileService,
+    }
+}
+
+// GetLock handles GET /api/files/lock?path=.
+func (lc *LockController) GetLock(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    if path == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path is required")
+        return
+    }
+    lock, err := lc.fileService.GetLock(path)
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    utils.RespondWithJSON(w, http.StatusOK, lock)
+}
+
+// LockItem handles POST /api/files/lock. Locking a path the caller already
+// holds renews the lock.
+func (lc *LockController) LockItem(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Path     string `json:"path"`
+        Duration int64  `json:"duration"` // seconds
+        Note     string `json:"note"`
+        Flock    bool   `json:"flock"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path is required")
+        return
+    }
+    user := utils.UserFromContext(r.Context())
+    if user == nil {
+        utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
+        return
+    }
+    ownerName := user.Name
+    if ownerName == "" {
+        ownerName = user.Email
+    }
+
+    lock, err := lc.fileService.LockItem(req.Path, user.ID, ownerName, services.LockOptions{
+        Duration: time.Duration(req.Duration) * time.Second,
+        Note:     req.Note,
+        Flock:    req.Flock,
+    })
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusBadRequest), err.Error())
+        return
+    }
+    utils.RespondWithJSON(w, http.StatusOK, lock)
+}
+
+// UnlockItem handles DELETE /api/files/lock. Admins may break the locks of
+// other users.
+func (lc *LockController) UnlockItem(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Path string `json:"path"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path is required")
+        return
+    }
+    user := utils.UserFromContext(r.Context())
+    if err := lc.fileService.UnlockItem(req.Path, currentUserID(r), services.IsAdmin(user)); err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,510 @@
+package controllers
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "mime/multipart"
+    "net/http"
+    "os"
+    "path"
+    "time"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+
+    "github.com/gorilla/mux"
+)
+
+// ShareLinkController creates and revokes share links and serves the
+// public /s/{token} route they point to. Every access through a link is
+// checked against the current permissions of the user who created it.
+type ShareLinkController struct {
+    shareLinks  *services.ShareLinkService
+    fileService *services.FileService
+    permissions *services.PermissionService
+    authService *services.AuthService
+}
+
+// NewShareLinkController creates a new ShareLinkController.
+func NewShareLinkController(shareLinks *services.ShareLinkService, fileService *services.FileService, permissions *services.PermissionService, authService *services.AuthService) *ShareLinkController {
+    return &ShareLinkController{
+        shareLinks:  shareLinks,
+        fileService: fileService,
+        permissions: permissions,
+        authService: authService,
+    }
+}
+
+// sharedItem is what visitors of a link learn about it; the path it points
+// to stays hidden.
+type sharedItem struct {
+    Name          string    `json:"name"`
+    IsDir         bool      `json:"is_dir"`
+    Mode          string    `json:"mode"`
+    ExpiresAt     time.Time `json:"expiresAt"`
+    DownloadsLeft *int      `json:"downloadsLeft,omitempty"` // absent when unlimited
+    AllowedTypes  []string  `json:"allowedTypes,omitempty"`  // uploads; absent when any type goes
+    MaxFileSize   int64     `json:"maxFileSize,omitempty"`   // uploads; absent when unlimited
+}
+
+// CreateShareLink handles POST /api/shares. Read links need read access to
+// the path, upload and file request links write access.
+func (sc *ShareLinkController) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Path         string `json:"path"`
+        Mode         string `json:"mode"`
+        ExpiresIn    int64  `json:"expiresIn"` // seconds
+        Password     string `json:"password"`
+        MaxDownloads int    `json:"maxDownloads"`
+        MaxFileSize  int64  `json:"maxFileSize"` // bytes, request links only
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+        handleError(w, errors.New("path is required"), http.StatusBadRequest)
+        return
+    }
+
+    action := services.ActionWrite
+    if req.Mode == "" || req.Mode == models.ShareLinkRead {
+        action = services.ActionRead
+    }
+    if !authorize(w, r, sc.permissions, action, req.Path) {
+        return
+    }
+    info, err := sc.fileService.GetFileInfo(req.Path)
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusNotFound))
+        return
+    }
+
+    link, err := sc.shareLinks.Create(info.Path, info.IsDir, currentUserID(r), services.ShareLinkOptions{
+        Mode:         req.Mode,
+        ExpiresIn:    time.Duration(req.ExpiresIn) * time.Second,
+        Password:     req.Password,
+        MaxDownloads: req.MaxDownloads,
+        MaxFileSize:  req.MaxFileSize,
+    })
+    if err != nil {
+        handleError(w, err, http.StatusBadRequest)
+        return
+    }
+    respondJSON(w, http.StatusCreated, link)
+}
+
+// ListShareLinks handles GET /api/shares: the caller's active links.
+func (sc *ShareLinkController) ListShareLinks(w http.ResponseWriter, r *http.Request) {
+    respondJSON(w, http.StatusOK, sc.shareLinks.List(currentUserID(r)))
+}
+
+// ListAllShareLinks handles GET /api/admin/shares: every active link.
+func (sc *ShareLinkController) ListAllShareLinks(w http.ResponseWriter, r *http.Request) {
+    respondJSON(w, http.StatusOK, sc.shareLinks.List(""))
+}
+
+// RevokeShareLink handles DELETE /api/shares/{id}. Users revoke their own
+// links; admins may revoke any. Links of other users are reported missing.
+func (sc *ShareLinkController) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+    user := utils.UserFromContext(r.Context())
+    link, err := sc.shareLinks.Get(id)
+    if err == nil && (user == nil || (link.CreatedBy != user.ID && !services.IsAdmin(user))) {
+        err = services.ErrShareLinkNotFound
+    }
+    if err == nil {
+        err = sc.shareLinks.Revoke(id, currentUserID(r))
+    }
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Access handles GET and HEAD /s/{token}. A file link downloads the file.
+// A folder link lists the folder, or with ?path= a file or subfolder inside
+// it; ?format=zip or tar.gz downloads the folder as an archive. Upload
+// and file request links describe themselves, or render an upload form for
+// browsers. Passwords are sent in the X-Share-Password header, or posted
+// from the password form browsers get, never in the URL where logs and
+// histories would keep them.
+func (sc *ShareLinkController) Access(w http.ResponseWriter, r *http.Request) {
+    password := r.Header.Get(sharePasswordHeader)
+    if password == "" && isPasswordForm(r) {
+        password = r.PostFormValue("password")
+    }
+    link, scope, ok := sc.open(w, r, "open", password)
+    if !ok {
+        return
+    }
+    if link.Mode != models.ShareLinkRead {
+        sc.shareLinks.RecordAccess(link, "open", r.RemoteAddr, nil)
+        if wantsHTML(r) {
+            sc.renderForm(w, link, password, http.StatusOK, nil, nil)
+            return
+        }
+        item, err := sc.describeLink(link)
+        if err != nil {
+            handleError(w, err, http.StatusInternalServerError)
+            return
+        }
+        respondJSON(w, http.StatusOK, map[string]interface{}{"link": item})
+        return
+    }
+
+    rel := path.Clean("/" + r.URL.Query().Get("path"))
+    target := link.Path
+    if link.IsDir {
+        target = path.Join(link.Path, rel)
+    } else if rel != "/" {
+        sc.fail(w, r, link, "open "+rel, services.ErrShareLinkNotFound)
+        return
+    }
+    if !scope.CanSee(target, link.IsDir) || !scope.CanSee(sc.fileService.RealPath(target), link.IsDir) {
+        sc.fail(w, r, link, "open "+rel, services.ErrShareLinkNotFound)
+        return
+    }
+
+    if format := r.URL.Query().Get("format"); format != "" && link.IsDir {
+        archiveFormat, err := services.ParseArchiveFormat(format)
+        if err != nil {
+            handleError(w, err, http.StatusBadRequest)
+            return
+        }
+        if !sc.consume(w, r, link, "archive "+rel) {
+            return
+        }
+        writeArchive(w, r, sc.fileService, []string{target}, scope, archiveFormat, archiveName("", []string{target}))
+        return
+    }
+
+    info, err := sc.fileService.GetFileInfo(target)
+    if err != nil {
+        sc.fail(w, r, link, "open "+rel, err)
+        return
+    }
+    if info.IsDir {
+        page, err := sc.fileService.ListDirectory(target, services.ListOptions{DirsFirst: true, Scope: scope})
+        if err != nil {
+            sc.fail(w, r, link, "list "+rel, err)
+            return
+        }
+        files := make([]models.File, len(page.Files))
+        for i, file := range page.Files {
+            file.Path = path.Join(rel, file.Name)
+            files[i] = file
+        }
+        item, err := sc.describeLink(link)
+        if err != nil {
+            handleError(w, err, http.StatusInternalServerError)
+            return
+        }
+        sc.shareLinks.RecordAccess(link, "list "+rel, r.RemoteAddr, nil)
+        respondJSON(w, http.StatusOK, map[string]interface{}{
+            "link":  item,
+            "path":  rel,
+            "files": files,
+        })
+        return
+    }
+    if !scope.Can(services.ActionRead, target) || !scope.Can(services.ActionRead, sc.fileService.RealPath(target)) {
+        sc.fail(w, r, link, "download "+rel, services.ErrShareLinkNotFound)
+        return
+    }
+    // Revalidating a copy the visitor already has is not a download, nor is
+    // fetching more of a download already counted.
+    if notModified(w, r, services.FileETag(info.LastModified, info.Size), info.LastModified) {
+        return
+    }
+    if r.Method != http.MethodHead && !sc.resumesDownload(r, link) {
+        session, err := sc.shareLinks.StartDownload(link.ID, rel)
+        sc.shareLinks.RecordAccess(link, "download "+rel, r.RemoteAddr, err)
+        if err != nil {
+            handleError(w, err, statusForError(err, http.StatusInternalServerError))
+            return
+        }
+        setDownloadSession(w, r, session)
+    }
+    serveFile(w, r, sc.fileService, target, "attachment")
+}
+
+// Upload handles POST /s/{token} for upload and file request links: every
+// "file" part of the multipart body is stored in the linked folder, owned by
+// the link's creator. File requests need the submitter's "name" (and
+// optionally "email") fields before the files, which then go to the
+// submitter's own subfolder. Browsers posting the link's form get an HTML
+// page back. A password form posted here opens the link as Access does.
+func (sc *ShareLinkController) Upload(w http.ResponseWriter, r *http.Request) {
+    if isPasswordForm(r) {
+        sc.Access(w, r)
+        return
+    }
+    parts := readUploadParts(r)
+    password := r.Header.Get(sharePasswordHeader)
+    if password == "" {
+        password = parts.password
+    }
+    link, scope, ok := sc.open(w, r, "upload", password)
+    if !ok {
+        return
+    }
+    if link.Mode == models.ShareLinkRead {
+        sc.fail(w, r, link, "upload", services.ErrShareLinkMode)
+        return
+    }
+    if !scope.Can(services.ActionWrite, link.Path) || !scope.Can(services.ActionWrite, sc.fileService.RealPath(link.Path)) {
+        sc.fail(w, r, link, "upload", services.ErrShareLinkNotFound)
+        return
+    }
+
+    uploaded, err := sc.receive(r, link, parts)
+    status := http.StatusCreated
+    if err != nil {
+        status = statusForError(err, http.StatusBadRequest)
+        err = publicError(err, status)
+    }
+    if wantsHTML(r) {
+        sc.renderForm(w, link, password, status, uploaded, err)
+        return
+    }
+    if err != nil {
+        handleError(w, err, status)
+        return
+    }
+    respondJSON(w, status, uploaded)
+}
+
+// uploadParts reads the parts of a multipart upload. The upload form sends
+// the link's password as its first field, so a leading "password" part is
+// read ahead, before the link is opened.
+type uploadParts struct {
+    reader   *multipart.Reader
+    next     *multipart.Part // part read ahead, if any
+    err      error
+    password string
+}
+
+func readUploadParts(r *http.Request) *uploadParts {
+    parts := &uploadParts{}
+    if parts.reader, parts.err = r.MultipartReader(); parts.err != nil {
+        return parts
+    }
+    part, err := parts.reader.NextPart()
+    if err != nil {
+        parts.err = err
+        return parts
+    }
+    if part.FormName() != "password" {
+        parts.next = part
+        return parts
+    }
+    value, err := io.ReadAll(io.LimitReader(part, maxSubmitterField))
+    part.Close()
+    if err != nil {
+        parts.err = err
+        return parts
+    }
+    parts.password = string(value)
+    return parts
+}
+
+// NextPart returns the next part not read yet.
+func (p *uploadParts) NextPart() (*multipart.Part, error) {
+    if p.err != nil {
+        return nil, p.err
+    }
+    if part := p.next; part != nil {
+        p.next = nil
+        return part, nil
+    }
+    return p.reader.NextPart()
+}
+
+// receive stores the files of an upload through link, returning those
+// stored before any error.
+func (sc *ShareLinkController) receive(r *http.Request, link *models.ShareLink, parts *uploadParts) ([]models.File, error) {
+    uploaded := []models.File{}
+    var name, email string
+    for {
+        part, err := parts.NextPart()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return uploaded, err
+        }
+
+        switch part.FormName() {
+        case "name", "email":
+            value, err := io.ReadAll(io.LimitReader(part, maxSubmitterField))
+            if err != nil {
+                return uploaded, err
+            }
+            if part.FormName() == "name" {
+                name = string(value)
+            } else {
+                email = string(value)
+            }
+        case "file":
+            if part.FileName() == "" {
+                // Browsers send an empty part when no file was chosen.
+                break
+            }
+            file, operation, err := sc.store(link, part, name, email)
+            sc.shareLinks.RecordAccess(link, operation, r.RemoteAddr, err)
+            if err != nil {
+                return uploaded, err
+            }
+            uploaded = append(uploaded, *file)
+        }
+        part.Close()
+    }
+    if len(uploaded) == 0 {
+        return uploaded, http.ErrMissingFile
+    }
+    return uploaded, nil
+}
+
+// store saves one uploaded file, returning it with its path relative to
+// the link and a description of the upload for the audit log.
+func (sc *ShareLinkController) store(link *models.ShareLink, part *multipart.Part, name, email string) (*models.File, string, error) {
+    operation := "upload " + part.FileName()
+    if link.Mode != models.ShareLinkRequest {
+        file, err := sc.fileService.ReceiveFile(link.Path, part.FileName(), link.CreatedBy, part)
+        if err != nil {
+            return nil, operation, err
+        }
+        file.Path = path.Join("/", file.Name)
+        return file, operation, nil
+    }
+
+    submitter, err := services.NewSubmitter(name, email)
+    if err != nil {
+        return nil, operation, err
+    }
+    operation += " by " + submitter.String()
+    file, err := sc.fileService.SubmitFile(link.Path, submitter, part.FileName(), link.CreatedBy, part, link.MaxFileSize)
+    if err != nil {
+        return nil, operation, err
+    }
+    file.Path = path.Join("/", submitter.Folder(), file.Name)
+    return file, operation, nil
+}
+
+// open resolves the link named in the URL with password along with the
+// access scope of its creator, writing the error response and audit entry
+// on failure. Browsers are asked for a missing or wrong password.
+func (sc *ShareLinkController) open(w http.ResponseWriter, r *http.Request, operation, password string) (*models.ShareLink, *services.AccessScope, bool) {
+    link, err := sc.shareLinks.Resolve(mux.Vars(r)["token"], password)
+    // A download started before the link ran out may still be finished.
+    if errors.Is(err, services.ErrShareLinkExhausted) && sc.resumesDownload(r, link) {
+        err = nil
+    }
+    if err != nil {
+        if link != nil {
+            sc.shareLinks.RecordAccess(link, operation, r.RemoteAddr, err)
+        }
+        if errors.Is(err, services.ErrSharePassword) && wantsHTML(r) {
+            renderPasswordForm(w, password != "")
+            return nil, nil, false
+        }
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return nil, nil, false
+    }
+
+    // Links die with their creator's account.
+    creator, err := sc.authService.UserByID(link.CreatedBy)
+    if err != nil {
+        sc.fail(w, r, link, operation, services.ErrShareLinkNotFound)
+        return nil, nil, false
+    }
+    scope, err := sc.permissions.ScopeFor(creator)
+    if err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return nil, nil, false
+    }
+    // Symlinks inside the linked folder must not lead visitors out of it.
+    return link, scope.Within(link.Path, sc.fileService.RealPath(link.Path)), true
+}
+
+// consume counts a download, writing the error response on failure.
+func (sc *ShareLinkController) consume(w http.ResponseWriter, r *http.Request, link *models.ShareLink, operation string) bool {
+    err := sc.shareLinks.ConsumeDownload(link.ID)
+    sc.shareLinks.RecordAccess(link, operation, r.RemoteAddr, err)
+    if err != nil {
+        handleError(w, err, statusForError(err, http.StatusInternalServerError))
+        return false
+    }
+    return true
+}
+
+// fail audits a failed access and writes its error response.
+func (sc *ShareLinkController) fail(w http.ResponseWriter, r *http.Request, link *models.ShareLink, operation string, err error) {
+    sc.shareLinks.RecordAccess(link, operation, r.RemoteAddr, err)
+    status := statusForError(err, http.StatusInternalServerError)
+    handleError(w, publicError(err, status), status)
+}
+
+// publicError is the error shown to link visitors. File system and lock
+// errors name server paths, so visitors only get their status text.
+func publicError(err error, status int) error {
+    var pathErr *os.PathError
+    if errors.As(err, &pathErr) || errors.Is(err, services.ErrLocked) {
+        return errors.New(http.StatusText(status))
+    }
+    return err
+}
+
+// downloadSessionHeader carries the session of a counted download for
+// clients without cookies.
+const downloadSessionHeader = "X-Share-Session"
+
+// resumesDownload reports whether a request continues a counted download of
+// the file it asks for, by header or by the cookie set when it started.
+func (sc *ShareLinkController) resumesDownload(r *http.Request, link *models.ShareLink) bool {
+    rel := path.Clean("/" + r.URL.Query().Get("path"))
+    session := r.Header.Get(downloadSessionHeader)
+    if session == "" {
+        if cookie, err := r.Cookie("share_session"); err == nil {
+            session = cookie.Value
+        }
+    }
+    return session != "" && sc.shareLinks.ResumesDownload(link.ID, rel, session)
+}
+
+// setDownloadSession hands out the session of a counted download, scoped
+// to the link's URL.
+func setDownloadSession(w http.ResponseWriter, r *http.Request, session string) {
+    w.Header().Set(downloadSessionHeader, session)
+    http.SetCookie(w, &http.Cookie{
+        Name:     "share_session",
+        Value:    session,
+        Path:     r.URL.Path,
+        MaxAge:   int(services.DownloadSessionLength / time.Second),
+        HttpOnly: true,
+        SameSite: http.SameSiteLaxMode,
+    })
+}
+
+// describeLink returns what visitors may know about a link, including the
+// upload limits of links taking uploads.
+func (sc *ShareLinkController) describeLink(link *models.ShareLink) (sharedItem, error) {
+    item := sharedItem{
+        Name:      path.Base(link.Path),
+        IsDir:     link.IsDir,
+        Mode:      link.Mode,
+        ExpiresAt: link.ExpiresAt,
+    }
+    if link.MaxDownloads > 0 {
+        left := link.MaxDownloads - link.Downloads
+        item.DownloadsLeft = &left
+    }
+    if link.Mode != models.ShareLinkRead {
+        policy, err := sc.fileService.RequestPolicy(link.MaxFileSize)
+        if err != nil {
+            return item, err
+        }
+        item.AllowedTypes = policy.AllowedTypes()
+        item.MaxFileSize = policy.MaxBytes
+    }
+    return item, nil
+}
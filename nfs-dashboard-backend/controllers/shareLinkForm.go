@@ -0,0 +1,163 @@
+package controllers
+
+import (
+    "fmt"
+    "html/template"
+    "log"
+    "net/http"
+    "strings"
+    "nfs-dashboard-backend/models"
+)
+
+// maxSubmitterField bounds the name and email fields of a file request,
+// and the password field of the forms.
+const maxSubmitterField = 256
+
+// sharePasswordHeader carries the password of a link for API clients.
+const sharePasswordHeader = "X-Share-Password"
+
+// uploadForm is the page browsers get for upload and file request links.
+// Fields come before the file input so they precede the files in the
+// streamed body, the password, which is needed to open the link, first.
+var uploadForm = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Send files to {{.Item.Name}}</title>
+<style>
+body { font-family: sans-serif; max-width: 32rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+label { display: block; margin-top: 1rem; }
+input { display: block; width: 100%; margin-top: .25rem; }
+button { margin-top: 1.5rem; padding: .5rem 1.5rem; }
+.note { color: #666; font-size: .9rem; }
+.error { color: #b00020; }
+.done { color: #1b5e20; }
+</style>
+</head>
+<body>
+<h1>Send files to {{.Item.Name}}</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Uploaded}}<p class="done">Received:</p>
+<ul>{{range .Uploaded}}<li>{{.Name}}</li>{{end}}</ul>{{end}}
+<form method="post" enctype="multipart/form-data">
+{{if .Password}}<input type="hidden" name="password" value="{{.Password}}">{{end}}
+{{if .Request}}
+<label>Your name <input name="name" required maxlength="100"></label>
+<label>Your email <input name="email" type="email" maxlength="200"></label>
+{{end}}
+<label>Files <input name="file" type="file" multiple required{{if .Accept}} accept="{{.Accept}}"{{end}}></label>
+<p class="note">
+{{if .Accept}}Allowed types: {{.Accept}}.{{end}}
+{{if .MaxSize}}Up to {{.MaxSize}} per file.{{end}}
+Files already in the folder are not shown. This link expires {{.Item.ExpiresAt.Format "2 Jan 2006 15:04 MST"}}.
+</p>
+<button type="submit">Send</button>
+</form>
+</body>
+</html>
+`))
+
+// passwordForm is the page browsers get for links whose password is missing
+// or wrong. It posts the password back to the same URL.
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Password required</title>
+<style>
+body { font-family: sans-serif; max-width: 32rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+label { display: block; margin-top: 1rem; }
+input { display: block; width: 100%; margin-top: .25rem; }
+button { margin-top: 1.5rem; padding: .5rem 1.5rem; }
+.error { color: #b00020; }
+</style>
+</head>
+<body>
+<h1>Password required</h1>
+{{if .}}<p class="error">The password is wrong.</p>{{end}}
+<form method="post">
+<label>Password <input name="password" type="password" required autofocus maxlength="256"></label>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// isPasswordForm reports whether r posts the password form.
+func isPasswordForm(r *http.Request) bool {
+    return r.Method == http.MethodPost &&
+        strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+}
+
+// renderPasswordForm asks a browser for the password of a link.
+func renderPasswordForm(w http.ResponseWriter, wrong bool) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(http.StatusUnauthorized)
+    if err := passwordForm.Execute(w, wrong); err != nil {
+        log.Printf("Failed to render password form: %v", err)
+    }
+}
+
+// wantsHTML reports whether the client is a browser expecting a page.
+func wantsHTML(r *http.Request) bool {
+    return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// renderForm writes the upload form of link, with the outcome of a
+// submission when there was one. The password the link was opened with is
+// sent along with the files.
+func (sc *ShareLinkController) renderForm(w http.ResponseWriter, link *models.ShareLink, password string, status int, uploaded []models.File, uploadErr error) {
+    item, err := sc.describeLink(link)
+    if err != nil {
+        handleError(w, err, http.StatusInternalServerError)
+        return
+    }
+    data := struct {
+        Item     sharedItem
+        Password string
+        Request  bool
+        Accept   string
+        MaxSize  string
+        Uploaded []models.File
+        Error    string
+    }{
+        Item:     item,
+        Password: password,
+        Request:  link.Mode == models.ShareLinkRequest,
+        Accept:   strings.Join(item.AllowedTypes, ","),
+        MaxSize:  formatSize(item.MaxFileSize),
+        Uploaded: uploaded,
+    }
+    if uploadErr != nil {
+        data.Error = uploadErr.Error()
+    }
+
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    if password != "" {
+        w.Header().Set("Cache-Control", "no-store")
+    }
+    w.WriteHeader(status)
+    if err := uploadForm.Execute(w, data); err != nil {
+        log.Printf("Failed to render upload form of link %s: %v", link.ID, err)
+    }
+}
+
+// formatSize formats a byte count for people, or "" for zero.
+func formatSize(n int64) string {
+    const unit = 1024
+    if n <= 0 {
+        return ""
+    }
+    if n < unit {
+        return fmt.Sprintf("%d bytes", n)
+    }
+    div, exp := int64(unit), 0
+    for m := n / unit; m >= unit; m /= unit {
+        div *= unit
+        exp++
+    }
+    return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+    "io"
+    "net/http"
+    "strconv"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+// ThumbnailController serves cached image thumbnails.
+type ThumbnailController struct {
+    thumbnails *services.ThumbnailService
+}
+
+// NewThumbnailController creates a new ThumbnailController.
+func NewThumbnailController(thumbnails *services.ThumbnailService) *ThumbnailController {
+    return &ThumbnailController{thumbnails: thumbnails}
+}
+
+// GetThumbnail handles GET /api/files/thumbnail?path=&size=. Browsers may
+// keep thumbnails but must revalidate them, since the source can change at
+// any time. The ETag is derived from the source file, so a matching
+// If-None-Match is answered with 304 before any image is read.
+func (tc *ThumbnailController) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    if path == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path is required")
+        return
+    }
+    size := 0
+    if v := r.URL.Query().Get("size"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n <= 0 {
+            utils.RespondWithError(w, http.StatusBadRequest, "size must be a positive number of pixels")
+            return
+        }
+        size = n
+    }
+
+    thumb, err := tc.thumbnails.Lookup(path, size)
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    w.Header().Set("ETag", thumb.ETag)
+    w.Header().Set("Cache-Control", "private, no-cache")
+    if etagMatches(r.Header.Get("If-None-Match"), thumb.ETag) {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    content, err := tc.thumbnails.Open(thumb)
+    if err != nil {
+        w.Header().Del("ETag")
+        w.Header().Del("Cache-Control")
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    defer content.Close()
+    w.Header().Set("Content-Type", thumb.ContentType)
+    if info, err := content.Stat(); err == nil {
+        w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+    }
+    io.Copy(w, content)
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "path"
+    "time"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+
+    "github.com/gorilla/mux"
+)
+
+// TrashController lists, restores and purges deleted items. Users see and
+// restore what they deleted themselves; admins see everything and are the
+// only ones allowed to purge.
+type TrashController struct {
+    fileService *services.FileService
+    permissions *services.PermissionService
+}
+
+// NewTrashController creates a new TrashController.
+func NewTrashController(fileService *services.FileService, permissions *services.PermissionService) *TrashController {
+    return &TrashController{
+        fileService: fileService,
+        permissions: permissions,
+    }
+}
+
+// visibleItem loads the trash item named in the URL if the caller deleted it.
+// Items of other users are reported as missing.
+func (tc *TrashController) visibleItem(w http.ResponseWriter, r *http.Request) (*models.TrashItem, bool) {
+    user := utils.UserFromContext(r.Context())
+    item, err := tc.fileService.GetTrashItem(mux.Vars(r)["id"])
+    if err == nil && (user == nil || (item.DeletedBy != user.ID && !services.IsAdmin(user))) {
+        err = services.ErrTrashItemNotFound
+    }
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return nil, false
+    }
+    return item, true
+}
+
+// ListTrash handles GET /api/trash.
+func (tc *TrashController) ListTrash(w http.ResponseWriter, r *http.Request) {
+    items, err := tc.fileService.ListTrash()
+    if err != nil {
+        utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    user := utils.UserFromContext(r.Context())
+    if !services.IsAdmin(user) {
+        own := []models.TrashItem{}
+        for _, item := range items {
+            if user != nil && item.DeletedBy == user.ID {
+                own = append(own, item)
+            }
+        }
+        items = own
+    }
+    utils.RespondWithJSON(w, http.StatusOK, items)
+}
+
+// RestoreItem handles POST /api/trash/{id}/restore. The optional JSON body
+// {"conflict": "fail|overwrite|rename"} decides what happens when the
+// original location is taken.
+func (tc *TrashController) RestoreItem(w http.ResponseWriter, r *http.Request) {
+    item, ok := tc.visibleItem(w, r)
+    if !ok {
+        return
+    }
+    var req struct {
+        Conflict string `json:"conflict"`
+    }
+    if r.ContentLength != 0 {
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            utils.RespondWithError(w, http.StatusBadRequest, "Invalid request")
+            return
+        }
+    }
+    policy, err := services.ParseConflictPolicy(req.Conflict)
+    if err != nil {
+        utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    if !authorize(w, r, tc.permissions, services.ActionWrite, path.Dir(item.OriginalPath)) {
+        return
+    }
+
+    restored, err := tc.fileService.RestoreTrashItem(item.ID, policy, currentUserID(r))
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    utils.RespondWithJSON(w, http.StatusOK, restored)
+}
+
+// PurgeItem handles DELETE /api/admin/trash/{id}.
+func (tc *TrashController) PurgeItem(w http.ResponseWriter, r *http.Request) {
+    if err := tc.fileService.PurgeTrashItem(mux.Vars(r)["id"]); err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// EmptyTrash handles DELETE /api/admin/trash, purging every item or, with
+// ?olderThanDays=N, only items deleted more than N days ago.
+func (tc *TrashController) EmptyTrash(w http.ResponseWriter, r *http.Request) {
+    cutoff := time.Now()
+    if days, err := parseInt64Param(r.URL.Query().Get("olderThanDays")); err != nil {
+        utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+        return
+    } else if days > 0 {
+        cutoff = cutoff.AddDate(0, 0, -int(days))
+    }
+    purged, err := tc.fileService.EmptyTrash(cutoff)
+    if err != nil {
+        utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    utils.RespondWithJSON(w, http.StatusOK, map[string]int{"purged": purged})
+}
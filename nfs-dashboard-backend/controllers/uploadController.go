@@ -0,0 +1,155 @@
+package controllers
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+
+    "github.com/gorilla/mux"
+)
+
+// tusVersion is the tus protocol version spoken by the resumable upload routes.
+const tusVersion = "1.0.0"
+
+// uploadsRoute is the collection URL for resumable uploads.
+const uploadsRoute = "/api/files/uploads/"
+
+// UploadController exposes resumable uploads using tus-style create (POST),
+// status (HEAD), append (PATCH) and cancel (DELETE) requests.
+type UploadController struct {
+    uploadService *services.UploadService
+    fileService   *services.FileService
+}
+
+// NewUploadController creates a new UploadController.
+func NewUploadController(uploadService *services.UploadService, fileService *services.FileService) *UploadController {
+    return &UploadController{
+        uploadService: uploadService,
+        fileService:   fileService,
+    }
+}
+
+// uploadStatus maps upload errors onto HTTP status codes.
+func uploadStatus(err error) int {
+    switch {
+    case errors.Is(err, services.ErrUploadNotFound):
+        return http.StatusNotFound
+    case errors.Is(err, services.ErrOffsetMismatch), errors.Is(err, services.ErrUploadBusy):
+        return http.StatusConflict
+    }
+    return statusForError(err, http.StatusInternalServerError)
+}
+
+// setUploadHeaders writes the tus headers describing an upload.
+func (uc *UploadController) setUploadHeaders(w http.ResponseWriter, upload *models.Upload) {
+    w.Header().Set("Tus-Resumable", tusVersion)
+    w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+    w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+    w.Header().Set("Upload-Expires", uc.uploadService.ExpiresAt(upload).UTC().Format(http.TimeFormat))
+    w.Header().Set("Cache-Control", "no-store")
+}
+
+// ownedUpload loads the upload named in the URL if the caller started it.
+// Uploads of other users are reported as missing.
+func (uc *UploadController) ownedUpload(w http.ResponseWriter, r *http.Request) (*models.Upload, bool) {
+    user := utils.UserFromContext(r.Context())
+    upload, err := uc.uploadService.Get(mux.Vars(r)["id"])
+    if err == nil && user != nil && upload.Owner != user.ID && !services.IsAdmin(user) {
+        err = services.ErrUploadNotFound
+    }
+    if err != nil {
+        utils.RespondWithError(w, uploadStatus(err), err.Error())
+        return nil, false
+    }
+    return upload, true
+}
+
+// CreateUpload handles POST /api/files/uploads. The target folder and file
+// name are passed as "path" and "filename" in the Upload-Metadata header.
+func (uc *UploadController) CreateUpload(w http.ResponseWriter, r *http.Request) {
+    length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+    if err != nil || length < 0 {
+        utils.RespondWithError(w, http.StatusBadRequest, "Upload-Length header required")
+        return
+    }
+    meta, err := services.ParseUploadMetadata(r.Header.Get("Upload-Metadata"))
+    if err != nil {
+        utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+        return
+    }
+    filename := meta["filename"]
+    if filename == "" {
+        filename = meta["name"]
+    }
+    if meta["path"] == "" || filename == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path and filename metadata required")
+        return
+    }
+
+    upload, err := uc.uploadService.Create(meta["path"], filename, length, currentUserID(r))
+    if err != nil {
+        utils.RespondWithError(w, uploadStatus(err), err.Error())
+        return
+    }
+
+    uc.setUploadHeaders(w, upload)
+    w.Header().Set("Location", uploadsRoute+upload.ID)
+    utils.RespondWithJSON(w, http.StatusCreated, upload)
+}
+
+// GetUploadStatus handles HEAD /api/files/uploads/{id}.
+func (uc *UploadController) GetUploadStatus(w http.ResponseWriter, r *http.Request) {
+    upload, ok := uc.ownedUpload(w, r)
+    if !ok {
+        return
+    }
+    uc.setUploadHeaders(w, upload)
+    w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload handles PATCH /api/files/uploads/{id}, appending the request
+// body at Upload-Offset. The final chunk responds with the stored file.
+func (uc *UploadController) PatchUpload(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+        utils.RespondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+        return
+    }
+    offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+    if err != nil || offset < 0 {
+        utils.RespondWithError(w, http.StatusBadRequest, "Upload-Offset header required")
+        return
+    }
+    if _, ok := uc.ownedUpload(w, r); !ok {
+        return
+    }
+
+    upload, file, err := uc.uploadService.Append(mux.Vars(r)["id"], offset, r.Body)
+    if upload != nil {
+        uc.setUploadHeaders(w, upload)
+    }
+    if err != nil {
+        utils.RespondWithError(w, uploadStatus(err), err.Error())
+        return
+    }
+    if file == nil {
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+    utils.RespondWithJSON(w, http.StatusOK, file)
+}
+
+// CancelUpload handles DELETE /api/files/uploads/{id}.
+func (uc *UploadController) CancelUpload(w http.ResponseWriter, r *http.Request) {
+    if _, ok := uc.ownedUpload(w, r); !ok {
+        return
+    }
+    if err := uc.uploadService.Cancel(mux.Vars(r)["id"]); err != nil {
+        utils.RespondWithError(w, uploadStatus(err), err.Error())
+        return
+    }
+    w.Header().Set("Tus-Resumable", tusVersion)
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+    "net/http"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+// UsageController reports per-user storage consumption.
+type UsageController struct {
+    fileService *services.FileService
+    authService *services.AuthService
+}
+
+// NewUsageController creates a new UsageController.
+func NewUsageController(fileService *services.FileService, authService *services.AuthService) *UsageController {
+    return &UsageController{
+        fileService: fileService,
+        authService: authService,
+    }
+}
+
+// MyUsage handles GET /api/auth/usage for the signed-in user.
+func (uc *UsageController) MyUsage(w http.ResponseWriter, r *http.Request) {
+    user := utils.UserFromContext(r.Context())
+    if user == nil {
+        utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
+        return
+    }
+    usage := uc.fileService.Usage(user.ID)
+    usage.Email = user.Email
+    utils.RespondWithJSON(w, http.StatusOK, usage)
+}
+
+// AllUsage handles GET /api/admin/usage. Every registered user is listed,
+// followed by owners that no longer have an account.
+func (uc *UsageController) AllUsage(w http.ResponseWriter, r *http.Request) {
+    all := uc.fileService.AllUsage()
+    owned := make(map[string]models.StorageUsage)
+    for _, usage := range all {
+        owned[usage.UserID] = usage
+    }
+
+    var result []models.StorageUsage
+    for _, user := range uc.authService.Users {
+        usage, ok := owned[user.ID]
+        if !ok {
+            usage = uc.fileService.Usage(user.ID)
+        }
+        usage.Email = user.Email
+        result = append(result, usage)
+        delete(owned, user.ID)
+    }
+    for _, usage := range all {
+        if _, orphaned := owned[usage.UserID]; orphaned {
+            result = append(result, usage)
+        }
+    }
+    utils.RespondWithJSON(w, http.StatusOK, result)
+}
+
+// RescanUsage handles POST /api/admin/usage/rescan, reconciling usage with
+// the shares before returning the refreshed totals.
+func (uc *UsageController) RescanUsage(w http.ResponseWriter, r *http.Request) {
+    uc.fileService.RescanUsage()
+    uc.AllUsage(w, r)
+}
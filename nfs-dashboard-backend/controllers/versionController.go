@@ -0,0 +1,94 @@
+package controllers
+
+import (
+    "encoding/json"
+    "net/http"
+    "path/filepath"
+    "time"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+// VersionController exposes the earlier versions kept for overwritten files.
+type VersionController struct {
+    fileService *services.FileService
+}
+
+// NewVersionController creates a new VersionController.
+func NewVersionController(fileService *services.FileService) *VersionController {
+    return &VersionController{
+        fileService: fileService,
+    }
+}
+
+// ListVersions handles GET /api/files/versions?path=.
+func (vc *VersionController) ListVersions(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    if path == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path is required")
+        return
+    }
+    versions, err := vc.fileService.ListVersions(path)
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    var modTime time.Time
+    if len(versions) > 0 {
+        modTime = versions[0].ReplacedAt // newest first
+    }
+    respondCachedJSON(w, r, versions, modTime)
+}
+
+// DownloadVersion handles GET /api/files/versions/download?path=&version=.
+// Versions never change, so their ID makes a strong ETag.
+func (vc *VersionController) DownloadVersion(w http.ResponseWriter, r *http.Request) {
+    path := r.URL.Query().Get("path")
+    id := r.URL.Query().Get("version")
+    if path == "" || id == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path and version are required")
+        return
+    }
+    content, version, err := vc.fileService.OpenVersion(path, id)
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    defer content.Close()
+    if notModified(w, r, `"`+version.ID+`"`, version.LastModified) {
+        return
+    }
+    info, err := content.Stat()
+    if err != nil {
+        utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+
+    name := filepath.Base(version.Path)
+    mimeType, body, err := vc.fileService.ContentTypes().Sniff(name, content)
+    if err != nil {
+        utils.RespondWithError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    setFileHeaders(w, mimeType, name, "attachment")
+    sendContent(w, r, content, info, body)
+}
+
+// RestoreVersion handles POST /api/files/versions/restore with a JSON body
+// {"path": "...", "version": "..."}.
+func (vc *VersionController) RestoreVersion(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Path    string `json:"path"`
+        Version string `json:"version"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" || req.Version == "" {
+        utils.RespondWithError(w, http.StatusBadRequest, "path and version are required")
+        return
+    }
+    restored, err := vc.fileService.RestoreVersion(req.Path, req.Version, currentUserID(r))
+    if err != nil {
+        utils.RespondWithError(w, statusForError(err, http.StatusInternalServerError), err.Error())
+        return
+    }
+    utils.RespondWithJSON(w, http.StatusOK, restored)
+}
@@ -4,11 +4,13 @@ go 1.16
 
 require (
 	github.com/gin-gonic/gin v1.7.4
-	github.com/golang-jwt/jwt/v4 v4.5.2 // indirect
-	github.com/google/uuid v1.6.0 // indirect
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.1
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/pquerna/otp v1.4.0 // indirect
-	github.com/rs/cors v1.11.1 // indirect
+	github.com/joho/godotenv v1.5.1
+	github.com/pquerna/otp v1.4.0
+	github.com/rs/cors v1.11.1
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+	golang.org/x/sys v0.10.0 // indirect
 // Add other dependencies as needed
 )
@@ -18,15 +18,12 @@ func main() {
     router := mux.NewRouter()
     routes.RegisterRoutes(router)
 
-    // Serve Swagger files
-    router.PathPrefix("/swagger.yaml").Handler(http.FileServer(http.Dir(".")))
-    router.PathPrefix("/swagger/").Handler(http.StripPrefix("/swagger/", http.FileServer(http.Dir("./swaggerui"))))
-
     // CORS config
     c := cors.New(cors.Options{
         AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:5174", "http://localhost:8080"},
-        AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-        AllowedHeaders:   []string{"Content-Type", "Authorization"},
+        AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+        AllowedHeaders:   []string{"Content-Type", "Authorization", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "If-None-Match", "If-Match", "If-Modified-Since", "If-Range", "Range", "X-Expected-Checksum", "X-Share-Password", "X-Share-Session"},
+        ExposedHeaders:   []string{"Location", "Tus-Resumable", "Upload-Offset", "Upload-Length", "Upload-Expires", "ETag", "Content-Range", "Accept-Ranges", "X-Next-Cursor", "X-Share-Session"},
         AllowCredentials: true,
     })
 
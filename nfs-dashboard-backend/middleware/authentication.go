@@ -0,0 +1,75 @@
+package middleware
+
+import (
+    "net/http"
+    "strings"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+// adminPrefix is the route prefix reserved for admin-role users.
+const adminPrefix = "/api/admin/"
+
+// Authenticator validates the JWT on every request, stores the resolved user
+// in the request context and restricts admin routes to admins. Routes that
+// need no token must be declared with Public.
+type Authenticator struct {
+    authService    *services.AuthService
+    publicPaths    map[string]bool
+    publicPrefixes []string
+}
+
+// NewAuthenticator creates an Authenticator with no public routes.
+func NewAuthenticator(authService *services.AuthService) *Authenticator {
+    return &Authenticator{
+        authService: authService,
+        publicPaths: make(map[string]bool),
+    }
+}
+
+// Public declares paths that can be reached without a token. A path ending
+// in "/" covers everything beneath it.
+func (a *Authenticator) Public(paths ...string) *Authenticator {
+    for _, p := range paths {
+        if strings.HasSuffix(p, "/") {
+            a.publicPrefixes = append(a.publicPrefixes, p)
+        } else {
+            a.publicPaths[p] = true
+        }
+    }
+    return a
+}
+
+func (a *Authenticator) isPublic(p string) bool {
+    if a.publicPaths[p] {
+        return true
+    }
+    for _, prefix := range a.publicPrefixes {
+        if strings.HasPrefix(p, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// Middleware implements mux.MiddlewareFunc.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if a.isPublic(r.URL.Path) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        user, err := a.authService.UserFromToken(r.Header.Get("Authorization"))
+        if err != nil {
+            utils.RespondWithError(w, http.StatusUnauthorized, "Invalid or expired token")
+            return
+        }
+        if strings.HasPrefix(r.URL.Path, adminPrefix) && !services.IsAdmin(user) {
+            utils.RespondWithError(w, http.StatusForbidden, "Admin access required")
+            return
+        }
+
+        next.ServeHTTP(w, r.WithContext(utils.WithUser(r.Context(), user)))
+    })
+}
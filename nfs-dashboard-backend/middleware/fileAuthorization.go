@@ -0,0 +1,147 @@
+package middleware
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "path"
+    "reflect"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+// maxJSONBody bounds how much of a JSON request body is buffered to read paths from it.
+const maxJSONBody = 1 << 20
+
+// PathExtractor returns the share paths a request operates on.
+type PathExtractor func(r *http.Request) ([]string, error)
+
+// QueryPath reads a path from the named query parameter.
+func QueryPath(name string) PathExtractor {
+    return func(r *http.Request) ([]string, error) {
+        return []string{r.URL.Query().Get(name)}, nil
+    }
+}
+
+// JSONPaths reads string fields from a JSON body. The body is restored so the
+// wrapped handler can decode it again. Fields are decoded into a struct
+// tagged with their names, so keys match them as they match the handler's
+// request struct: case-insensitively, the last one winning.
+func JSONPaths(fields ...string) PathExtractor {
+    structFields := make([]reflect.StructField, len(fields))
+    for i, field := range fields {
+        structFields[i] = reflect.StructField{
+            Name: fmt.Sprintf("F%d", i),
+            Type: reflect.TypeOf(""),
+            Tag:  reflect.StructTag(fmt.Sprintf(`json:%q`, field)),
+        }
+    }
+    bodyType := reflect.StructOf(structFields)
+
+    return func(r *http.Request) ([]string, error) {
+        data, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBody))
+        if err != nil {
+            return nil, err
+        }
+        r.Body.Close()
+        r.Body = io.NopCloser(bytes.NewReader(data))
+
+        body := reflect.New(bodyType)
+        if err := json.Unmarshal(data, body.Interface()); err != nil {
+            return nil, errors.New("invalid request payload")
+        }
+        paths := make([]string, len(fields))
+        for i := range fields {
+            paths[i] = body.Elem().Field(i).String()
+        }
+        return paths, nil
+    }
+}
+
+// UploadMetadataPath reads a path from the tus Upload-Metadata header.
+func UploadMetadataPath(key string) PathExtractor {
+    return func(r *http.Request) ([]string, error) {
+        meta, err := services.ParseUploadMetadata(r.Header.Get("Upload-Metadata"))
+        if err != nil {
+            return nil, err
+        }
+        return []string{meta[key]}, nil
+    }
+}
+
+// JSONRenamePaths reads "path" and a new name field from a JSON body and
+// returns both the original path and the path after renaming.
+func JSONRenamePaths(nameField string) PathExtractor {
+    extract := JSONPaths("path", nameField)
+    return func(r *http.Request) ([]string, error) {
+        paths, err := extract(r)
+        if err != nil || paths[0] == "" || paths[1] == "" {
+            return paths, err
+        }
+        return []string{paths[0], path.Join(path.Dir(paths[0]), paths[1])}, nil
+    }
+}
+
+// FileAuthorizer enforces role permissions on file routes. It expects the
+// Authenticator to have placed the caller in the request context.
+type FileAuthorizer struct {
+    permissions *services.PermissionService
+}
+
+// NewFileAuthorizer creates a FileAuthorizer.
+func NewFileAuthorizer(permissions *services.PermissionService) *FileAuthorizer {
+    return &FileAuthorizer{
+        permissions: permissions,
+    }
+}
+
+// Require wraps next so it only runs when the caller may perform action on
+// every path returned by extract. Requests missing a path are refused.
+func (a *FileAuthorizer) Require(action services.Action, extract PathExtractor, next http.HandlerFunc) http.HandlerFunc {
+    return a.guard(func(user *models.User, p string) error {
+        return a.permissions.Check(user, action, p)
+    }, extract, next)
+}
+
+// RequireList is like Require(ActionRead, ...) but also admits ancestors of
+// granted paths so users can navigate down to the folders they were given.
+func (a *FileAuthorizer) RequireList(extract PathExtractor, next http.HandlerFunc) http.HandlerFunc {
+    return a.guard(a.permissions.CheckList, extract, next)
+}
+
+// guard runs check for the caller against every extracted path.
+func (a *FileAuthorizer) guard(check func(*models.User, string) error, extract PathExtractor, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        user := utils.UserFromContext(r.Context())
+        if user == nil {
+            utils.RespondWithError(w, http.StatusUnauthorized, "Authorization token required")
+            return
+        }
+
+        paths, err := extract(r)
+        if err != nil {
+            utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+            return
+        }
+        for _, p := range paths {
+            if p == "" {
+                utils.RespondWithError(w, http.StatusBadRequest, "path is required")
+                return
+            }
+            if err := check(user, p); err != nil {
+                var permErr *services.PermissionError
+                if errors.As(err, &permErr) {
+                    utils.RespondWithError(w, http.StatusForbidden, permErr.Error())
+                    return
+                }
+                utils.RespondWithError(w, http.StatusInternalServerError, "Failed to evaluate permissions")
+                return
+            }
+        }
+        next(w, r)
+    }
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/services"
+    "nfs-dashboard-backend/utils"
+)
+
+type stubRoles []models.Role
+
+func (r stubRoles) GetRoles() ([]models.Role, error) {
+    return r, nil
+}
+
+func TestRequireJSONPaths(t *testing.T) {
+    roles := stubRoles{{Name: "staff", Permissions: []string{"write:/tmp"}}}
+    authorizer := NewFileAuthorizer(services.NewPermissionService(roles, nil))
+    staff := &models.User{ID: "2", Role: &models.Role{Name: "staff"}}
+
+    // The handler decodes the body as handlers do, into a tagged struct.
+    var acted string
+    handler := authorizer.Require(services.ActionWrite, JSONPaths("path"), func(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+            Path string `json:"path"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            t.Fatal(err)
+        }
+        acted = req.Path
+        w.WriteHeader(http.StatusOK)
+    })
+
+    tests := []struct {
+        name   string
+        body   string
+        status int
+    }{
+        {"granted", `{"path":"/tmp/ok"}`, http.StatusOK},
+        {"denied", `{"path":"/data/secret"}`, http.StatusForbidden},
+        {"key in another case", `{"Path":"/data/secret"}`, http.StatusForbidden},
+        {"granted key then another case", `{"path":"/tmp/ok","PATH":"/data/secret"}`, http.StatusForbidden},
+        {"denied key then granted", `{"PATH":"/data/secret","path":"/tmp/ok"}`, http.StatusOK},
+        {"missing path", `{"other":"/tmp/ok"}`, http.StatusBadRequest},
+        {"empty path", `{"path":""}`, http.StatusBadRequest},
+        {"not a string", `{"path":["/tmp/ok"]}`, http.StatusBadRequest},
+        {"invalid JSON", `{"path":`, http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            acted = ""
+            r := httptest.NewRequest(http.MethodPost, "/api/files/folder", strings.NewReader(tt.body))
+            r = r.WithContext(utils.WithUser(r.Context(), staff))
+            w := httptest.NewRecorder()
+            handler(w, r)
+            if w.Code != tt.status {
+                t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+            }
+            if tt.status == http.StatusOK && acted != "/tmp/ok" {
+                t.Errorf("handler acted on %q, want the checked path", acted)
+            }
+            if tt.status != http.StatusOK && acted != "" {
+                t.Errorf("handler ran for a refused request on %q", acted)
+            }
+            if tt.status != http.StatusOK && !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+                t.Errorf("error response is %q, want JSON", w.Header().Get("Content-Type"))
+            }
+        })
+    }
+}
+
+func TestRequireMissingQueryPath(t *testing.T) {
+    roles := stubRoles{{Name: "staff", Permissions: []string{"*"}}}
+    authorizer := NewFileAuthorizer(services.NewPermissionService(roles, nil))
+    handler := authorizer.Require(services.ActionRead, QueryPath("path"), func(w http.ResponseWriter, r *http.Request) {
+        t.Error("handler ran without a path")
+    })
+    r := httptest.NewRequest(http.MethodGet, "/api/files/info", nil)
+    r = r.WithContext(utils.WithUser(r.Context(), &models.User{ID: "2", Role: &models.Role{Name: "staff"}}))
+    w := httptest.NewRecorder()
+    handler(w, r)
+    if w.Code != http.StatusBadRequest {
+        t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+    }
+}
+
+func TestJSONRenamePaths(t *testing.T) {
+    tests := []struct {
+        body string
+        want []string
+    }{
+        {`{"path":"/data/docs/a.txt","newName":"b.txt"}`, []string{"/data/docs/a.txt", "/data/docs/b.txt"}},
+        {`{"Path":"/data/docs/a.txt","NEWNAME":"b.txt"}`, []string{"/data/docs/a.txt", "/data/docs/b.txt"}},
+        {`{"path":"/data/docs/a.txt"}`, []string{"/data/docs/a.txt", ""}},
+        {`{"newName":"b.txt"}`, []string{"", "b.txt"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.body, func(t *testing.T) {
+            r := httptest.NewRequest(http.MethodPut, "/api/files/rename", strings.NewReader(tt.body))
+            got, err := JSONRenamePaths("newName")(r)
+            if err != nil || strings.Join(got, "|") != strings.Join(tt.want, "|") {
+                t.Errorf("paths = %q, %v, want %q", got, err, tt.want)
+            }
+        })
+    }
+}
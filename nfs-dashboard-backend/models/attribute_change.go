@@ -0,0 +1,20 @@
+package models
+
+// AttributeChange is a file whose mode or ownership was changed, or would
+// be in a dry run.
+type AttributeChange struct {
+    Path   string `json:"path"`
+    Before string `json:"before"`
+    After  string `json:"after"`
+    Error  string `json:"error,omitempty"` // the change failed
+}
+
+// AttributeChangeResult summarises a chmod or chown run.
+type AttributeChangeResult struct {
+    DryRun    bool              `json:"dryRun"`
+    Changed   int               `json:"changed"`
+    Unchanged int               `json:"unchanged"`
+    Failed    int               `json:"failed"`
+    Changes   []AttributeChange `json:"changes"`
+    Truncated bool              `json:"truncated,omitempty"` // Changes lists only the first entries
+}
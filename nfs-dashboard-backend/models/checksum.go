@@ -0,0 +1,10 @@
+package models
+
+// Checksum is the digest of a file's content.
+type Checksum struct {
+    Path      string `json:"path"`
+    Algorithm string `json:"algorithm"`
+    Value     string `json:"value"` // lower-case hex
+    Size      int64  `json:"size"`
+    Cached    bool   `json:"cached"` // served without reading the file
+}
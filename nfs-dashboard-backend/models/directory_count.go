@@ -0,0 +1,9 @@
+package models
+
+// DirectoryCount is the number of entries a folder listing would return.
+type DirectoryCount struct {
+    Path  string `json:"path"`
+    Total int    `json:"total"`
+    Files int    `json:"files"`
+    Dirs  int    `json:"dirs"`
+}
@@ -8,5 +8,9 @@ type File struct {
     IsDir        bool      `json:"is_dir"`
     Size         int64     `json:"size"`
     LastModified time.Time `json:"lastModified"`
+    IsArchive    bool      `json:"is_archive,omitempty"` // browsable via "<path>!"
+    ContentType  string    `json:"contentType,omitempty"`
+    Posix        *PosixInfo `json:"posix,omitempty"` // file info and detailed listings only
+    Lock         *FileLock  `json:"lock,omitempty"`  // set while the entry is locked
 }
 
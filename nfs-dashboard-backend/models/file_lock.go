@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// FileLock is an advisory lock checking out a file or folder, and
+// everything inside it, for one user.
+type FileLock struct {
+    Path      string    `json:"path"`
+    Owner     string    `json:"owner"` // user ID
+    OwnerName string    `json:"ownerName,omitempty"`
+    LockedAt  time.Time `json:"lockedAt"`
+    ExpiresAt time.Time `json:"expiresAt"`
+    Note      string    `json:"note,omitempty"`
+    Flock     bool      `json:"flock,omitempty"` // also held as an flock(2) lock on the file
+}
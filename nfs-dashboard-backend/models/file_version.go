@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// FileVersion is an earlier content of a file, kept when it was overwritten.
+type FileVersion struct {
+    ID           string    `json:"id"`
+    Path         string    `json:"path"`
+    Size         int64     `json:"size"`
+    LastModified time.Time `json:"lastModified"` // mtime of this content
+    ReplacedAt   time.Time `json:"replacedAt"`
+    ReplacedBy   string    `json:"replacedBy"` // ID of the user who overwrote it
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Job statuses.
+const (
+    JobRunning   = "running"
+    JobSucceeded = "succeeded"
+    JobFailed    = "failed"
+    JobCancelled = "cancelled"
+)
+
+// Job is a long-running operation whose progress can be polled.
+type Job struct {
+    ID         string      `json:"id"`
+    Type       string      `json:"type"`
+    Owner      string      `json:"owner"`
+    Status     string      `json:"status"`
+    Done       int64       `json:"done"`  // units of work finished, e.g. bytes
+    Total      int64       `json:"total"` // 0 while unknown
+    Error      string      `json:"error,omitempty"`
+    Result     interface{} `json:"result,omitempty"`
+    CreatedAt  time.Time   `json:"createdAt"`
+    FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+}
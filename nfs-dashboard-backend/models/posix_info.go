@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PosixInfo is the file system metadata of a file on a POSIX share.
+type PosixInfo struct {
+    Mode          string    `json:"mode"`        // e.g. "-rwxr-xr-x"
+    Permissions   string    `json:"permissions"` // octal incl. setuid/setgid/sticky, e.g. "0755"
+    UID           uint32    `json:"uid"`
+    GID           uint32    `json:"gid"`
+    Owner         string    `json:"owner,omitempty"` // resolved user name
+    Group         string    `json:"group,omitempty"` // resolved group name
+    Inode         uint64    `json:"inode"`
+    Links         uint64    `json:"links"`
+    AccessedAt    time.Time `json:"accessedAt"`
+    ChangedAt     time.Time `json:"changedAt"`
+    SymlinkTarget string    `json:"symlinkTarget,omitempty"`
+    IsMountPoint  bool      `json:"isMountPoint"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Share link modes.
+const (
+    ShareLinkRead    = "read"    // download the file or browse the folder
+    ShareLinkUpload  = "upload"  // upload into the folder without seeing it
+    ShareLinkRequest = "request" // like upload, into a subfolder per named submitter
+)
+
+// ShareLink grants access to a file or folder without a dashboard account.
+type ShareLink struct {
+    ID           string     `json:"id"`
+    Path         string     `json:"path"`
+    IsDir        bool       `json:"is_dir"`
+    Mode         string     `json:"mode"`
+    CreatedBy    string     `json:"createdBy"`
+    CreatedAt    time.Time  `json:"createdAt"`
+    ExpiresAt    time.Time  `json:"expiresAt"`
+    PasswordHash string     `json:"passwordHash,omitempty"` // bcrypt; never sent to clients
+    HasPassword  bool       `json:"hasPassword"`
+    MaxDownloads int        `json:"maxDownloads,omitempty"` // 0 means unlimited
+    MaxFileSize  int64      `json:"maxFileSize,omitempty"`  // bytes per upload; 0 means the system limit
+    Downloads    int        `json:"downloads"`
+    RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+    Token        string     `json:"token,omitempty"` // derived from ID; not stored
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// StorageUsage is the amount of share storage attributed to one user.
+type StorageUsage struct {
+    UserID     string    `json:"userId"`
+    Email      string    `json:"email,omitempty"`
+    UsedBytes  int64     `json:"usedBytes"`
+    Files      int       `json:"files"`
+    QuotaBytes int64     `json:"quotaBytes"` // 0 means unlimited
+    ScannedAt  time.Time `json:"scannedAt"`  // last full reconciliation
+}
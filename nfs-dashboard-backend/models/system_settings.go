@@ -1,9 +1,15 @@
 package models
 
 type SystemSettings struct {
-    MaxFileSize        int    `json:"max_file_size"`
-    AllowedFileTypes   string `json:"allowed_file_types"`
-    MaxStoragePerUser  int    `json:"max_storage_per_user"`
-    EnableAuditLog     bool   `json:"enable_audit_log"`
-    SessionTimeout     int    `json:"session_timeout"`
-}
\ No newline at end of file
+    MaxFileSize          int    `json:"max_file_size"`
+    AllowedFileTypes     string `json:"allowed_file_types"`
+    MaxStoragePerUser    int    `json:"max_storage_per_user"`
+    EnableAuditLog       bool   `json:"enable_audit_log"`
+    SessionTimeout       int    `json:"session_timeout"`
+    TrashRetentionDays   int    `json:"trash_retention_days"`   // 0 keeps trash until purged
+    MaxVersions          int    `json:"max_versions"`           // per file, 0 disables versioning
+    VersionRetentionDays int    `json:"version_retention_days"` // 0 keeps versions regardless of age
+    // ContentTypeOverrides maps extensions such as ".log" to the MIME type
+    // files with them are served as, regardless of their content.
+    ContentTypeOverrides map[string]string `json:"content_type_overrides,omitempty"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// TrashItem is a deleted file or folder that can still be restored.
+type TrashItem struct {
+    ID           string    `json:"id"`
+    Name         string    `json:"name"`
+    OriginalPath string    `json:"originalPath"`
+    IsDir        bool      `json:"is_dir"`
+    Size         int64     `json:"size"`
+    DeletedBy    string    `json:"deletedBy"`
+    DeletedAt    time.Time `json:"deletedAt"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload in progress.
+type Upload struct {
+    ID        string    `json:"id"`
+    Path      string    `json:"path"`
+    Filename  string    `json:"filename"`
+    Length    int64     `json:"length"`
+    Offset    int64     `json:"offset"`
+    Owner     string    `json:"owner"`
+    CreatedAt time.Time `json:"createdAt"`
+    UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,79 @@
+package repositories
+
+import (
+    "encoding/json"
+    "os"
+    "sync"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+type SettingsFileRepository struct {
+    filePath string
+    defaults models.SystemSettings
+    mu       sync.Mutex
+    cached   *models.SystemSettings // nil until read
+    modTime  time.Time              // of the file cached, zero if there was none
+    size     int64
+}
+
+// NewSettingsFileRepository stores system settings in filePath. Until the
+// file has been written, defaults are returned. The file is kept in memory
+// and read again only once its modification time or size changes, so hand
+// edits still apply immediately.
+func NewSettingsFileRepository(filePath string, defaults models.SystemSettings) *SettingsFileRepository {
+    return &SettingsFileRepository{filePath: filePath, defaults: defaults}
+}
+
+func (r *SettingsFileRepository) GetSettings() (*models.SystemSettings, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    var modTime time.Time
+    var size int64
+    info, err := os.Stat(r.filePath)
+    if err == nil {
+        modTime, size = info.ModTime(), info.Size()
+    } else if !os.IsNotExist(err) {
+        return nil, err
+    }
+    if r.cached == nil || !modTime.Equal(r.modTime) || size != r.size {
+        settings := r.defaults
+        file, err := os.ReadFile(r.filePath)
+        if err != nil && !os.IsNotExist(err) {
+            return nil, err
+        }
+        if len(file) > 0 {
+            if err := json.Unmarshal(file, &settings); err != nil {
+                return nil, err
+            }
+        }
+        r.cached, r.modTime, r.size = &settings, modTime, size
+    }
+    return copySettings(*r.cached), nil
+}
+
+func (r *SettingsFileRepository) SaveSettings(settings models.SystemSettings) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    data, err := json.MarshalIndent(settings, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.WriteFile(r.filePath, data, 0644); err != nil {
+        return err
+    }
+    r.cached = nil
+    return nil
+}
+
+// copySettings copies settings so callers cannot change the cached copy.
+func copySettings(settings models.SystemSettings) *models.SystemSettings {
+    if settings.ContentTypeOverrides != nil {
+        overrides := make(map[string]string, len(settings.ContentTypeOverrides))
+        for ext, contentType := range settings.ContentTypeOverrides {
+            overrides[ext] = contentType
+        }
+        settings.ContentTypeOverrides = overrides
+    }
+    return &settings
+}
@@ -0,0 +1,63 @@
+package repositories
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+func TestSettingsFollowFileChanges(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "system_settings.json")
+    repo := NewSettingsFileRepository(file, models.SystemSettings{MaxFileSize: 100})
+
+    steps := []struct {
+        name  string
+        apply func() error
+        want  int
+    }{
+        {"defaults without a file", func() error { return nil }, 100},
+        {"saved", func() error { return repo.SaveSettings(models.SystemSettings{MaxFileSize: 50}) }, 50},
+        {"edited by hand", func() error {
+            if err := os.WriteFile(file, []byte(`{"max_file_size": 7}`), 0644); err != nil {
+                return err
+            }
+            // Make the edit visible even on file systems with coarse mtimes.
+            later := time.Now().Add(time.Minute)
+            return os.Chtimes(file, later, later)
+        }, 7},
+        {"removed", func() error { return os.Remove(file) }, 100},
+    }
+    for _, step := range steps {
+        if err := step.apply(); err != nil {
+            t.Fatalf("%s: %v", step.name, err)
+        }
+        settings, err := repo.GetSettings()
+        if err != nil {
+            t.Fatalf("%s: GetSettings: %v", step.name, err)
+        }
+        if settings.MaxFileSize != step.want {
+            t.Errorf("%s: MaxFileSize = %d, want %d", step.name, settings.MaxFileSize, step.want)
+        }
+    }
+}
+
+func TestSettingsCopiesAreIndependent(t *testing.T) {
+    repo := NewSettingsFileRepository(filepath.Join(t.TempDir(), "system_settings.json"), models.SystemSettings{})
+    if err := repo.SaveSettings(models.SystemSettings{ContentTypeOverrides: map[string]string{".log": "text/plain"}}); err != nil {
+        t.Fatal(err)
+    }
+    first, err := repo.GetSettings()
+    if err != nil {
+        t.Fatal(err)
+    }
+    first.ContentTypeOverrides[".log"] = "text/html"
+    second, err := repo.GetSettings()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := second.ContentTypeOverrides[".log"]; got != "text/plain" {
+        t.Errorf("override changed through an earlier copy: %q", got)
+    }
+}
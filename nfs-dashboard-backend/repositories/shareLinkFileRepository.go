@@ -0,0 +1,52 @@
+package repositories
+
+import (
+    "encoding/json"
+    "os"
+    "sync"
+    "nfs-dashboard-backend/models"
+)
+
+// ShareLinkFileRepository stores share links in a JSON file.
+type ShareLinkFileRepository struct {
+    filePath string
+    mu       sync.Mutex
+}
+
+func NewShareLinkFileRepository(filePath string) *ShareLinkFileRepository {
+    return &ShareLinkFileRepository{filePath: filePath}
+}
+
+// GetShareLinks returns every stored link; a missing file holds none.
+func (r *ShareLinkFileRepository) GetShareLinks() ([]models.ShareLink, error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    file, err := os.ReadFile(r.filePath)
+    if os.IsNotExist(err) || (err == nil && len(file) == 0) {
+        return []models.ShareLink{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    var links []models.ShareLink
+    if err := json.Unmarshal(file, &links); err != nil {
+        return nil, err
+    }
+    return links, nil
+}
+
+// SaveShareLinks replaces the stored links. The file is only readable by
+// the server since it holds password hashes.
+func (r *ShareLinkFileRepository) SaveShareLinks(links []models.ShareLink) error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    data, err := json.MarshalIndent(links, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp := r.filePath + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, r.filePath)
+}
@@ -2,48 +2,147 @@ package routes
 
 import (
     "nfs-dashboard-backend/controllers"
+    "nfs-dashboard-backend/middleware"
+    "nfs-dashboard-backend/repositories"
     "nfs-dashboard-backend/services"
     "net/http"
+    "os"
+    "runtime"
+    "time"
 
     "github.com/gorilla/mux"
 )
 
 func RegisterRoutes(router *mux.Router) {
     // Initialize services
-    adminRepo := services.NewInMemoryAdminRepository("roles.json")
+    adminRepo := services.NewInMemoryAdminRepository("roles.json", "system_settings.json")
     authService, err := services.NewAuthService("users.json")
     if err != nil {
         panic("Failed to initialize AuthService: " + err.Error())
     }
-    fileService := services.NewFileService()
+    shareRoots, err := services.ParseShareRoots(os.Getenv("SHARE_ROOTS"))
+    if err != nil {
+        panic("Failed to load share roots: " + err.Error())
+    }
     adminService := services.NewAdminService(adminRepo) // Implement your AdminRepository
+    fileService := services.NewFileService(shareRoots, adminService, adminService, authService)
+    uploadService := services.NewUploadService(fileService, services.DefaultUploadExpiry)
+    uploadService.StartCleanup(time.Hour)
+    fileService.StartUsageRescan(30 * time.Minute)
+    fileService.StartTrashPurge(time.Hour)
+    fileService.StartVersionPrune(24 * time.Hour)
+    fileService.StartLockExpiry(time.Minute)
+    shareLinkService, err := services.NewShareLinkService(repositories.NewShareLinkFileRepository("share_links.json"), os.Getenv("JWT_SECRET"), adminService)
+    if err != nil {
+        panic("Failed to load share links: " + err.Error())
+    }
+    shareLinkService.StartPrune(24 * time.Hour)
+    jobService := services.NewJobService()
+    // The cache lives with the dashboard's other data files, away from the
+    // shares; a folder inside a share is refused.
+    thumbnailDir := os.Getenv("THUMBNAIL_CACHE_DIR")
+    if thumbnailDir == "" {
+        thumbnailDir = "thumbnail_cache"
+    }
+    thumbnailService, err := services.NewThumbnailService(fileService, thumbnailDir, runtime.NumCPU())
+    if err != nil {
+        panic("Failed to create thumbnail cache: " + err.Error())
+    }
+    thumbnailService.StartCachePrune(24*time.Hour, 30*24*time.Hour)
+    permissionService := services.NewPermissionService(adminRepo, fileService)
+    fileAuthorizer := middleware.NewFileAuthorizer(permissionService)
 
     // Initialize controllers with dependencies
     authController := controllers.NewAuthController(authService)
-    fileController := controllers.NewFileController(*fileService)
+    fileController := controllers.NewFileController(fileService, permissionService, jobService)
+    uploadController := controllers.NewUploadController(uploadService, fileService)
+    usageController := controllers.NewUsageController(fileService, authService)
+    trashController := controllers.NewTrashController(fileService, permissionService)
+    versionController := controllers.NewVersionController(fileService)
+    lockController := controllers.NewLockController(fileService)
+    jobController := controllers.NewJobController(jobService)
+    thumbnailController := controllers.NewThumbnailController(thumbnailService)
     monitoringController := controllers.NewMonitoringController()
     adminController := controllers.NewAdminController(adminService)
+    shareLinkController := controllers.NewShareLinkController(shareLinkService, fileService, permissionService, authService)
     
+    // Every route requires a valid token unless declared public here.
+    authenticator := middleware.NewAuthenticator(authService).Public(
+        "/api/auth/login",
+        "/api/auth/register",
+        "/swagger.yaml",
+        "/swagger/",
+        "/s/",
+    )
+    router.Use(authenticator.Middleware)
+
     // Auth routes
     router.HandleFunc("/api/auth/login", authController.Login).Methods(http.MethodPost)
     router.HandleFunc("/api/auth/register", authController.Register).Methods(http.MethodPost)
     router.HandleFunc("/api/auth/profile", authController.Profile).Methods(http.MethodGet)
+    router.HandleFunc("/api/auth/usage", usageController.MyUsage).Methods(http.MethodGet)
     router.HandleFunc("/api/generate-2fa-secret", authController.Generate2FASecret).Methods(http.MethodPost)
     router.HandleFunc("/api/verify-2fa", authController.Verify2FA).Methods(http.MethodPost)
     router.HandleFunc("/api/logout", authController.Logout).Methods(http.MethodPost)
     router.HandleFunc("/api/change-password", authController.ChangePassword).Methods(http.MethodPost)
     router.HandleFunc("/api/disable-2fa", authController.Disable2FA).Methods(http.MethodPost)
 
-    // File management routes
-    router.HandleFunc("/api/files", fileController.ListFiles).Methods(http.MethodGet)
-    router.HandleFunc("/api/files/folder", fileController.CreateFolder).Methods(http.MethodPost)
+    // File management routes, guarded by role permissions
+    read, write, del := services.ActionRead, services.ActionWrite, services.ActionDelete
+    router.HandleFunc("/api/files", fileAuthorizer.RequireList(middleware.QueryPath("path"), fileController.ListFiles)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/count", fileAuthorizer.RequireList(middleware.QueryPath("path"), fileController.CountFiles)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/search", fileAuthorizer.RequireList(middleware.QueryPath("path"), fileController.SearchFiles)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/folder", fileAuthorizer.Require(write, middleware.JSONPaths("path"), fileController.CreateFolder)).Methods(http.MethodPost)
+    // Upload checks the write permission itself once the streamed form yields the path
     router.HandleFunc("/api/files/upload", fileController.UploadFile).Methods(http.MethodPost)
-    router.HandleFunc("/api/files/rename", fileController.RenameItem).Methods(http.MethodPut)
-    router.HandleFunc("/api/files", fileController.DeleteItem).Methods(http.MethodDelete)
-    router.HandleFunc("/api/files/download", fileController.DownloadFile).Methods(http.MethodGet)
-    router.HandleFunc("/api/files/preview", fileController.PreviewFile).Methods(http.MethodGet)
-    router.HandleFunc("/api/files/info", fileController.GetFileInfo).Methods(http.MethodGet)
-    router.HandleFunc("/api/files/stream", fileController.StreamFile).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/uploads", fileAuthorizer.Require(write, middleware.UploadMetadataPath("path"), uploadController.CreateUpload)).Methods(http.MethodPost)
+    router.HandleFunc("/api/files/uploads/{id}", uploadController.GetUploadStatus).Methods(http.MethodHead)
+    router.HandleFunc("/api/files/uploads/{id}", uploadController.PatchUpload).Methods(http.MethodPatch)
+    router.HandleFunc("/api/files/uploads/{id}", uploadController.CancelUpload).Methods(http.MethodDelete)
+    router.HandleFunc("/api/files/rename", fileAuthorizer.Require(write, middleware.JSONRenamePaths("newName"), fileController.RenameItem)).Methods(http.MethodPut)
+    router.HandleFunc("/api/files/copy", fileAuthorizer.Require(read, middleware.JSONPaths("sourcePath"),
+        fileAuthorizer.Require(write, middleware.JSONPaths("destinationPath"), fileController.CopyItem))).Methods(http.MethodPost)
+    router.HandleFunc("/api/files/move", fileAuthorizer.Require(del, middleware.JSONPaths("sourcePath"),
+        fileAuthorizer.Require(write, middleware.JSONPaths("destinationPath"), fileController.MoveItem))).Methods(http.MethodPut)
+    router.HandleFunc("/api/files", fileAuthorizer.Require(del, middleware.JSONPaths("path"), fileController.DeleteItem)).Methods(http.MethodDelete)
+    router.HandleFunc("/api/files/chmod", fileAuthorizer.Require(write, middleware.JSONPaths("path"), fileController.ChangeMode)).Methods(http.MethodPost)
+    // Chown additionally requires an admin
+    router.HandleFunc("/api/files/chown", fileAuthorizer.Require(write, middleware.JSONPaths("path"), fileController.ChangeOwner)).Methods(http.MethodPost)
+    router.HandleFunc("/api/files/download", fileAuthorizer.Require(read, middleware.QueryPath("path"), fileController.DownloadFile)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/preview", fileAuthorizer.Require(read, middleware.QueryPath("path"), fileController.PreviewFile)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/content", fileAuthorizer.Require(write, middleware.QueryPath("path"), fileController.SaveFileContent)).Methods(http.MethodPut)
+    router.HandleFunc("/api/files/checksum", fileAuthorizer.Require(read, middleware.QueryPath("path"), fileController.GetChecksum)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/info", fileAuthorizer.Require(read, middleware.QueryPath("path"), fileController.GetFileInfo)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/stream", fileAuthorizer.Require(read, middleware.QueryPath("path"), fileController.StreamFile)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/thumbnail", fileAuthorizer.Require(read, middleware.QueryPath("path"), thumbnailController.GetThumbnail)).Methods(http.MethodGet)
+    // Archive checks each selected path and entry itself
+    router.HandleFunc("/api/files/archive", fileController.ArchiveFiles).Methods(http.MethodPost)
+    // Extract checks write access to the destination itself
+    router.HandleFunc("/api/files/extract", fileAuthorizer.Require(read, middleware.JSONPaths("path"), fileController.ExtractArchive)).Methods(http.MethodPost)
+    // Unlocking someone else's lock additionally requires an admin
+    router.HandleFunc("/api/files/lock", fileAuthorizer.Require(read, middleware.QueryPath("path"), lockController.GetLock)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/lock", fileAuthorizer.Require(write, middleware.JSONPaths("path"), lockController.LockItem)).Methods(http.MethodPost)
+    router.HandleFunc("/api/files/lock", fileAuthorizer.Require(write, middleware.JSONPaths("path"), lockController.UnlockItem)).Methods(http.MethodDelete)
+    router.HandleFunc("/api/files/versions", fileAuthorizer.Require(read, middleware.QueryPath("path"), versionController.ListVersions)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/versions/download", fileAuthorizer.Require(read, middleware.QueryPath("path"), versionController.DownloadVersion)).Methods(http.MethodGet)
+    router.HandleFunc("/api/files/versions/restore", fileAuthorizer.Require(write, middleware.JSONPaths("path"), versionController.RestoreVersion)).Methods(http.MethodPost)
+
+    // Trash; restore checks write access to the original folder itself
+    router.HandleFunc("/api/trash", trashController.ListTrash).Methods(http.MethodGet)
+    router.HandleFunc("/api/trash/{id}/restore", trashController.RestoreItem).Methods(http.MethodPost)
+
+    // Share links; creation checks access to the path itself
+    router.HandleFunc("/api/shares", shareLinkController.CreateShareLink).Methods(http.MethodPost)
+    router.HandleFunc("/api/shares", shareLinkController.ListShareLinks).Methods(http.MethodGet)
+    router.HandleFunc("/api/shares/{id}", shareLinkController.RevokeShareLink).Methods(http.MethodDelete)
+    // Public; access is checked against the link creator's permissions
+    router.HandleFunc("/s/{token}", shareLinkController.Access).Methods(http.MethodGet, http.MethodHead)
+    router.HandleFunc("/s/{token}", shareLinkController.Upload).Methods(http.MethodPost)
+
+    // Background jobs
+    router.HandleFunc("/api/jobs", jobController.ListJobs).Methods(http.MethodGet)
+    router.HandleFunc("/api/jobs/{id}", jobController.GetJob).Methods(http.MethodGet)
+    router.HandleFunc("/api/jobs/{id}", jobController.CancelJob).Methods(http.MethodDelete)
 
     // Monitoring
     router.HandleFunc("/api/monitoring", monitoringController.GetMonitoringData).Methods(http.MethodGet)
@@ -67,4 +166,17 @@ func RegisterRoutes(router *mux.Router) {
     // System settings and audit logs
     router.HandleFunc("/api/admin/settings", adminController.SystemSettings).Methods(http.MethodGet, http.MethodPut)
     router.HandleFunc("/api/admin/audit-logs", adminController.GetAuditLogs).Methods(http.MethodGet)
-}
\ No newline at end of file
+    router.HandleFunc("/api/admin/shares", shareLinkController.ListAllShareLinks).Methods(http.MethodGet)
+
+    // Trash purging
+    router.HandleFunc("/api/admin/trash", trashController.EmptyTrash).Methods(http.MethodDelete)
+    router.HandleFunc("/api/admin/trash/{id}", trashController.PurgeItem).Methods(http.MethodDelete)
+
+    // Storage usage
+    router.HandleFunc("/api/admin/usage", usageController.AllUsage).Methods(http.MethodGet)
+    router.HandleFunc("/api/admin/usage/rescan", usageController.RescanUsage).Methods(http.MethodPost)
+
+    // Swagger documentation
+    router.PathPrefix("/swagger.yaml").Handler(http.FileServer(http.Dir(".")))
+    router.PathPrefix("/swagger/").Handler(http.StripPrefix("/swagger/", http.FileServer(http.Dir("./swaggerui"))))
+}
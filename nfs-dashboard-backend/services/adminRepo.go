@@ -16,23 +16,26 @@ type InMemoryAdminRepository struct {
     admins         map[int]*models.Admin
     users          []models.User
     roleRepo       *repositories.RoleFileRepository // <-- use file-based repo
-    systemSettings *models.SystemSettings
+    settingsRepo   *repositories.SettingsFileRepository
     auditLogs      []models.AuditLog
     mu             sync.RWMutex
 }
 
-func NewInMemoryAdminRepository(roleFilePath string) *InMemoryAdminRepository {
+func NewInMemoryAdminRepository(roleFilePath, settingsFilePath string) *InMemoryAdminRepository {
     return &InMemoryAdminRepository{
         admins:          make(map[int]*models.Admin),
         users:           []models.User{},
         roleRepo:        repositories.NewRoleFileRepository(roleFilePath), 
-        systemSettings:  &models.SystemSettings{
-            MaxFileSize:       100,
-            AllowedFileTypes:  ".jpg,.png,.pdf,.doc,.docx",
-            MaxStoragePerUser: 5,
-            EnableAuditLog:    true,
-            SessionTimeout:    30,
-        },
+        settingsRepo:    repositories.NewSettingsFileRepository(settingsFilePath, models.SystemSettings{
+            MaxFileSize:          100,
+            AllowedFileTypes:     ".jpg,.png,.pdf,.doc,.docx",
+            MaxStoragePerUser:    5,
+            EnableAuditLog:       true,
+            SessionTimeout:       30,
+            TrashRetentionDays:   30,
+            MaxVersions:          10,
+            VersionRetentionDays: 90,
+        }),
         auditLogs:        []models.AuditLog{},
     }
 }
@@ -100,9 +103,18 @@ func (repo *InMemoryAdminRepository) GetRoles() ([]models.Role, error) {
 }
 
 func (repo *InMemoryAdminRepository) GetSystemSettings() (*models.SystemSettings, error) {
-    repo.mu.RLock()
-    defer repo.mu.RUnlock()
-    return repo.systemSettings, nil
+    return repo.settingsRepo.GetSettings()
+}
+
+// UpdateSystemSettings persists new system settings on behalf of actor.
+func (repo *InMemoryAdminRepository) UpdateSystemSettings(settings models.SystemSettings, actor string) error {
+    if err := repo.settingsRepo.SaveSettings(settings); err != nil {
+        return err
+    }
+    repo.mu.Lock()
+    defer repo.mu.Unlock()
+    repo.appendAuditLog("update_settings", actor, "Updated system settings")
+    return nil
 }
 
 // GetUserByID finds a user by ID.
@@ -164,9 +176,16 @@ func (repo *InMemoryAdminRepository) BulkDeleteUsers(ids []string) error {
     return nil
 }
 
+// RecordAuditLog appends an audit log entry on behalf of another service.
+func (repo *InMemoryAdminRepository) RecordAuditLog(action, userID, details string) {
+    repo.mu.Lock()
+    defer repo.mu.Unlock()
+    repo.appendAuditLog(action, userID, details)
+}
+
 // Helper to append an audit log entry
 func (repo *InMemoryAdminRepository) appendAuditLog(action, userID, details string) {
-    if repo.systemSettings != nil && !repo.systemSettings.EnableAuditLog {
+    if settings, err := repo.settingsRepo.GetSettings(); err == nil && !settings.EnableAuditLog {
         return
     }
     log := models.AuditLog{
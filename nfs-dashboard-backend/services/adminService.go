@@ -29,10 +29,12 @@ type AdminServiceInterface interface {
 
     // System settings
     GetSystemSettings() (*models.SystemSettings, error)
+    UpdateSystemSettings(settings models.SystemSettings, actor string) error
 
     // 2FA and audit
     DisableUser2FA(id string) error
     GetAuditLogs() ([]models.AuditLog, error)
+    RecordAuditLog(action, userID, details string)
 }
 
 // AdminService defines the service for admin-related operations.
@@ -64,10 +66,12 @@ type AdminRepository interface {
 
     // System settings
     GetSystemSettings() (*models.SystemSettings, error)
+    UpdateSystemSettings(settings models.SystemSettings, actor string) error
 
     // 2FA and audit
     DisableUser2FA(id string) error
     GetAuditLogs() ([]models.AuditLog, error)
+    RecordAuditLog(action, userID, details string)
 }
 
 // NewAdminService creates a new instance of AdminService.
@@ -117,6 +121,16 @@ func (s *AdminService) GetRoles() ([]models.Role, error) {
 func (s *AdminService) GetSystemSettings() (*models.SystemSettings, error) {
     return s.repo.GetSystemSettings()
 }
+func (s *AdminService) UpdateSystemSettings(settings models.SystemSettings, actor string) error {
+    if settings.MaxFileSize < 0 || settings.MaxStoragePerUser < 0 || settings.SessionTimeout < 0 || settings.TrashRetentionDays < 0 ||
+        settings.MaxVersions < 0 || settings.VersionRetentionDays < 0 {
+        return errors.New("settings must not be negative")
+    }
+    if err := ValidateContentTypeOverrides(settings.ContentTypeOverrides); err != nil {
+        return err
+    }
+    return s.repo.UpdateSystemSettings(settings, actor)
+}
 
 func (s *AdminService) GetUserByID(id string) (*models.User, error) {
     // Find user by ID in repo
@@ -131,6 +145,11 @@ func (s *AdminService) GetAuditLogs() ([]models.AuditLog, error) {
     return s.repo.GetAuditLogs()
 }
 
+// RecordAuditLog adds an entry to the audit log.
+func (s *AdminService) RecordAuditLog(action, userID, details string) {
+    s.repo.RecordAuditLog(action, userID, details)
+}
+
 func (s *AdminService) GetRoleByID(id string) (*models.Role, error) {
     return s.repo.GetRoleByID(id)
 }
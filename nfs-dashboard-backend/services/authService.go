@@ -5,6 +5,7 @@ import (
     "errors"
     "fmt"
     "os"
+    "strings"
     "sync"
     "time"
     "github.com/golang-jwt/jwt/v4"
@@ -161,6 +162,38 @@ func (s *AuthService) GetProfile(token string) (*models.User, error) {
     return nil, errors.New("user not found")
 }
 
+// UserByID returns the user with the given ID without their password.
+func (s *AuthService) UserByID(id string) (*models.User, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, user := range s.Users {
+        if user.ID == id {
+            safeUser := user
+            safeUser.Password = ""
+            return &safeUser, nil
+        }
+    }
+    return nil, errors.New("user not found")
+}
+
+// adminRoleName is the role that grants access to admin routes.
+const adminRoleName = "admin"
+
+// IsAdmin reports whether the user holds the admin role.
+func IsAdmin(user *models.User) bool {
+    return user != nil && user.Role != nil && user.Role.Name == adminRoleName
+}
+
+// UserFromToken validates a JWT (optionally prefixed with "Bearer ") and
+// returns the matching user without their password.
+func (s *AuthService) UserFromToken(token string) (*models.User, error) {
+    token = strings.TrimPrefix(token, "Bearer ")
+    if token == "" {
+        return nil, errors.New("authorization token required")
+    }
+    return s.GetProfile(token)
+}
+
 func (s *AuthService) GetEmailFromToken(tokenString string) (string, error) {
     // Remove "Bearer " if present
     if len(tokenString) > 7 && tokenString[:7] == "Bearer " {
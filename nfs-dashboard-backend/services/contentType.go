@@ -0,0 +1,139 @@
+package services
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "path"
+    "strings"
+)
+
+const defaultContentType = "application/octet-stream"
+
+// extensionTypes covers common text formats that system MIME tables often
+// lack, so they are served the same way on every host.
+var extensionTypes = map[string]string{
+    ".txt":  "text/plain; charset=utf-8",
+    ".log":  "text/plain; charset=utf-8",
+    ".md":   "text/markdown; charset=utf-8",
+    ".csv":  "text/csv; charset=utf-8",
+    ".tsv":  "text/tab-separated-values; charset=utf-8",
+    ".ini":  "text/plain; charset=utf-8",
+    ".conf": "text/plain; charset=utf-8",
+    ".yaml": "application/yaml",
+    ".yml":  "application/yaml",
+    ".json": "application/json",
+    ".xml":  "application/xml",
+    ".pdf":  "application/pdf",
+}
+
+// ContentTypeDetector decides the MIME type of files from an admin-defined
+// override table, the file extension and the content itself, in that order
+// of precedence.
+type ContentTypeDetector struct {
+    Overrides map[string]string // lower-case extension with dot -> MIME type
+}
+
+// NewContentTypeDetector creates a detector with the given overrides.
+func NewContentTypeDetector(overrides map[string]string) ContentTypeDetector {
+    normalized := make(map[string]string, len(overrides))
+    for ext, contentType := range overrides {
+        normalized[normalizeExtension(ext)] = contentType
+    }
+    return ContentTypeDetector{Overrides: normalized}
+}
+
+// CurrentContentTypes returns a detector using the overrides in the live
+// system settings, or none if they cannot be read.
+func CurrentContentTypes(settings SettingsProvider) ContentTypeDetector {
+    if settings == nil {
+        return NewContentTypeDetector(nil)
+    }
+    current, err := settings.GetSystemSettings()
+    if err != nil {
+        return NewContentTypeDetector(nil)
+    }
+    return NewContentTypeDetector(current.ContentTypeOverrides)
+}
+
+// ValidateContentTypeOverrides checks that every override maps an extension
+// to a well-formed MIME type.
+func ValidateContentTypeOverrides(overrides map[string]string) error {
+    for ext, contentType := range overrides {
+        if normalizeExtension(ext) == "." || strings.ContainsAny(ext, "/\\") {
+            return fmt.Errorf("invalid extension %q", ext)
+        }
+        if _, _, err := mime.ParseMediaType(contentType); err != nil {
+            return fmt.Errorf("invalid content type %q for %s", contentType, ext)
+        }
+    }
+    return nil
+}
+
+func normalizeExtension(ext string) string {
+    ext = strings.ToLower(strings.TrimSpace(ext))
+    if !strings.HasPrefix(ext, ".") {
+        ext = "." + ext
+    }
+    return ext
+}
+
+// Detect returns the MIME type of a file named name starting with head.
+// With a nil head only the name is considered. The extension wins unless
+// the content clearly belongs to another format, so misnamed images or PDFs
+// are still served correctly and extensionless text is served as text.
+func (d ContentTypeDetector) Detect(name string, head []byte) string {
+    ext := strings.ToLower(path.Ext(name))
+    if contentType, ok := d.Overrides[ext]; ok && ext != "" {
+        return contentType
+    }
+    byExtension := extensionTypes[ext]
+    if byExtension == "" && ext != "" {
+        byExtension = mime.TypeByExtension(ext)
+    }
+    if head == nil {
+        if byExtension == "" {
+            return defaultContentType
+        }
+        return byExtension
+    }
+    sniffed := http.DetectContentType(head)
+    // A recognised signature is more reliable than the name.
+    sniffedType, _, _ := mime.ParseMediaType(sniffed)
+    expectedType, _, _ := mime.ParseMediaType(byExtension)
+    if signatureTypes[sniffedType] && sniffedType != expectedType {
+        return sniffed
+    }
+    if byExtension != "" && contentMatchesExtension(ext, sniffed) {
+        return byExtension
+    }
+    return sniffed
+}
+
+// Sniff detects the type of the content read from r and returns a reader
+// that yields the whole content again, including the bytes sniffed.
+func (d ContentTypeDetector) Sniff(name string, r io.Reader) (string, io.Reader, error) {
+    head := make([]byte, sniffLen)
+    n, err := io.ReadFull(r, head)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+        return "", nil, err
+    }
+    head = head[:n]
+    return d.Detect(name, head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// IsTextType reports whether content of the given type is readable as text.
+func IsTextType(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return false
+    }
+    switch mediaType {
+    case "application/json", "application/xml", "application/yaml", "application/javascript":
+        return true
+    }
+    return strings.HasPrefix(mediaType, "text/") ||
+        strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
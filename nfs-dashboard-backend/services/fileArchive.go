@@ -0,0 +1,285 @@
+package services
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/gzip"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// ArchiveFormat is the container used for multi-file downloads.
+type ArchiveFormat string
+
+const (
+    ArchiveZip   ArchiveFormat = "zip"
+    ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+var (
+    // ErrArchiveTooLarge is returned when a selection exceeds the ArchiveLimits.
+    ErrArchiveTooLarge = errors.New("selection exceeds the archive size or entry limit")
+    // ErrNothingToArchive is returned when no readable entry was selected.
+    ErrNothingToArchive = errors.New("nothing to archive")
+)
+
+// ParseArchiveFormat parses a format name, defaulting to ArchiveZip.
+func ParseArchiveFormat(s string) (ArchiveFormat, error) {
+    switch strings.ToLower(s) {
+    case "", "zip":
+        return ArchiveZip, nil
+    case "tar.gz", "tgz":
+        return ArchiveTarGz, nil
+    }
+    return "", fmt.Errorf("unknown archive format %q", s)
+}
+
+// ContentType returns the MIME type of the format.
+func (f ArchiveFormat) ContentType() string {
+    if f == ArchiveTarGz {
+        return "application/gzip"
+    }
+    return "application/zip"
+}
+
+// Extension returns the file name extension of the format, without the dot.
+func (f ArchiveFormat) Extension() string {
+    return string(f)
+}
+
+// ArchiveLimits bounds what a single archive download may contain.
+type ArchiveLimits struct {
+    MaxBytes   int64 // total size of the files
+    MaxEntries int   // files and folders
+}
+
+// DefaultArchiveLimits keeps on-the-fly archives to a size a browser
+// download can reasonably be expected to finish.
+var DefaultArchiveLimits = ArchiveLimits{MaxBytes: 10 << 30, MaxEntries: 50000}
+
+type archiveEntry struct {
+    abs  string
+    name string // slash-separated path inside the archive
+    info os.FileInfo
+}
+
+// ArchivePlan is the list of entries to archive, collected and checked
+// against the limits before anything is sent to the client.
+type ArchivePlan struct {
+    entries []archiveEntry
+    Bytes   int64
+}
+
+// Entries returns how many files and folders the archive will contain.
+func (plan *ArchivePlan) Entries() int {
+    return len(plan.entries)
+}
+
+// PlanArchive collects the files and folders beneath paths that scope may
+// read. Each selected item becomes a top-level entry named after it, so the
+// structure beneath it is preserved. Symlinks to files inside the share are
+// archived as their target; symlinked folders are skipped to avoid loops.
+func (fs *FileService) PlanArchive(ctx context.Context, paths []string, scope *AccessScope, limits ArchiveLimits) (*ArchivePlan, error) {
+    plan := &ArchivePlan{}
+    used := make(map[string]bool)
+    for _, p := range paths {
+        item, err := fs.resolve(p)
+        if err != nil {
+            return nil, err
+        }
+        info, err := os.Stat(item.abs)
+        if err != nil {
+            return nil, err
+        }
+        top := filepath.Base(item.abs)
+        if item.IsRoot() {
+            top = item.root.Name
+        }
+        top = uniqueArchiveName(top, used)
+
+        if !info.IsDir() {
+            if scope == nil || (scope.Can(ActionRead, item.Virtual()) && scope.Can(ActionRead, item.realVirtual())) {
+                if err := plan.add(item.abs, top, info, limits); err != nil {
+                    return nil, err
+                }
+            }
+            continue
+        }
+
+        err = filepath.WalkDir(item.abs, func(abs string, d os.DirEntry, walkErr error) error {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if walkErr != nil {
+                if abs == item.abs {
+                    return walkErr
+                }
+                // Entries that vanish or cannot be read are left out.
+                if d != nil && d.IsDir() {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+            rel, err := filepath.Rel(item.root.Path, abs)
+            if err != nil {
+                return nil
+            }
+            entry := &resolvedPath{root: item.root, rel: filepath.ToSlash(rel), abs: abs}
+            if entry.rel == "." {
+                entry.rel = ""
+            }
+            if isInternal(entry.rel) {
+                return filepath.SkipDir
+            }
+            virtual := entry.Virtual()
+            if d.IsDir() && scope != nil && !scope.CanTraverse(virtual) {
+                return filepath.SkipDir
+            }
+            if scope != nil && !scope.Can(ActionRead, virtual) {
+                return nil
+            }
+
+            info, err := entryInfo(entry, d)
+            if err != nil || (d.Type()&os.ModeSymlink != 0 && info.IsDir()) {
+                return nil
+            }
+            if !info.IsDir() && !info.Mode().IsRegular() {
+                return nil
+            }
+            if d.Type()&os.ModeSymlink != 0 {
+                // The target must be readable too.
+                if scope != nil && !scope.Can(ActionRead, entry.realVirtual()) {
+                    return nil
+                }
+                if abs, err = filepath.EvalSymlinks(abs); err != nil {
+                    return nil
+                }
+            }
+            inner, err := filepath.Rel(item.abs, entry.abs)
+            if err != nil {
+                return nil
+            }
+            return plan.add(abs, path.Join(top, filepath.ToSlash(inner)), info, limits)
+        })
+        if err != nil {
+            return nil, err
+        }
+    }
+    if len(plan.entries) == 0 {
+        return nil, ErrNothingToArchive
+    }
+    return plan, nil
+}
+
+func (plan *ArchivePlan) add(abs, name string, info os.FileInfo, limits ArchiveLimits) error {
+    if info.Mode().IsRegular() {
+        plan.Bytes += info.Size()
+    }
+    plan.entries = append(plan.entries, archiveEntry{abs: abs, name: name, info: info})
+    if (limits.MaxBytes > 0 && plan.Bytes > limits.MaxBytes) ||
+        (limits.MaxEntries > 0 && len(plan.entries) > limits.MaxEntries) {
+        return ErrArchiveTooLarge
+    }
+    return nil
+}
+
+// uniqueArchiveName suffixes name so that selected items sharing a name do
+// not overwrite each other in the archive.
+func uniqueArchiveName(name string, used map[string]bool) string {
+    candidate := name
+    ext := path.Ext(name)
+    for i := 2; used[candidate]; i++ {
+        candidate = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), i, ext)
+    }
+    used[candidate] = true
+    return candidate
+}
+
+// Write streams the archive to w without staging it on disk. Errors after
+// the first byte cannot be reported to an HTTP client, so callers should
+// only log them; the truncated archive will fail to open.
+func (plan *ArchivePlan) Write(ctx context.Context, w io.Writer, format ArchiveFormat) error {
+    if format == ArchiveTarGz {
+        return plan.writeTarGz(ctx, w)
+    }
+    return plan.writeZip(ctx, w)
+}
+
+func (plan *ArchivePlan) writeZip(ctx context.Context, w io.Writer) error {
+    zw := zip.NewWriter(w)
+    for _, entry := range plan.entries {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        header, err := zip.FileInfoHeader(entry.info)
+        if err != nil {
+            return err
+        }
+        header.Name = entry.name
+        if entry.info.IsDir() {
+            header.Name += "/"
+        } else {
+            header.Method = zip.Deflate
+        }
+        out, err := zw.CreateHeader(header)
+        if err != nil {
+            return err
+        }
+        if !entry.info.IsDir() {
+            if err := copyArchiveEntry(out, entry); err != nil {
+                return err
+            }
+        }
+    }
+    return zw.Close()
+}
+
+func (plan *ArchivePlan) writeTarGz(ctx context.Context, w io.Writer) error {
+    gz := gzip.NewWriter(w)
+    tw := tar.NewWriter(gz)
+    for _, entry := range plan.entries {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        header, err := tar.FileInfoHeader(entry.info, "")
+        if err != nil {
+            return err
+        }
+        header.Name = entry.name
+        if entry.info.IsDir() {
+            header.Name += "/"
+        }
+        if err := tw.WriteHeader(header); err != nil {
+            return err
+        }
+        if !entry.info.IsDir() {
+            if err := copyArchiveEntry(tw, entry); err != nil {
+                return err
+            }
+        }
+    }
+    if err := tw.Close(); err != nil {
+        return err
+    }
+    return gz.Close()
+}
+
+// copyArchiveEntry copies exactly the planned size of a file, since tar
+// headers are written before the content and cannot be corrected.
+func copyArchiveEntry(w io.Writer, entry archiveEntry) error {
+    f, err := os.Open(entry.abs)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    if _, err := io.CopyN(w, f, entry.info.Size()); err != nil {
+        return fmt.Errorf("%s changed while archiving: %w", entry.name, err)
+    }
+    return nil
+}
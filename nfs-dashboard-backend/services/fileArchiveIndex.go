@@ -0,0 +1,473 @@
+package services
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "compress/bzip2"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+// archiveSeparator separates an archive's path from a member path inside
+// it, as in "/data/bundle.zip!/inner/file.txt".
+const archiveSeparator = "!"
+
+const (
+    // maxCachedArchiveIndexes bounds how many archive indexes are kept.
+    maxCachedArchiveIndexes = 32
+    // maxIndexedMembers bounds the size of a single archive index.
+    maxIndexedMembers = 200000
+)
+
+var (
+    // ErrArchiveReadOnly is returned when modifying a path inside an archive.
+    ErrArchiveReadOnly = errors.New("archive contents are read-only")
+    // ErrArchiveTooManyMembers is returned for archives too large to browse.
+    ErrArchiveTooManyMembers = errors.New("archive has too many entries to browse")
+)
+
+// splitArchivePath splits a cleaned virtual path into the archive holding it
+// and the slash-separated member path, which is "" for the archive's top
+// level. Each candidate archive path is put to isArchive in turn, so a folder
+// that merely has a name like "x.zip!" is not taken for one; nil accepts the
+// first candidate. ok is false for paths that do not point into an archive.
+func splitArchivePath(p string, isArchive func(archive string) bool) (archive, member string, ok bool) {
+    for i := strings.Index(p, archiveSeparator); i >= 0; {
+        rest := p[i+len(archiveSeparator):]
+        if (rest == "" || rest[0] == '/') && archiveKind(p[:i]) != "" && (isArchive == nil || isArchive(p[:i])) {
+            return p[:i], strings.Trim(rest, "/"), true
+        }
+        next := strings.Index(rest, archiveSeparator)
+        if next < 0 {
+            break
+        }
+        i += len(archiveSeparator) + next
+    }
+    return "", "", false
+}
+
+// archivePath is splitArchivePath for paths of this service: only regular
+// files, symlinks to them included, are taken for archives.
+func (fs *FileService) archivePath(p string) (archive, member string, ok bool) {
+    return splitArchivePath(p, fs.IsArchive)
+}
+
+// IsArchive reports whether p is a regular file, or a symlink to one, that
+// paths may lead into. The name decides whether it is an archive at all.
+func (fs *FileService) IsArchive(p string) bool {
+    item, err := fs.resolve(p)
+    if err != nil {
+        return false
+    }
+    info, err := os.Stat(item.abs)
+    return err == nil && info.Mode().IsRegular()
+}
+
+// archiveMember is one file or folder inside an indexed archive.
+type archiveMember struct {
+    name     string // slash-separated path inside the archive
+    isDir    bool
+    size     int64
+    modTime  time.Time
+    zipIndex int   // position in the zip directory, -1 for tar
+    offset   int64 // data offset in an uncompressed tar, -1 if unknown
+}
+
+// memberInfo adapts an archiveMember to os.FileInfo.
+type memberInfo struct{ m *archiveMember }
+
+func (i memberInfo) Name() string       { return path.Base(i.m.name) }
+func (i memberInfo) Size() int64        { return i.m.size }
+func (i memberInfo) ModTime() time.Time { return i.m.modTime }
+func (i memberInfo) IsDir() bool        { return i.m.isDir }
+func (i memberInfo) Sys() interface{}   { return nil }
+func (i memberInfo) Mode() os.FileMode {
+    if i.m.isDir {
+        return os.ModeDir | 0555
+    }
+    return 0444
+}
+
+// archiveIndex lists the members of an archive as it was at modTime.
+type archiveIndex struct {
+    kind     string
+    modTime  time.Time
+    size     int64
+    members  map[string]*archiveMember
+    children map[string][]string // folder -> sorted child names
+    lastUsed time.Time
+}
+
+// add records a member and the folders implied by its path.
+func (idx *archiveIndex) add(m *archiveMember) {
+    if existing, ok := idx.members[m.name]; ok {
+        // The first entry of a name wins, except that an explicit folder
+        // entry replaces one implied by an earlier member's path.
+        if existing.isDir && m.isDir {
+            *existing = *m
+        }
+        return
+    }
+    idx.members[m.name] = m
+    parent := path.Dir(m.name)
+    if parent == "." {
+        parent = ""
+    }
+    idx.children[parent] = append(idx.children[parent], path.Base(m.name))
+    if parent != "" {
+        if _, ok := idx.members[parent]; !ok {
+            idx.add(&archiveMember{name: parent, isDir: true, modTime: m.modTime, zipIndex: -1, offset: -1})
+        }
+    }
+}
+
+// archiveIndexCache keeps recently used archive indexes, each valid for as
+// long as the archive's size and modification time are unchanged.
+type archiveIndexCache struct {
+    mu      sync.Mutex
+    indexes map[string]*archiveIndex
+}
+
+func newArchiveIndexCache() *archiveIndexCache {
+    return &archiveIndexCache{indexes: make(map[string]*archiveIndex)}
+}
+
+// get returns the index of the archive at abs, building it if needed.
+func (c *archiveIndexCache) get(abs string, info os.FileInfo) (*archiveIndex, error) {
+    c.mu.Lock()
+    idx, ok := c.indexes[abs]
+    if ok && idx.modTime.Equal(info.ModTime()) && idx.size == info.Size() {
+        idx.lastUsed = time.Now()
+        c.mu.Unlock()
+        return idx, nil
+    }
+    c.mu.Unlock()
+
+    // Build without holding the lock; a concurrent build of the same archive
+    // only costs a second scan.
+    idx, err := buildArchiveIndex(abs, info)
+    if err != nil {
+        return nil, err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.indexes[abs] = idx
+    for len(c.indexes) > maxCachedArchiveIndexes {
+        var oldest string
+        for key, cached := range c.indexes {
+            if oldest == "" || cached.lastUsed.Before(c.indexes[oldest].lastUsed) {
+                oldest = key
+            }
+        }
+        delete(c.indexes, oldest)
+    }
+    return idx, nil
+}
+
+func buildArchiveIndex(abs string, info os.FileInfo) (*archiveIndex, error) {
+    idx := &archiveIndex{
+        kind:     archiveKind(info.Name()),
+        modTime:  info.ModTime(),
+        size:     info.Size(),
+        members:  make(map[string]*archiveMember),
+        children: make(map[string][]string),
+        lastUsed: time.Now(),
+    }
+    var err error
+    if idx.kind == ".zip" {
+        err = idx.scanZip(abs)
+    } else {
+        err = idx.scanTar(abs)
+    }
+    if err != nil {
+        return nil, err
+    }
+    for dir := range idx.children {
+        sort.Strings(idx.children[dir])
+    }
+    return idx, nil
+}
+
+// memberName cleans a member name, rejecting absolute names and ones that
+// climb out of the archive. Such members are left out of the index.
+func memberName(name string) (string, bool) {
+    name = strings.ReplaceAll(name, "\\", "/")
+    if path.IsAbs(name) {
+        return "", false
+    }
+    for _, seg := range strings.Split(name, "/") {
+        if seg == ".." {
+            return "", false
+        }
+    }
+    clean := path.Clean(name)
+    return clean, clean != "."
+}
+
+func (idx *archiveIndex) scanZip(abs string) error {
+    r, err := zip.OpenReader(abs)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+    }
+    defer r.Close()
+    if len(r.File) > maxIndexedMembers {
+        return ErrArchiveTooManyMembers
+    }
+    for i, f := range r.File {
+        name, ok := memberName(f.Name)
+        if !ok {
+            continue
+        }
+        mode := f.Mode()
+        if !mode.IsDir() && !mode.IsRegular() {
+            continue
+        }
+        idx.add(&archiveMember{
+            name:     name,
+            isDir:    mode.IsDir(),
+            size:     int64(f.UncompressedSize64),
+            modTime:  f.Modified,
+            zipIndex: i,
+            offset:   -1,
+        })
+    }
+    return nil
+}
+
+func (idx *archiveIndex) scanTar(abs string) error {
+    tr, closer, counted, err := openTarStream(abs, idx.kind)
+    if err != nil {
+        return err
+    }
+    defer closer.Close()
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+        }
+        if header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+            continue
+        }
+        name, ok := memberName(header.Name)
+        if !ok {
+            continue
+        }
+        if len(idx.members) >= maxIndexedMembers {
+            return ErrArchiveTooManyMembers
+        }
+        // In an uncompressed tar the reader stops right at the member's data,
+        // which lets members be opened later without rescanning.
+        offset := int64(-1)
+        if idx.kind == ".tar" {
+            offset = counted.n
+        }
+        idx.add(&archiveMember{
+            name:     name,
+            isDir:    header.Typeflag == tar.TypeDir,
+            size:     header.Size,
+            modTime:  header.ModTime,
+            zipIndex: -1,
+            offset:   offset,
+        })
+    }
+}
+
+// openTarStream opens a tar archive for sequential reading, decompressing
+// it as its kind requires.
+func openTarStream(abs, kind string) (*tar.Reader, io.Closer, *countingReader, error) {
+    file, err := os.Open(abs)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    counted := &countingReader{r: file}
+    var stream io.Reader = counted
+    switch kind {
+    case ".tar.gz", ".tgz":
+        gz, err := gzip.NewReader(counted)
+        if err != nil {
+            file.Close()
+            return nil, nil, nil, fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+        }
+        stream = gz
+    case ".tar.bz2", ".tbz2":
+        stream = bzip2.NewReader(counted)
+    }
+    return tar.NewReader(stream), file, counted, nil
+}
+
+// archiveMemberAt resolves a virtual path inside an archive to the archive
+// file and the indexed member; member is nil for the archive's top level.
+func (fs *FileService) archiveMemberAt(archivePath, memberPath string) (*resolvedPath, *archiveIndex, *archiveMember, error) {
+    archive, err := fs.resolve(archivePath)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    info, err := os.Stat(archive.abs)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    if info.IsDir() {
+        return nil, nil, nil, ErrUnsupportedArchive
+    }
+    idx, err := fs.archives.get(archive.abs, info)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    if memberPath == "" {
+        return archive, idx, nil, nil
+    }
+    member, ok := idx.members[memberPath]
+    if !ok {
+        return nil, nil, nil, os.ErrNotExist
+    }
+    return archive, idx, member, nil
+}
+
+// archiveFile builds the API representation of an archive member.
+func archiveFile(archive *resolvedPath, m *archiveMember) models.File {
+    return models.File{
+        Name:         path.Base(m.name),
+        Path:         archive.Virtual() + archiveSeparator + "/" + m.name,
+        IsDir:        m.isDir,
+        Size:         m.size,
+        LastModified: m.modTime,
+    }
+}
+
+// listArchive lists a folder inside an archive.
+func (fs *FileService) listArchive(archivePath, memberPath string) ([]models.File, error) {
+    archive, idx, member, err := fs.archiveMemberAt(archivePath, memberPath)
+    if err != nil {
+        return nil, err
+    }
+    if member != nil && !member.isDir {
+        return nil, errors.New("provided path is not a directory")
+    }
+    files := []models.File{}
+    for _, name := range idx.children[memberPath] {
+        files = append(files, archiveFile(archive, idx.members[path.Join(memberPath, name)]))
+    }
+    return files, nil
+}
+
+// archiveFileInfo returns metadata for an archive member. The archive's top
+// level is reported as a folder named after the archive.
+func (fs *FileService) archiveFileInfo(archivePath, memberPath string) (*models.File, error) {
+    archive, idx, member, err := fs.archiveMemberAt(archivePath, memberPath)
+    if err != nil {
+        return nil, err
+    }
+    if member == nil {
+        member = &archiveMember{name: path.Base(archive.abs), isDir: true, modTime: idx.modTime}
+        file := archiveFile(archive, member)
+        file.Path = archive.Virtual() + archiveSeparator
+        return &file, nil
+    }
+    file := archiveFile(archive, member)
+    return &file, nil
+}
+
+// sectionFile serves a byte range of an open file, so members stored
+// without compression can be read with seeking.
+type sectionFile struct {
+    *io.SectionReader
+    io.Closer
+}
+
+// streamFile pairs a member's content with the resources backing it.
+type streamFile struct {
+    io.Reader
+    closers []io.Closer
+}
+
+func (s *streamFile) Close() error {
+    var first error
+    for _, c := range s.closers {
+        if err := c.Close(); err != nil && first == nil {
+            first = err
+        }
+    }
+    return first
+}
+
+// openArchiveMember opens a file inside an archive for reading. The result
+// also implements io.Seeker for members of uncompressed tar archives and
+// members stored uncompressed in zip archives.
+func (fs *FileService) openArchiveMember(archivePath, memberPath string) (io.ReadCloser, os.FileInfo, error) {
+    archive, idx, member, err := fs.archiveMemberAt(archivePath, memberPath)
+    if err != nil {
+        return nil, nil, err
+    }
+    if member == nil || member.isDir {
+        return nil, nil, errors.New("provided path is a directory")
+    }
+    info := memberInfo{member}
+
+    if idx.kind == ".zip" {
+        r, err := zip.OpenReader(archive.abs)
+        if err != nil {
+            return nil, nil, err
+        }
+        if member.zipIndex >= len(r.File) {
+            r.Close()
+            return nil, nil, os.ErrNotExist
+        }
+        f := r.File[member.zipIndex]
+        if f.Method == zip.Store {
+            if offset, err := f.DataOffset(); err == nil {
+                r.Close()
+                return openSection(archive.abs, offset, member.size, info)
+            }
+        }
+        content, err := f.Open()
+        if err != nil {
+            r.Close()
+            return nil, nil, err
+        }
+        return &streamFile{Reader: content, closers: []io.Closer{content, r}}, info, nil
+    }
+
+    if member.offset >= 0 {
+        return openSection(archive.abs, member.offset, member.size, info)
+    }
+    // Compressed tarballs can only be read from the start.
+    tr, closer, _, err := openTarStream(archive.abs, idx.kind)
+    if err != nil {
+        return nil, nil, err
+    }
+    for {
+        header, err := tr.Next()
+        if err != nil {
+            closer.Close()
+            if err == io.EOF {
+                return nil, nil, os.ErrNotExist
+            }
+            return nil, nil, err
+        }
+        if name, ok := memberName(header.Name); ok && name == member.name &&
+            (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) {
+            return &streamFile{Reader: tr, closers: []io.Closer{closer}}, info, nil
+        }
+    }
+}
+
+func openSection(abs string, offset, size int64, info os.FileInfo) (io.ReadCloser, os.FileInfo, error) {
+    file, err := os.Open(abs)
+    if err != nil {
+        return nil, nil, err
+    }
+    return sectionFile{SectionReader: io.NewSectionReader(file, offset, size), Closer: file}, info, nil
+}
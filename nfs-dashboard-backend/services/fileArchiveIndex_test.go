@@ -0,0 +1,200 @@
+package services
+
+import (
+    "archive/zip"
+    "errors"
+    "io"
+    "os"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestSplitArchivePath(t *testing.T) {
+    tests := []struct {
+        in      string
+        archive string
+        member  string
+        ok      bool
+    }{
+        {"/data/bundle.zip!", "/data/bundle.zip", "", true},
+        {"/data/bundle.zip!/", "/data/bundle.zip", "", true},
+        {"/data/bundle.zip!/inner/note.txt", "/data/bundle.zip", "inner/note.txt", true},
+        {"/data/Backup.TAR.GZ!/a", "/data/Backup.TAR.GZ", "a", true},
+        {"/data/bundle.zip", "", "", false},
+        {"/data/wow!/bundle.zip!/a", "/data/wow!/bundle.zip", "a", true},
+        {"/data/bundle.zip!x/a", "", "", false},
+        {"/data/notes.txt!/a", "", "", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.in, func(t *testing.T) {
+            archive, member, ok := splitArchivePath(tt.in, nil)
+            if archive != tt.archive || member != tt.member || ok != tt.ok {
+                t.Errorf("splitArchivePath(%q) = %q, %q, %v, want %q, %q, %v", tt.in, archive, member, ok, tt.archive, tt.member, tt.ok)
+            }
+        })
+    }
+
+    // Candidates that are not archives are passed over.
+    isArchive := func(archive string) bool { return archive == "/data/x.zip!/y.zip" }
+    archive, member, ok := splitArchivePath("/data/x.zip!/y.zip!/a", isArchive)
+    if archive != "/data/x.zip!/y.zip" || member != "a" || !ok {
+        t.Errorf("split past a folder = %q, %q, %v, want the inner archive", archive, member, ok)
+    }
+    if _, _, ok := splitArchivePath("/data/x.zip!/a", isArchive); ok {
+        t.Error("a folder named x.zip! was taken for an archive")
+    }
+}
+
+// writeContentZip writes a zip holding the given files, stored without
+// compression when their name starts with "stored".
+func writeContentZip(t *testing.T, file string, files map[string]string) {
+    out, err := os.Create(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer out.Close()
+    zw := zip.NewWriter(out)
+    for name, content := range files {
+        header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+        if strings.HasPrefix(name, "stored") {
+            header.Method = zip.Store
+        }
+        w, err := zw.CreateHeader(header)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := w.Write([]byte(content)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func listNames(t *testing.T, fs *FileService, p string) string {
+    page, err := fs.ListDirectory(p, ListOptions{})
+    if err != nil {
+        t.Fatalf("ListDirectory(%q): %v", p, err)
+    }
+    var names []string
+    for _, f := range page.Files {
+        names = append(names, f.Name)
+    }
+    return strings.Join(names, " ")
+}
+
+func TestArchiveMembers(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeContentZip(t, diskPath(fs, "docs/bundle.zip"), map[string]string{
+        "inner/note.txt": "hello\n",
+        "stored.txt":     "plain\n",
+    })
+    writeTar(t, diskPath(fs, "docs/bundle.tar"), []testEntry{{"dir/", 0}, {"dir/a.bin", 3}})
+
+    for p, want := range map[string]string{
+        "/data/docs/bundle.zip!":       "inner stored.txt",
+        "/data/docs/bundle.zip!/inner": "note.txt",
+        "/data/docs/bundle.tar!/dir":   "a.bin",
+    } {
+        if got := listNames(t, fs, p); got != want {
+            t.Errorf("listing of %s = %q, want %q", p, got, want)
+        }
+    }
+
+    for p, want := range map[string]string{
+        "/data/docs/bundle.zip!/inner/note.txt": "hello\n",
+        "/data/docs/bundle.zip!/stored.txt":     "plain\n",
+        "/data/docs/bundle.tar!/dir/a.bin":      "\x00\x00\x00",
+    } {
+        content, info, err := fs.OpenFile(p)
+        if err != nil {
+            t.Errorf("OpenFile(%q): %v", p, err)
+            continue
+        }
+        data, err := io.ReadAll(content)
+        content.Close()
+        if err != nil || string(data) != want || info.Size() != int64(len(want)) {
+            t.Errorf("%s = %q (size %d), %v, want %q", p, data, info.Size(), err, want)
+        }
+    }
+    // Stored members can be read with seeking.
+    content, _, err := fs.OpenFile("/data/docs/bundle.zip!/stored.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := content.(io.Seeker); !ok {
+        t.Error("stored member is not seekable")
+    }
+    content.Close()
+
+    if _, _, err := fs.OpenFile("/data/docs/bundle.zip!/missing.txt"); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("opening a missing member: error = %v, want it not to exist", err)
+    }
+    if _, _, err := fs.OpenFile("/data/docs/bundle.zip!/inner"); err == nil {
+        t.Error("opened a folder inside the archive")
+    }
+    if _, err := fs.CreateFolder("/data/docs/bundle.zip!", "new", "2"); err != ErrArchiveReadOnly {
+        t.Errorf("writing into an archive: error = %v, want %v", err, ErrArchiveReadOnly)
+    }
+}
+
+func TestFolderNamedLikeArchive(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    if _, err := fs.CreateFolder("/data/docs", "x.zip!", "2"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.UploadFile("/data/docs/x.zip!", "a.txt", "2", strings.NewReader("a\n"), nil); err != nil {
+        t.Fatal(err)
+    }
+    if got := listNames(t, fs, "/data/docs/x.zip!"); got != "a.txt" {
+        t.Errorf("listing = %q, want a.txt", got)
+    }
+    if _, err := fs.RenameItem("/data/docs/x.zip!/a.txt", "b.txt", "2"); err != nil {
+        t.Errorf("rename inside the folder: %v", err)
+    }
+    if _, err := fs.DeleteItem("/data/docs/x.zip!", "2"); err != nil {
+        t.Errorf("delete of the folder: %v", err)
+    }
+}
+
+func TestPermissionsOfFolderNamedLikeArchive(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    if err := os.Mkdir(diskPath(fs, "docs/x.zip!"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, fs, "docs/x.zip!/a.txt", "a\n")
+    writeContentZip(t, diskPath(fs, "docs/y.zip"), map[string]string{"a.txt": "a\n"})
+    roles := stubRoles{{Name: "staff", Permissions: []string{"read:/data/docs/x.zip", "read:/data/docs/y.zip"}}}
+    ps := NewPermissionService(roles, fs)
+    staff := &models.User{ID: "2", Role: &models.Role{Name: "staff"}}
+    scope, err := ps.ScopeFor(staff)
+    if err != nil {
+        t.Fatal(err)
+    }
+    limited := scope.Within("/data/docs/x.zip", "/data/docs/y.zip")
+
+    tests := []struct {
+        path string
+        want bool
+    }{
+        // A grant on x.zip does not cover a folder named x.zip!.
+        {"/data/docs/x.zip!/a.txt", false},
+        // Members of a real archive are governed by the archive.
+        {"/data/docs/y.zip!/a.txt", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.path, func(t *testing.T) {
+            if err := ps.Check(staff, ActionRead, tt.path); (err == nil) != tt.want {
+                t.Errorf("Check(read, %q) = %v, want allowed %v", tt.path, err, tt.want)
+            }
+            if got := scope.Can(ActionRead, tt.path); got != tt.want {
+                t.Errorf("Can(read, %q) = %v, want %v", tt.path, got, tt.want)
+            }
+            if got := limited.Can(ActionRead, tt.path); got != tt.want {
+                t.Errorf("Can(read, %q) within the grants = %v, want %v", tt.path, got, tt.want)
+            }
+        })
+    }
+}
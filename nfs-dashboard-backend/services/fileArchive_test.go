@@ -0,0 +1,149 @@
+package services
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "bytes"
+    "compress/gzip"
+    "context"
+    "io"
+    "sort"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+// archiveContents writes plan in format and reads it back as a map of
+// entry names to contents, folders mapping to "".
+func archiveContents(t *testing.T, plan *ArchivePlan, format ArchiveFormat) map[string]string {
+    var buf bytes.Buffer
+    if err := plan.Write(context.Background(), &buf, format); err != nil {
+        t.Fatal(err)
+    }
+    contents := make(map[string]string)
+    if format == ArchiveZip {
+        zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, f := range zr.File {
+            r, err := f.Open()
+            if err != nil {
+                t.Fatal(err)
+            }
+            data, err := io.ReadAll(r)
+            r.Close()
+            if err != nil {
+                t.Fatal(err)
+            }
+            contents[f.Name] = string(data)
+        }
+        return contents
+    }
+    gz, err := gzip.NewReader(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    tr := tar.NewReader(gz)
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return contents
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, err := io.ReadAll(tr)
+        if err != nil {
+            t.Fatal(err)
+        }
+        contents[header.Name] = string(data)
+    }
+}
+
+func entryNames(contents map[string]string) string {
+    var names []string
+    for name := range contents {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return strings.Join(names, " ")
+}
+
+func TestArchiveFolder(t *testing.T) {
+    for _, format := range []ArchiveFormat{ArchiveZip, ArchiveTarGz} {
+        t.Run(string(format), func(t *testing.T) {
+            fs := newTestFiles(t, models.SystemSettings{})
+            writeTestFile(t, fs, "docs/sub/a.txt", "a\n")
+            plan, err := fs.PlanArchive(context.Background(), []string{"/data/docs"}, nil, DefaultArchiveLimits)
+            if err != nil {
+                t.Fatal(err)
+            }
+            // The symlinks to outside the share and to the internal area
+            // are left out.
+            contents := archiveContents(t, plan, format)
+            if got, want := entryNames(contents), "docs/ docs/readme.txt docs/sub/ docs/sub/a.txt"; got != want {
+                t.Errorf("entries = %q, want %q", got, want)
+            }
+            if contents["docs/sub/a.txt"] != "a\n" {
+                t.Errorf("docs/sub/a.txt = %q", contents["docs/sub/a.txt"])
+            }
+        })
+    }
+}
+
+func TestArchiveSelection(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "docs/sub/a.txt", "a\n")
+    writeTestFile(t, fs, "docs/sub/b.txt", "b\n")
+    tests := []struct {
+        name  string
+        paths []string
+        scope *AccessScope
+        want  string
+    }{
+        {"items sharing a name", []string{"/data/docs/readme.txt", "/data/link/readme.txt"}, nil, "readme (2).txt readme.txt"},
+        {"share root", []string{"/data"}, &AccessScope{permissions: []string{"read:/data/docs/sub/a.txt"}}, "data/docs/sub/a.txt"},
+        {"unreadable items", []string{"/data/docs/sub"}, &AccessScope{permissions: []string{"read:/data/docs/sub/b.txt"}}, "sub/b.txt"},
+    }
+    // Folders that are only passed through on the way to readable files
+    // get no entries of their own.
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            plan, err := fs.PlanArchive(context.Background(), tt.paths, tt.scope, DefaultArchiveLimits)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got := entryNames(archiveContents(t, plan, ArchiveZip)); got != tt.want {
+                t.Errorf("entries = %q, want %q", got, tt.want)
+            }
+        })
+    }
+
+    unreadable := &AccessScope{permissions: []string{"read:/data/other"}}
+    if _, err := fs.PlanArchive(context.Background(), []string{"/data/docs/readme.txt"}, unreadable, DefaultArchiveLimits); err != ErrNothingToArchive {
+        t.Errorf("nothing readable: error = %v, want %v", err, ErrNothingToArchive)
+    }
+}
+
+func TestArchiveLimits(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "docs/sub/a.txt", "a\n")
+    tests := []struct {
+        name   string
+        limits ArchiveLimits
+        err    error
+    }{
+        {"within limits", ArchiveLimits{MaxBytes: 7, MaxEntries: 4}, nil},
+        {"too many bytes", ArchiveLimits{MaxBytes: 6}, ErrArchiveTooLarge},
+        {"too many entries", ArchiveLimits{MaxEntries: 3}, ErrArchiveTooLarge},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := fs.PlanArchive(context.Background(), []string{"/data/docs"}, nil, tt.limits)
+            if err != tt.err {
+                t.Errorf("error = %v, want %v", err, tt.err)
+            }
+        })
+    }
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/user"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "nfs-dashboard-backend/models"
+)
+
+var (
+    // ErrInvalidMode is returned for modes that are neither octal nor
+    // symbolic chmod modes.
+    ErrInvalidMode = errors.New("invalid mode")
+    // ErrUnknownAccount is returned for owners or groups that do not exist.
+    ErrUnknownAccount = errors.New("unknown user or group")
+    // ErrSpecialModeBits is returned when a caller without SpecialBits tries
+    // to set the setuid, setgid or sticky bit.
+    ErrSpecialModeBits = errors.New("only admins can set the setuid, setgid and sticky bits")
+)
+
+// maxReportedChanges bounds the entries listed in an AttributeChangeResult;
+// the counts always cover every entry.
+const maxReportedChanges = 1000
+
+// AttributeOptions controls ChangeMode and ChangeOwner.
+type AttributeOptions struct {
+    Recursive   bool
+    DryRun      bool
+    Actor       string // user ID recorded in the audit log
+    SpecialBits bool   // may set the setuid, setgid and sticky bits
+}
+
+// attributePlan describes the change to one entry: its attributes before
+// and after, and how to apply it. A nil apply leaves the entry alone.
+// Folders change after the entries beneath them unless early is set.
+type attributePlan struct {
+    before, after string
+    apply         func() error
+    early         bool // the folder stays listable, so it may change first
+}
+
+// ChangeMode sets the mode of p, and with Recursive of everything beneath
+// it, to mode. Symlinks inside the tree are left alone. Without SpecialBits,
+// modes that could set the setuid, setgid or sticky bit are refused.
+func (fs *FileService) ChangeMode(p, mode string, opts AttributeOptions) (*models.AttributeChangeResult, error) {
+    change, err := parseModeSpec(mode)
+    if err != nil {
+        return nil, err
+    }
+    if !opts.SpecialBits && setsSpecialBits(mode) {
+        return nil, ErrSpecialModeBits
+    }
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    result, err := fs.changeAttributes(item, opts, func(abs string, info os.FileInfo) attributePlan {
+        before := unixPermissions(info.Mode())
+        after := change(before, info.IsDir())
+        return attributePlan{
+            before: fmt.Sprintf("%04o", before),
+            after:  fmt.Sprintf("%04o", after),
+            apply:  func() error { return os.Chmod(abs, fileModeFromUnix(after)) },
+            // Granting read and search first lets the walk into a folder
+            // that was closed to its owner.
+            early: after&0500 == 0500,
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+    fs.recordAttributeChange("chmod", fmt.Sprintf("mode of %s to %s", item.Virtual(), mode), opts, result)
+    return result, nil
+}
+
+// ChangeOwner sets the owner and group of p, and with Recursive of
+// everything beneath it. Either may be empty to keep it, and each is a name
+// or a numeric id. Symlinks inside the tree are left alone.
+func (fs *FileService) ChangeOwner(p, owner, group string, opts AttributeOptions) (*models.AttributeChangeResult, error) {
+    if owner == "" && group == "" {
+        return nil, errors.New("owner or group is required")
+    }
+    uid, err := lookupAccountID(owner, func(name string) (string, error) {
+        u, err := user.Lookup(name)
+        if err != nil {
+            return "", err
+        }
+        return u.Uid, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    gid, err := lookupAccountID(group, func(name string) (string, error) {
+        g, err := user.LookupGroup(name)
+        if err != nil {
+            return "", err
+        }
+        return g.Gid, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+
+    result, err := fs.changeAttributes(item, opts, func(abs string, info os.FileInfo) attributePlan {
+        details, ok := statDetailsOf(info)
+        if !ok {
+            return attributePlan{before: "?", after: "?", apply: func() error {
+                return errors.New("ownership is not supported on this platform")
+            }}
+        }
+        newUID, newGID := details.uid, details.gid
+        if uid >= 0 {
+            newUID = uint32(uid)
+        }
+        if gid >= 0 {
+            newGID = uint32(gid)
+        }
+        return attributePlan{
+            before: ownerString(details.uid, details.gid),
+            after:  ownerString(newUID, newGID),
+            apply:  func() error { return os.Chown(abs, uid, gid) },
+        }
+    })
+    if err != nil {
+        return nil, err
+    }
+    target := owner
+    if group != "" {
+        target += ":" + group
+    }
+    fs.recordAttributeChange("chown", fmt.Sprintf("owner of %s to %s", item.Virtual(), target), opts, result)
+    return result, nil
+}
+
+// changeAttributes plans and, unless it is a dry run, applies a change to
+// item and with Recursive to the tree beneath it. Failures on single
+// entries are collected in the result instead of stopping the run.
+func (fs *FileService) changeAttributes(item *resolvedPath, opts AttributeOptions, plan func(abs string, info os.FileInfo) attributePlan) (*models.AttributeChangeResult, error) {
+    top, err := os.Stat(item.abs)
+    if err != nil {
+        return nil, err
+    }
+    if !opts.DryRun {
+        if err := fs.checkUnlocked(item, opts.Actor); err != nil {
+            return nil, err
+        }
+    }
+    result := &models.AttributeChangeResult{DryRun: opts.DryRun, Changes: []models.AttributeChange{}}
+    record := func(change models.AttributeChange) {
+        if len(result.Changes) < maxReportedChanges {
+            result.Changes = append(result.Changes, change)
+        } else {
+            result.Truncated = true
+        }
+    }
+    visit := func(virtual string, step attributePlan) {
+        if step.before == step.after {
+            result.Unchanged++
+            return
+        }
+        change := models.AttributeChange{Path: virtual, Before: step.before, After: step.after}
+        if !opts.DryRun {
+            if err := step.apply(); err != nil {
+                change.Error = err.Error()
+                result.Failed++
+                record(change)
+                return
+            }
+        }
+        result.Changed++
+        record(change)
+    }
+    fail := func(virtual string, err error) {
+        result.Failed++
+        record(models.AttributeChange{Path: virtual, Error: err.Error()})
+    }
+
+    // Folders change after everything beneath them, deepest first, so
+    // removing read or search permission from one does not stop the walk or
+    // the changes to its children. Folders that stay listable change first.
+    type folder struct {
+        virtual string
+        step    attributePlan
+    }
+    var folders []folder
+    schedule := func(virtual string, info os.FileInfo, step attributePlan) {
+        if info.IsDir() && !step.early {
+            folders = append(folders, folder{virtual, step})
+        } else {
+            visit(virtual, step)
+        }
+    }
+    schedule(item.Virtual(), top, plan(item.abs, top))
+    if opts.Recursive && top.IsDir() {
+        internal := filepath.Join(item.root.Path, internalDirName)
+        filepath.WalkDir(item.abs, func(abs string, d os.DirEntry, err error) error {
+            if abs == item.abs {
+                if err != nil {
+                    fail(item.Virtual(), err)
+                }
+                return nil
+            }
+            rel, _ := filepath.Rel(item.abs, abs)
+            virtual := path.Join(item.Virtual(), filepath.ToSlash(rel))
+            if err != nil {
+                fail(virtual, err)
+                return nil
+            }
+            if abs == internal {
+                return filepath.SkipDir
+            }
+            if d.Type()&os.ModeSymlink != 0 {
+                return nil
+            }
+            info, err := d.Info()
+            if err != nil {
+                if !os.IsNotExist(err) {
+                    fail(virtual, err)
+                }
+                return nil
+            }
+            schedule(virtual, info, plan(abs, info))
+            return nil
+        })
+    }
+    for i := len(folders) - 1; i >= 0; i-- {
+        visit(folders[i].virtual, folders[i].step)
+    }
+    return result, nil
+}
+
+// recordAttributeChange writes the audit log entry of a chmod or chown run.
+func (fs *FileService) recordAttributeChange(action, what string, opts AttributeOptions, result *models.AttributeChangeResult) {
+    if fs.audit == nil {
+        return
+    }
+    details := "Changed " + what
+    if opts.DryRun {
+        details = "Dry run: change " + what
+    }
+    if opts.Recursive {
+        details += " recursively"
+    }
+    details += fmt.Sprintf(" (%d changed, %d failed)", result.Changed, result.Failed)
+    fs.audit.RecordAuditLog(action, opts.Actor, details)
+}
+
+// lookupAccountID resolves a user or group given by name or numeric id, or
+// returns -1 for "" to keep the current one.
+func lookupAccountID(account string, lookup func(name string) (string, error)) (int, error) {
+    if account == "" {
+        return -1, nil
+    }
+    id := account
+    if _, err := strconv.ParseUint(account, 10, 32); err != nil {
+        if id, err = lookup(account); err != nil {
+            return 0, fmt.Errorf("%w: %s", ErrUnknownAccount, account)
+        }
+    }
+    n, err := strconv.ParseUint(id, 10, 32)
+    if err != nil {
+        return 0, fmt.Errorf("%w: %s", ErrUnknownAccount, account)
+    }
+    return int(n), nil
+}
+
+// ownerString formats ownership as "owner:group", using names where known.
+func ownerString(uid, gid uint32) string {
+    owner, group := accountNames.user(uid), accountNames.group(gid)
+    if owner == "" {
+        owner = strconv.FormatUint(uint64(uid), 10)
+    }
+    if group == "" {
+        group = strconv.FormatUint(uint64(gid), 10)
+    }
+    return owner + ":" + group
+}
+
+// parseModeSpec parses an octal mode such as "0755" or a symbolic one as
+// chmod accepts it, such as "u+x,go-w" or "a=rX", into a function giving
+// the new permission bits of a file from its current ones.
+func parseModeSpec(spec string) (func(bits uint32, isDir bool) uint32, error) {
+    spec = strings.TrimSpace(spec)
+    if spec == "" {
+        return nil, ErrInvalidMode
+    }
+    if spec[0] >= '0' && spec[0] <= '7' {
+        bits, err := strconv.ParseUint(spec, 8, 32)
+        if err != nil || bits > 07777 {
+            return nil, fmt.Errorf("%w: %s", ErrInvalidMode, spec)
+        }
+        return func(uint32, bool) uint32 { return uint32(bits) }, nil
+    }
+
+    type operation struct {
+        who   uint32
+        op    byte
+        perms string
+    }
+    var ops []operation
+    for _, clause := range strings.Split(spec, ",") {
+        i := 0
+        var who uint32
+        for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+            who |= map[byte]uint32{'u': 04700, 'g': 02070, 'o': 01007, 'a': 07777}[clause[i]]
+        }
+        if who == 0 {
+            who = 07777
+        }
+        if i == len(clause) {
+            return nil, fmt.Errorf("%w: %s", ErrInvalidMode, spec)
+        }
+        for i < len(clause) {
+            op := clause[i]
+            if op != '+' && op != '-' && op != '=' {
+                return nil, fmt.Errorf("%w: %s", ErrInvalidMode, spec)
+            }
+            j := i + 1
+            for ; j < len(clause) && strings.IndexByte("rwxXst", clause[j]) >= 0; j++ {
+            }
+            ops = append(ops, operation{who: who, op: op, perms: clause[i+1 : j]})
+            i = j
+        }
+    }
+
+    return func(bits uint32, isDir bool) uint32 {
+        for _, o := range ops {
+            var perms uint32
+            for _, c := range o.perms {
+                switch c {
+                case 'r':
+                    perms |= 0444
+                case 'w':
+                    perms |= 0222
+                case 'x':
+                    perms |= 0111
+                case 'X':
+                    // Execute only for folders and already executable files.
+                    if isDir || bits&0111 != 0 {
+                        perms |= 0111
+                    }
+                case 's':
+                    perms |= 06000
+                case 't':
+                    perms |= 01000
+                }
+            }
+            perms &= o.who
+            switch o.op {
+            case '+':
+                bits |= perms
+            case '-':
+                bits &^= perms
+            case '=':
+                bits = bits&^o.who | perms
+            }
+        }
+        return bits
+    }, nil
+}
+
+// setsSpecialBits reports whether a mode parseModeSpec accepted can set the
+// setuid, setgid or sticky bit.
+func setsSpecialBits(spec string) bool {
+    spec = strings.TrimSpace(spec)
+    if spec[0] >= '0' && spec[0] <= '7' {
+        bits, _ := strconv.ParseUint(spec, 8, 32)
+        return bits&07000 != 0
+    }
+    for _, clause := range strings.Split(spec, ",") {
+        // Within a clause, each operator applies to the letters after it.
+        op := byte(0)
+        for i := 0; i < len(clause); i++ {
+            switch c := clause[i]; c {
+            case '+', '-', '=':
+                op = c
+            case 's', 't':
+                if op != '-' {
+                    return true
+                }
+            }
+        }
+    }
+    return false
+}
+
+// fileModeFromUnix converts classic octal permission bits into a Go file mode.
+func fileModeFromUnix(bits uint32) os.FileMode {
+    mode := os.FileMode(bits & 0777)
+    if bits&04000 != 0 {
+        mode |= os.ModeSetuid
+    }
+    if bits&02000 != 0 {
+        mode |= os.ModeSetgid
+    }
+    if bits&01000 != 0 {
+        mode |= os.ModeSticky
+    }
+    return mode
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestParseModeSpec(t *testing.T) {
+    tests := []struct {
+        spec  string
+        bits  uint32
+        isDir bool
+        want  uint32
+        err   error
+    }{
+        {"0755", 0644, false, 0755, nil},
+        {"4711", 0644, false, 04711, nil},
+        {"u+x,go-w", 0666, false, 0744, nil},
+        {"a=rX", 0644, false, 0444, nil},
+        {"a=rX", 0744, false, 0555, nil},
+        {"a=rX", 0700, true, 0555, nil},
+        {"o=", 0777, false, 0770, nil},
+        {"g+s", 0755, true, 02755, nil},
+        {"+t", 0777, true, 01777, nil},
+        {"u-x+w", 0544, false, 0644, nil},
+        {"", 0, false, 0, ErrInvalidMode},
+        {"0788", 0, false, 0, ErrInvalidMode},
+        {"17777", 0, false, 0, ErrInvalidMode},
+        {"u", 0, false, 0, ErrInvalidMode},
+        {"u*x", 0, false, 0, ErrInvalidMode},
+        {"z+x", 0, false, 0, ErrInvalidMode},
+    }
+    for _, tt := range tests {
+        t.Run(tt.spec, func(t *testing.T) {
+            change, err := parseModeSpec(tt.spec)
+            if !errors.Is(err, tt.err) {
+                t.Fatalf("parseModeSpec(%q) error = %v, want %v", tt.spec, err, tt.err)
+            }
+            if err != nil {
+                return
+            }
+            if got := change(tt.bits, tt.isDir); got != tt.want {
+                t.Errorf("%q applied to %04o = %04o, want %04o", tt.spec, tt.bits, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestSetsSpecialBits(t *testing.T) {
+    tests := []struct {
+        spec string
+        want bool
+    }{
+        {"0755", false},
+        {"4755", true},
+        {"1777", true},
+        {"u+s", true},
+        {"o+t", true},
+        {"g=rxs", true},
+        {"g-s", false},
+        {"a-st", false},
+        {"u-s+t", true},
+        {"u+x,g-s", false},
+        {"a=rwx", false},
+    }
+    for _, tt := range tests {
+        if got := setsSpecialBits(tt.spec); got != tt.want {
+            t.Errorf("setsSpecialBits(%q) = %v, want %v", tt.spec, got, tt.want)
+        }
+    }
+}
+
+// modeOf returns the permission bits of a path inside the "data" share.
+func modeOf(t *testing.T, fs *FileService, rel string) os.FileMode {
+    info, err := os.Stat(diskPath(fs, rel))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return info.Mode().Perm()
+}
+
+func TestChangeModeRecursive(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "tree/top.txt", "x")
+    writeTestFile(t, fs, "tree/a/b/deep.txt", "x")
+    entries := []string{"tree", "tree/top.txt", "tree/a", "tree/a/b", "tree/a/b/deep.txt"}
+
+    // Closing the folders to their owner must not keep the walk from the
+    // entries beneath them.
+    result, err := fs.ChangeMode("/data/tree", "u=rw,go=", AttributeOptions{Recursive: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.Changed != len(entries) || result.Failed != 0 {
+        t.Errorf("closing: %d changed, %d failed, want %d changed: %+v", result.Changed, result.Failed, len(entries), result.Changes)
+    }
+    // Opening them again must first let the walk in.
+    result, err = fs.ChangeMode("/data/tree", "u=rwX,go=rX", AttributeOptions{Recursive: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.Changed != len(entries) || result.Failed != 0 {
+        t.Errorf("opening: %d changed, %d failed, want %d changed: %+v", result.Changed, result.Failed, len(entries), result.Changes)
+    }
+    for _, rel := range entries {
+        want := os.FileMode(0644)
+        if !strings.HasSuffix(rel, ".txt") {
+            want = 0755
+        }
+        if got := modeOf(t, fs, rel); got != want {
+            t.Errorf("mode of %s = %v, want %v", rel, got, want)
+        }
+    }
+
+    // Without Recursive only the folder itself changes.
+    result, err = fs.ChangeMode("/data/tree", "0700", AttributeOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if result.Changed != 1 || modeOf(t, fs, "tree") != 0700 || modeOf(t, fs, "tree/a") != 0755 {
+        t.Errorf("non-recursive change: %+v", result)
+    }
+}
+
+func TestChangeModeDryRun(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "tree/a.txt", "x")
+    writeTestFile(t, fs, "tree/b.txt", "x")
+    if err := os.Chmod(diskPath(fs, "tree/b.txt"), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    result, err := fs.ChangeMode("/data/tree", "go-rwx", AttributeOptions{Recursive: true, DryRun: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !result.DryRun || result.Changed != 2 || result.Unchanged != 1 || result.Failed != 0 {
+        t.Errorf("dry run result = %+v, want 2 changed and 1 unchanged", result)
+    }
+    want := map[string]string{"/data/tree": "0755 0700", "/data/tree/a.txt": "0644 0600"}
+    for _, change := range result.Changes {
+        if got := change.Before + " " + change.After; got != want[change.Path] {
+            t.Errorf("planned change of %s = %q, want %q", change.Path, got, want[change.Path])
+        }
+    }
+    if modeOf(t, fs, "tree") != 0755 || modeOf(t, fs, "tree/a.txt") != 0644 {
+        t.Error("a dry run changed modes")
+    }
+}
+
+func TestChangeAttributesCollectsErrors(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "tree/good.txt", "x")
+    writeTestFile(t, fs, "tree/bad.txt", "x")
+    if err := os.Symlink("good.txt", diskPath(fs, "tree/link")); err != nil {
+        t.Fatal(err)
+    }
+    item, err := fs.resolve("/data/tree")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var applied []string
+    result, err := fs.changeAttributes(item, AttributeOptions{Recursive: true}, func(abs string, info os.FileInfo) attributePlan {
+        name := filepath.Base(abs)
+        return attributePlan{before: "old", after: "new", apply: func() error {
+            if name == "bad.txt" {
+                return errors.New("refused")
+            }
+            applied = append(applied, name)
+            return nil
+        }}
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    // One failure does not stop the others, and symlinks are left alone.
+    if result.Changed != 2 || result.Failed != 1 {
+        t.Errorf("result = %+v, want 2 changed and 1 failed", result)
+    }
+    if got := strings.Join(applied, " "); got != "good.txt tree" {
+        t.Errorf("applied to %q, want the file before its folder", got)
+    }
+    for _, change := range result.Changes {
+        if (change.Error != "") != (change.Path == "/data/tree/bad.txt") {
+            t.Errorf("change of %s has error %q", change.Path, change.Error)
+        }
+    }
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+    "container/list"
+    "context"
+    "crypto/md5"
+    "crypto/sha1"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "os"
+    "strings"
+    "sync"
+    "nfs-dashboard-backend/models"
+
+    "golang.org/x/crypto/blake2b"
+)
+
+var (
+    // ErrUnsupportedAlgorithm is returned for unknown checksum algorithms.
+    ErrUnsupportedAlgorithm = errors.New("unsupported checksum algorithm, use md5, sha1, sha256 or blake2b")
+    // ErrInvalidChecksum is returned for expected checksums that are malformed.
+    ErrInvalidChecksum = errors.New("expected checksum must be <algorithm>:<hex digest>")
+    // ErrChecksumMismatch is returned when an upload does not match its
+    // expected checksum.
+    ErrChecksumMismatch = errors.New("uploaded content does not match the expected checksum")
+    // ErrChecksumOfFolder is returned when a checksum of a folder is requested.
+    ErrChecksumOfFolder = errors.New("checksums are only available for files")
+)
+
+// DefaultChecksumAlgorithm is used when no algorithm is requested.
+const DefaultChecksumAlgorithm = "sha256"
+
+// maxCachedChecksums bounds the checksum cache; entries are small.
+const maxCachedChecksums = 10000
+
+// checksumAlgorithms creates the hash of each supported algorithm; blake2b
+// is BLAKE2b-512.
+var checksumAlgorithms = map[string]func() hash.Hash{
+    "md5":    md5.New,
+    "sha1":   sha1.New,
+    "sha256": sha256.New,
+    "blake2b": func() hash.Hash {
+        h, _ := blake2b.New512(nil)
+        return h
+    },
+}
+
+// checksumAlgorithm normalises an algorithm name such as "SHA-256".
+func checksumAlgorithm(name string) (string, error) {
+    name = strings.ToLower(strings.Replace(name, "-", "", -1))
+    if name == "" {
+        return DefaultChecksumAlgorithm, nil
+    }
+    if _, ok := checksumAlgorithms[name]; !ok {
+        return "", ErrUnsupportedAlgorithm
+    }
+    return name, nil
+}
+
+// ExpectedChecksum is a checksum an upload must match.
+type ExpectedChecksum struct {
+    Algorithm string
+    Value     string // lower-case hex
+}
+
+// ParseExpectedChecksum parses "<algorithm>:<hex digest>", e.g.
+// "sha256:9f86d0…". An empty string yields nil.
+func ParseExpectedChecksum(s string) (*ExpectedChecksum, error) {
+    if s == "" {
+        return nil, nil
+    }
+    i := strings.Index(s, ":")
+    if i < 0 {
+        return nil, ErrInvalidChecksum
+    }
+    algo, err := checksumAlgorithm(strings.TrimSpace(s[:i]))
+    if err != nil {
+        return nil, err
+    }
+    value := strings.ToLower(strings.TrimSpace(s[i+1:]))
+    digest, err := hex.DecodeString(value)
+    if err != nil || len(digest) != checksumAlgorithms[algo]().Size() {
+        return nil, ErrInvalidChecksum
+    }
+    return &ExpectedChecksum{Algorithm: algo, Value: value}, nil
+}
+
+// Checksum computes the checksum of p with algo in a single streaming pass.
+// Results are cached until the file's inode, size or mtime changes, so
+// verifying large datasets twice does not read them twice.
+func (fs *FileService) Checksum(ctx context.Context, p, algo string) (*models.Checksum, error) {
+    algo, err := checksumAlgorithm(algo)
+    if err != nil {
+        return nil, err
+    }
+    file, err := fs.StatFile(p)
+    if err != nil {
+        return nil, err
+    }
+    if file.IsDir {
+        return nil, ErrChecksumOfFolder
+    }
+    result := &models.Checksum{Path: file.Path, Algorithm: algo, Size: file.Size}
+
+    // Archive members cannot be cached; they have no inode of their own.
+    var item *resolvedPath
+    key := ""
+    if item, err = fs.resolve(p); err == nil {
+        if info, err := os.Stat(item.abs); err == nil {
+            key = checksumKey(item.abs, info, algo)
+        }
+    }
+    if value, ok := fs.checksums.get(key); ok {
+        result.Value, result.Cached = value, true
+        return result, nil
+    }
+
+    content, _, err := fs.OpenFile(p)
+    if err != nil {
+        return nil, err
+    }
+    defer content.Close()
+    h := checksumAlgorithms[algo]()
+    if _, err := io.Copy(h, &contextReader{ctx: ctx, r: content}); err != nil {
+        return nil, err
+    }
+    result.Value = hex.EncodeToString(h.Sum(nil))
+
+    // Only cache if the file did not change while it was read.
+    if key != "" {
+        if info, err := os.Stat(item.abs); err == nil && checksumKey(item.abs, info, algo) == key {
+            fs.checksums.put(key, result.Value)
+        }
+    }
+    return result, nil
+}
+
+// checksumKey identifies a file's content by device, inode, size and mtime,
+// or by path where inodes are unavailable.
+func checksumKey(abs string, info os.FileInfo, algo string) string {
+    id := abs
+    if details, ok := statDetailsOf(info); ok {
+        id = fmt.Sprintf("%d:%d", details.device, details.inode)
+    }
+    return fmt.Sprintf("%s:%d:%d:%s", id, info.ModTime().UnixNano(), info.Size(), algo)
+}
+
+// checksumCache is a least-recently-used map of checksum keys to values.
+type checksumCache struct {
+    mu      sync.Mutex
+    order   *list.List // of *checksumEntry, most recent first
+    entries map[string]*list.Element
+}
+
+type checksumEntry struct {
+    key, value string
+}
+
+func newChecksumCache() *checksumCache {
+    return &checksumCache{order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *checksumCache) get(key string) (string, bool) {
+    if key == "" {
+        return "", false
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    elem, ok := c.entries[key]
+    if !ok {
+        return "", false
+    }
+    c.order.MoveToFront(elem)
+    return elem.Value.(*checksumEntry).value, true
+}
+
+func (c *checksumCache) put(key, value string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if elem, ok := c.entries[key]; ok {
+        elem.Value.(*checksumEntry).value = value
+        c.order.MoveToFront(elem)
+        return
+    }
+    c.entries[key] = c.order.PushFront(&checksumEntry{key: key, value: value})
+    for c.order.Len() > maxCachedChecksums {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.entries, oldest.Value.(*checksumEntry).key)
+    }
+}
+
+// contextReader stops reading once ctx is done, so hashing a huge file ends
+// soon after the client goes away.
+type contextReader struct {
+    ctx context.Context
+    r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+    if err := cr.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return cr.r.Read(p)
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "os"
+    "strings"
+    "testing"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+func sha256Of(s string) string {
+    sum := sha256.Sum256([]byte(s))
+    return hex.EncodeToString(sum[:])
+}
+
+// folderNames returns the names in a folder of the "data" share.
+func folderNames(t *testing.T, fs *FileService, rel string) string {
+    entries, err := os.ReadDir(diskPath(fs, rel))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, entry := range entries {
+        names = append(names, entry.Name())
+    }
+    return strings.Join(names, " ")
+}
+
+func TestParseExpectedChecksum(t *testing.T) {
+    digest := sha256Of("x")
+    tests := []struct {
+        in   string
+        want *ExpectedChecksum
+        err  error
+    }{
+        {"", nil, nil},
+        {"sha256:" + digest, &ExpectedChecksum{"sha256", digest}, nil},
+        {"SHA-256:" + strings.ToUpper(digest), &ExpectedChecksum{"sha256", digest}, nil},
+        {digest, nil, ErrInvalidChecksum},
+        {"sha256:abc", nil, ErrInvalidChecksum},
+        {"md5:" + digest, nil, ErrInvalidChecksum},
+        {"crc32:00000000", nil, ErrUnsupportedAlgorithm},
+    }
+    for _, tt := range tests {
+        t.Run(tt.in, func(t *testing.T) {
+            got, err := ParseExpectedChecksum(tt.in)
+            if err != tt.err || (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+                t.Errorf("ParseExpectedChecksum(%q) = %v, %v, want %v, %v", tt.in, got, err, tt.want, tt.err)
+            }
+        })
+    }
+}
+
+func TestUploadVerifiesChecksum(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{MaxVersions: 5})
+    writeTestFile(t, fs, "docs/plan.txt", "old\n")
+    wrong := &ExpectedChecksum{Algorithm: "sha256", Value: sha256Of("something else")}
+
+    if _, err := fs.UploadFile("/data/docs", "new.txt", "2", strings.NewReader("new\n"), wrong); err != ErrChecksumMismatch {
+        t.Errorf("mismatching new file: error = %v, want %v", err, ErrChecksumMismatch)
+    }
+    if _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader("new\n"), wrong); err != ErrChecksumMismatch {
+        t.Errorf("mismatching overwrite: error = %v, want %v", err, ErrChecksumMismatch)
+    }
+    // Neither the new file nor a temporary file is left behind, and the
+    // existing file is untouched.
+    if got := folderNames(t, fs, "docs"); got != "internal out plan.txt readme.txt" {
+        t.Errorf("docs holds %q after rejected uploads", got)
+    }
+    if got := readTestFile(t, fs, "docs/plan.txt"); got != "old\n" {
+        t.Errorf("content after a rejected overwrite = %q, want it unchanged", got)
+    }
+    if got := versionContents(t, fs, "/data/docs/plan.txt"); len(got) != 0 {
+        t.Errorf("rejected overwrite kept versions %q", got)
+    }
+
+    right := &ExpectedChecksum{Algorithm: "sha256", Value: sha256Of("new\n")}
+    if _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader("new\n"), right); err != nil {
+        t.Fatalf("matching upload: %v", err)
+    }
+    // The verified checksum is cached without reading the file again.
+    checksum, err := fs.Checksum(context.Background(), "/data/docs/plan.txt", "sha256")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !checksum.Cached || checksum.Value != right.Value {
+        t.Errorf("checksum after a verified upload = %+v, want the cached expected value", checksum)
+    }
+}
+
+func TestChecksumCache(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "docs/data.bin", "first")
+    ctx := context.Background()
+
+    first, err := fs.Checksum(ctx, "/data/docs/data.bin", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first.Cached || first.Algorithm != "sha256" || first.Value != sha256Of("first") {
+        t.Errorf("first checksum = %+v, want an uncached sha256 of the content", first)
+    }
+    again, err := fs.Checksum(ctx, "/data/docs/data.bin", "sha256")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !again.Cached || again.Value != first.Value {
+        t.Errorf("second checksum = %+v, want the cached value", again)
+    }
+
+    // Content of the same size with a new mtime is read again.
+    writeTestFile(t, fs, "docs/data.bin", "other")
+    later := time.Now().Add(time.Minute)
+    if err := os.Chtimes(diskPath(fs, "docs/data.bin"), later, later); err != nil {
+        t.Fatal(err)
+    }
+    changed, err := fs.Checksum(ctx, "/data/docs/data.bin", "sha256")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if changed.Cached || changed.Value != sha256Of("other") {
+        t.Errorf("checksum after a change = %+v, want a fresh sha256 of the new content", changed)
+    }
+
+    // Another inode with the same size and mtime is not mistaken for it.
+    writeTestFile(t, fs, "docs/copy.bin", "third")
+    if err := os.Chtimes(diskPath(fs, "docs/copy.bin"), later, later); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Rename(diskPath(fs, "docs/copy.bin"), diskPath(fs, "docs/data.bin")); err != nil {
+        t.Fatal(err)
+    }
+    replaced, err := fs.Checksum(ctx, "/data/docs/data.bin", "sha256")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if replaced.Cached || replaced.Value != sha256Of("third") {
+        t.Errorf("checksum of a replaced file = %+v, want a fresh sha256 of the new content", replaced)
+    }
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strconv"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+// MaxTextSize is the most text previewed, and so the largest file that can
+// be edited: saving a truncated preview would cut the file short.
+const MaxTextSize = 2 << 20 // 2 MB
+
+var (
+    // ErrPreconditionFailed is returned when a file changed since the
+    // version an edit was based on.
+    ErrPreconditionFailed = errors.New("file was changed since it was loaded")
+    // ErrNotText is returned for edits of files that are not text.
+    ErrNotText = errors.New("only text files can be edited")
+    // ErrTextTooLarge is returned for edits of or to more than MaxTextSize.
+    ErrTextTooLarge = errors.New("text files over 2 MB cannot be edited")
+)
+
+// FileETag identifies the current content of a file by its modification
+// time and size.
+func FileETag(modTime time.Time, size int64) string {
+    return `"` + strconv.FormatInt(modTime.UnixNano(), 36) + "-" + strconv.FormatInt(size, 36) + `"`
+}
+
+// SaveContent replaces the text file at p with content, provided it still
+// has the given ETag ("*" accepts any). The previous content is kept as a
+// version even with versioning off, the file keeps its mode and ownership,
+// and the new content is attributed to user. A symlink is followed, so the
+// file it leads to is edited rather than the link replaced.
+func (fs *FileService) SaveContent(p, etag, user string, content io.Reader) (*models.File, error) {
+    if clean, err := cleanVirtualPath(p); err == nil {
+        if _, _, ok := fs.archivePath(clean); ok {
+            return nil, ErrArchiveReadOnly
+        }
+    }
+    dest, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    if info, err := os.Lstat(dest.abs); err == nil && info.Mode()&os.ModeSymlink != 0 {
+        if dest, err = fs.resolve(dest.realVirtual()); err != nil {
+            return nil, err
+        }
+    }
+
+    // Saves are serialized so two edits of the same version cannot both pass
+    // the ETag check.
+    fs.contentMu.Lock()
+    defer fs.contentMu.Unlock()
+
+    current, err := os.Open(dest.abs)
+    if err != nil {
+        return nil, err
+    }
+    info, err := current.Stat()
+    if err != nil {
+        current.Close()
+        return nil, err
+    }
+    if !info.Mode().IsRegular() {
+        current.Close()
+        return nil, ErrNotText
+    }
+    mimeType, _, err := fs.ContentTypes().Sniff(info.Name(), current)
+    current.Close()
+    if err != nil {
+        return nil, err
+    }
+    if !IsTextType(mimeType) {
+        return nil, ErrNotText
+    }
+    if etag != "*" && etag != FileETag(info.ModTime(), info.Size()) {
+        return nil, ErrPreconditionFailed
+    }
+    if info.Size() > MaxTextSize {
+        return nil, ErrTextTooLarge
+    }
+
+    saved, err := fs.storeFile(dest, user, true, func(tmp string, quotaLeft int64) error {
+        return writeContent(tmp, content, info, quotaLeft)
+    })
+    if err != nil {
+        return nil, err
+    }
+    if fs.audit != nil {
+        fs.audit.RecordAuditLog("edit", user, fmt.Sprintf("Edited %s (%d bytes)", saved.Path, saved.Size))
+    }
+    return saved, nil
+}
+
+// writeContent writes at most MaxTextSize bytes of r to a new file at dst
+// with the mode and, where permitted, the owner of the file it replaces.
+func writeContent(dst string, r io.Reader, replaced os.FileInfo, quotaLeft int64) error {
+    out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return err
+    }
+    written, err := io.Copy(out, io.LimitReader(r, MaxTextSize+1))
+    if err != nil {
+        out.Close()
+        return err
+    }
+    if written > MaxTextSize {
+        out.Close()
+        return ErrTextTooLarge
+    }
+    if quotaLeft != unlimitedQuota && written > quotaLeft {
+        out.Close()
+        return ErrQuotaExceeded
+    }
+    // The mode is set explicitly since the umask applies on creation.
+    if err := out.Chmod(replaced.Mode().Perm()); err != nil {
+        out.Close()
+        return err
+    }
+    if details, ok := statDetailsOf(replaced); ok {
+        if err := out.Chown(int(details.uid), int(details.gid)); err != nil && !sameOwner(out, details) {
+            out.Close()
+            return fmt.Errorf("cannot keep the owner of the file: %w", err)
+        }
+    }
+    if err := out.Sync(); err != nil {
+        out.Close()
+        return err
+    }
+    return out.Close()
+}
+
+// sameOwner reports whether f already has the owner and group in details,
+// in which case a failed chown does not matter.
+func sameOwner(f *os.File, details statDetails) bool {
+    info, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    own, ok := statDetailsOf(info)
+    return ok && own.uid == details.uid && own.gid == details.gid
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+    "os"
+    "strings"
+    "syscall"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+// currentETag returns the ETag a preview of rel would carry.
+func currentETag(t *testing.T, fs *FileService, rel string) string {
+    info, err := os.Stat(diskPath(fs, rel))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return FileETag(info.ModTime(), info.Size())
+}
+
+func TestSaveContentChecksETag(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "docs/notes.txt", "first\n")
+    etag := currentETag(t, fs, "docs/notes.txt")
+
+    if _, err := fs.SaveContent("/data/docs/notes.txt", etag, "2", strings.NewReader("second\n")); err != nil {
+        t.Fatal(err)
+    }
+    // The ETag the first edit was based on is outdated now.
+    if _, err := fs.SaveContent("/data/docs/notes.txt", etag, "3", strings.NewReader("third\n")); err != ErrPreconditionFailed {
+        t.Errorf("save of an outdated version: error = %v, want %v", err, ErrPreconditionFailed)
+    }
+    if got := readTestFile(t, fs, "docs/notes.txt"); got != "second\n" {
+        t.Errorf("content = %q, want the first save", got)
+    }
+    if _, err := fs.SaveContent("/data/docs/notes.txt", "*", "3", strings.NewReader("third\n")); err != nil {
+        t.Errorf("save with If-Match *: %v", err)
+    }
+}
+
+func TestSaveContentKeepsModeAndOwner(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "docs/run.txt", "old\n")
+    file := diskPath(fs, "docs/run.txt")
+    if err := os.Chmod(file, 0751); err != nil {
+        t.Fatal(err)
+    }
+    // Only root can hand the file to someone else; otherwise the file
+    // keeps the test's own owner.
+    if os.Geteuid() == 0 {
+        if err := os.Chown(file, 1234, 1234); err != nil {
+            t.Fatal(err)
+        }
+    }
+    before, err := os.Stat(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := fs.SaveContent("/data/docs/run.txt", "*", "2", strings.NewReader("new\n")); err != nil {
+        t.Fatal(err)
+    }
+    after, err := os.Stat(file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if after.Mode().Perm() != 0751 {
+        t.Errorf("mode = %v, want %v", after.Mode().Perm(), os.FileMode(0751))
+    }
+    was, is := before.Sys().(*syscall.Stat_t), after.Sys().(*syscall.Stat_t)
+    if was.Uid != is.Uid || was.Gid != is.Gid {
+        t.Errorf("owner = %d:%d, want %d:%d", is.Uid, is.Gid, was.Uid, was.Gid)
+    }
+}
+
+func TestSaveContentKeepsBackup(t *testing.T) {
+    for _, versions := range []int{0, 3} {
+        fs := newTestFiles(t, models.SystemSettings{MaxVersions: versions})
+        writeTestFile(t, fs, "docs/notes.txt", "v1\n")
+        for _, content := range []string{"v2\n", "v3\n"} {
+            if _, err := fs.SaveContent("/data/docs/notes.txt", "*", "2", strings.NewReader(content)); err != nil {
+                t.Fatal(err)
+            }
+        }
+        want := "v2\n|v1\n"
+        if versions == 0 {
+            // Without versioning only the content before the last edit is kept.
+            want = "v2\n"
+        }
+        if got := strings.Join(versionContents(t, fs, "/data/docs/notes.txt"), "|"); got != want {
+            t.Errorf("max_versions %d: backups = %q, want %q", versions, got, want)
+        }
+    }
+}
+
+func TestSaveContentFollowsSymlinks(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "docs/notes.txt", "old\n")
+    if err := os.Symlink("notes.txt", diskPath(fs, "docs/current.txt")); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := fs.SaveContent("/data/docs/current.txt", "*", "2", strings.NewReader("new\n")); err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Lstat(diskPath(fs, "docs/current.txt")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+        t.Error("the symlink was replaced")
+    }
+    if got := readTestFile(t, fs, "docs/notes.txt"); got != "new\n" {
+        t.Errorf("target content = %q, want the edit", got)
+    }
+    if got := versionContents(t, fs, "/data/docs/notes.txt"); len(got) != 1 || got[0] != "old\n" {
+        t.Errorf("backups of the target = %q, want the old content", got)
+    }
+}
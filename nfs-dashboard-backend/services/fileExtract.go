@@ -0,0 +1,411 @@
+package services
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "bufio"
+    "compress/bzip2"
+    "compress/gzip"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+var (
+    // ErrUnsupportedArchive is returned for files that are not zip or tar archives.
+    ErrUnsupportedArchive = errors.New("unsupported archive format")
+    // ErrUnsafeArchivePath is returned for entries that would land outside
+    // the target folder ("zip slip").
+    ErrUnsafeArchivePath = errors.New("archive contains an unsafe path")
+    // ErrExtractLimit is returned when an archive expands beyond the ExtractLimits.
+    ErrExtractLimit = errors.New("archive exceeds the extraction limits")
+)
+
+// ExtractLimits guards against decompression bombs.
+type ExtractLimits struct {
+    MaxBytes   int64   // total extracted size
+    MaxEntries int     // files and folders
+    MaxRatio   float64 // extracted size divided by archive size
+}
+
+// DefaultExtractLimits allows ordinary bundles while stopping bombs early.
+var DefaultExtractLimits = ExtractLimits{MaxBytes: 20 << 30, MaxEntries: 100000, MaxRatio: 200}
+
+// extractRatioGrace is how much may be extracted before MaxRatio applies,
+// since small archives of text legitimately compress very well.
+const extractRatioGrace = 16 << 20
+
+// ExtractResult describes a finished extraction.
+type ExtractResult struct {
+    Files   []models.File `json:"files"`             // top-level items created
+    Entries int           `json:"entries"`           // files and folders extracted
+    Bytes   int64         `json:"bytes"`             // total size extracted
+    Skipped []string      `json:"skipped,omitempty"` // links, devices and disallowed types
+}
+
+// archiveKind returns the archive type implied by a file name, or "".
+func archiveKind(name string) string {
+    name = strings.ToLower(name)
+    for _, kind := range []string{".zip", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar"} {
+        if strings.HasSuffix(name, kind) {
+            return kind
+        }
+    }
+    return ""
+}
+
+// ExtractArchive unpacks a zip, tar, tar.gz or tar.bz2 file into destDir.
+// Entries are first extracted into a hidden staging folder inside destDir,
+// so a rejected archive leaves nothing behind, and the top-level items are
+// then moved into place according to policy. Extracted files count against
+// owner's quota, and types not allowed for uploads are skipped.
+func (fs *FileService) ExtractArchive(ctx context.Context, src, destDir string, policy ConflictPolicy, owner string, limits ExtractLimits, progress ProgressReporter) (*ExtractResult, error) {
+    archive, err := fs.resolve(src)
+    if err != nil {
+        return nil, err
+    }
+    info, err := os.Stat(archive.abs)
+    if err != nil {
+        return nil, err
+    }
+    kind := archiveKind(info.Name())
+    if info.IsDir() || kind == "" {
+        return nil, ErrUnsupportedArchive
+    }
+    dir, err := fs.resolve(destDir)
+    if err != nil {
+        return nil, err
+    }
+    if dirInfo, err := os.Stat(dir.abs); err != nil {
+        return nil, err
+    } else if !dirInfo.IsDir() {
+        return nil, errors.New("destination is not a directory")
+    }
+
+    upload, err := fs.UploadPolicy()
+    if err != nil {
+        return nil, err
+    }
+    quotaLeft, err := fs.quotaLeft(owner)
+    if err != nil {
+        return nil, err
+    }
+
+    staging := tempSibling(filepath.Join(dir.abs, info.Name()), "extracting")
+    if err := os.Mkdir(staging, 0700); err != nil {
+        return nil, err
+    }
+    defer os.RemoveAll(staging)
+
+    ex := &extractor{
+        ctx:         ctx,
+        root:        staging,
+        limits:      limits,
+        quotaLeft:   quotaLeft,
+        archiveSize: info.Size(),
+        upload:      upload,
+        progress:    progress,
+        result:      &ExtractResult{Files: []models.File{}},
+    }
+    if kind == ".zip" {
+        err = ex.extractZip(archive.abs)
+    } else {
+        err = ex.extractTar(archive.abs, kind)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    files, err := fs.placeExtracted(staging, dir, policy, owner)
+    if err != nil {
+        return nil, err
+    }
+    ex.result.Files = files
+    return ex.result, nil
+}
+
+// placeExtracted moves the top-level items of staging into dir. Nothing is
+// moved if any of them is locked or, under ConflictFail, already exists.
+func (fs *FileService) placeExtracted(staging string, dir *resolvedPath, policy ConflictPolicy, owner string) ([]models.File, error) {
+    entries, err := os.ReadDir(staging)
+    if err != nil {
+        return nil, err
+    }
+    targets := make([]*resolvedPath, len(entries))
+    for i, entry := range entries {
+        if targets[i], err = dir.child(entry.Name()); err != nil {
+            return nil, err
+        }
+        if _, err := os.Lstat(targets[i].abs); err == nil && policy == ConflictFail {
+            return nil, fmt.Errorf("%w: %s", ErrDestinationExists, entry.Name())
+        }
+        if err := fs.checkUnlocked(targets[i], owner); err != nil {
+            return nil, err
+        }
+    }
+
+    files := []models.File{}
+    for i, entry := range entries {
+        target := targets[i]
+        if _, err := os.Lstat(target.abs); err == nil && policy == ConflictRename {
+            if target, err = freeName(dir, entry.Name()); err != nil {
+                return files, err
+            }
+        }
+        err := fs.placeAt(target, policy == ConflictOverwrite, owner, func(dst string) error {
+            return os.Rename(filepath.Join(staging, entry.Name()), dst)
+        })
+        if err != nil {
+            return files, err
+        }
+        fs.usage.restored(target, map[string]string{"": owner})
+        if info, err := os.Stat(target.abs); err == nil {
+            files = append(files, fileFromInfo(target, info))
+        }
+    }
+    return files, nil
+}
+
+// extractor writes archive entries below root while enforcing the limits.
+type extractor struct {
+    ctx         context.Context
+    root        string
+    limits      ExtractLimits
+    quotaLeft   int64
+    archiveSize int64
+    upload      UploadPolicy
+    progress    ProgressReporter
+    result      *ExtractResult
+
+    done  func() int64 // progress so far, in the unit of total
+    total int64
+}
+
+func (ex *extractor) extractZip(archivePath string) error {
+    r, err := zip.OpenReader(archivePath)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+    }
+    defer r.Close()
+    if ex.limits.MaxEntries > 0 && len(r.File) > ex.limits.MaxEntries {
+        return ErrExtractLimit
+    }
+    // Declared sizes may lie, so they only drive progress; limits are
+    // enforced on the bytes actually written.
+    for _, f := range r.File {
+        ex.total += int64(f.UncompressedSize64)
+    }
+    ex.done = func() int64 { return ex.result.Bytes }
+
+    for _, f := range r.File {
+        if err := ex.ctx.Err(); err != nil {
+            return err
+        }
+        mode := f.Mode()
+        switch {
+        case mode.IsDir():
+            err = ex.dir(f.Name)
+        case mode.IsRegular():
+            err = ex.zipFile(f)
+        default:
+            ex.result.Skipped = append(ex.result.Skipped, f.Name)
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (ex *extractor) zipFile(f *zip.File) error {
+    content, err := f.Open()
+    if err != nil {
+        return err
+    }
+    defer content.Close()
+    return ex.file(f.Name, content, f.Mode(), f.Modified)
+}
+
+func (ex *extractor) extractTar(archivePath, kind string) error {
+    file, err := os.Open(archivePath)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    counted := &countingReader{r: file}
+    ex.total = ex.archiveSize
+    ex.done = func() int64 { return counted.n }
+
+    var stream io.Reader = counted
+    switch kind {
+    case ".tar.gz", ".tgz":
+        gz, err := gzip.NewReader(counted)
+        if err != nil {
+            return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+        }
+        defer gz.Close()
+        stream = gz
+    case ".tar.bz2", ".tbz2":
+        stream = bzip2.NewReader(counted)
+    }
+
+    tr := tar.NewReader(stream)
+    for {
+        if err := ex.ctx.Err(); err != nil {
+            return err
+        }
+        header, err := tr.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return fmt.Errorf("%w: %v", ErrUnsupportedArchive, err)
+        }
+        switch header.Typeflag {
+        case tar.TypeDir:
+            err = ex.dir(header.Name)
+        case tar.TypeReg, tar.TypeRegA:
+            err = ex.file(header.Name, tr, header.FileInfo().Mode(), header.ModTime)
+        case tar.TypeXGlobalHeader:
+        default:
+            ex.result.Skipped = append(ex.result.Skipped, header.Name)
+        }
+        if err != nil {
+            return err
+        }
+    }
+}
+
+// target validates an entry name and returns where it is extracted.
+// Absolute names and ".." segments are rejected outright.
+func (ex *extractor) target(name string) (string, bool, error) {
+    name = strings.ReplaceAll(name, "\\", "/")
+    if path.IsAbs(name) {
+        return "", false, fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+    }
+    for _, seg := range strings.Split(name, "/") {
+        if seg == ".." {
+            return "", false, fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+        }
+    }
+    clean := path.Clean(name)
+    if clean == "." {
+        return "", false, nil
+    }
+    abs := filepath.Join(ex.root, filepath.FromSlash(clean))
+    if !isWithin(ex.root, abs) {
+        return "", false, fmt.Errorf("%w: %s", ErrUnsafeArchivePath, name)
+    }
+    return abs, true, nil
+}
+
+// count registers one more entry against MaxEntries.
+func (ex *extractor) count() error {
+    ex.result.Entries++
+    if ex.limits.MaxEntries > 0 && ex.result.Entries > ex.limits.MaxEntries {
+        return ErrExtractLimit
+    }
+    return nil
+}
+
+func (ex *extractor) dir(name string) error {
+    abs, ok, err := ex.target(name)
+    if err != nil || !ok {
+        return err
+    }
+    if err := ex.count(); err != nil {
+        return err
+    }
+    return os.MkdirAll(abs, 0755)
+}
+
+func (ex *extractor) file(name string, content io.Reader, mode os.FileMode, modified time.Time) error {
+    abs, ok, err := ex.target(name)
+    if err != nil || !ok {
+        return err
+    }
+    if ex.upload.CheckName(abs) != nil {
+        ex.result.Skipped = append(ex.result.Skipped, name)
+        return nil
+    }
+    // The content is sniffed as for uploads, so a renamed executable does
+    // not get through as an allowed type.
+    in := bufio.NewReaderSize(&limitedExtraction{r: content, ex: ex}, sniffLen)
+    head, err := in.Peek(sniffLen)
+    if err != nil && err != io.EOF {
+        return err
+    }
+    if ex.upload.CheckContent(abs, head) != nil {
+        ex.result.Skipped = append(ex.result.Skipped, name)
+        return nil
+    }
+    if err := ex.count(); err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+        return err
+    }
+    out, err := os.OpenFile(abs, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm()|0600)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(out, in); err != nil {
+        out.Close()
+        return err
+    }
+    if err := out.Close(); err != nil {
+        return err
+    }
+    if !modified.IsZero() {
+        os.Chtimes(abs, time.Now(), modified)
+    }
+    return nil
+}
+
+// limitedExtraction counts extracted bytes and stops once a limit is hit.
+type limitedExtraction struct {
+    r  io.Reader
+    ex *extractor
+}
+
+func (l *limitedExtraction) Read(p []byte) (int, error) {
+    if err := l.ex.ctx.Err(); err != nil {
+        return 0, err
+    }
+    n, err := l.r.Read(p)
+    ex := l.ex
+    ex.result.Bytes += int64(n)
+    if ex.quotaLeft != unlimitedQuota && ex.result.Bytes > ex.quotaLeft {
+        return n, ErrQuotaExceeded
+    }
+    if ex.limits.MaxBytes > 0 && ex.result.Bytes > ex.limits.MaxBytes {
+        return n, ErrExtractLimit
+    }
+    if ex.limits.MaxRatio > 0 && ex.result.Bytes > extractRatioGrace &&
+        float64(ex.result.Bytes) > ex.limits.MaxRatio*float64(ex.archiveSize) {
+        return n, ErrExtractLimit
+    }
+    if ex.progress != nil {
+        ex.progress.Progress(ex.done(), ex.total)
+    }
+    return n, err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+    r io.Reader
+    n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+    n, err := c.r.Read(p)
+    c.n += int64(n)
+    return n, err
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+    "archive/tar"
+    "archive/zip"
+    "bytes"
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+// testEntry is a file, or a folder if its name ends in "/".
+type testEntry struct {
+    name string
+    size int
+}
+
+func writeZip(t *testing.T, file string, entries []testEntry) int64 {
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for _, e := range entries {
+        w, err := zw.Create(e.name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := w.Write(make([]byte, e.size)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return int64(buf.Len())
+}
+
+func writeTar(t *testing.T, file string, entries []testEntry) int64 {
+    var buf bytes.Buffer
+    tw := tar.NewWriter(&buf)
+    for _, e := range entries {
+        header := &tar.Header{Name: e.name, Mode: 0644, Size: int64(e.size), Typeflag: tar.TypeReg}
+        if e.name[len(e.name)-1] == '/' {
+            header.Mode, header.Typeflag = 0755, tar.TypeDir
+        }
+        if err := tw.WriteHeader(header); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := tw.Write(make([]byte, e.size)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := tw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(file, buf.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+    return int64(buf.Len())
+}
+
+func TestExtractRejectsUnsafePaths(t *testing.T) {
+    tests := []struct {
+        name  string
+        entry string
+        err   error
+    }{
+        {"plain file", "docs/readme.txt", nil},
+        {"current folder", "./readme.txt", nil},
+        {"dots in name", "docs/..readme.txt", nil},
+        {"parent", "../evil.txt", ErrUnsafeArchivePath},
+        {"nested parent", "docs/../../evil.txt", ErrUnsafeArchivePath},
+        {"parent that stays inside", "docs/../readme.txt", ErrUnsafeArchivePath},
+        {"absolute", "/etc/evil.txt", ErrUnsafeArchivePath},
+        {"backslash parent", `..\evil.txt`, ErrUnsafeArchivePath},
+        {"backslash absolute", `\etc\evil.txt`, ErrUnsafeArchivePath},
+    }
+    for _, format := range []string{".zip", ".tar"} {
+        for _, tt := range tests {
+            t.Run(format+"/"+tt.name, func(t *testing.T) {
+                dir := t.TempDir()
+                archive := filepath.Join(dir, "bundle"+format)
+                staging := filepath.Join(dir, "staging")
+                if err := os.Mkdir(staging, 0700); err != nil {
+                    t.Fatal(err)
+                }
+                entries := []testEntry{{tt.entry, 4}}
+                ex := &extractor{ctx: context.Background(), root: staging, limits: DefaultExtractLimits,
+                    quotaLeft: unlimitedQuota, result: &ExtractResult{}}
+                var err error
+                if format == ".zip" {
+                    ex.archiveSize = writeZip(t, archive, entries)
+                    err = ex.extractZip(archive)
+                } else {
+                    ex.archiveSize = writeTar(t, archive, entries)
+                    err = ex.extractTar(archive, format)
+                }
+                if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+                    t.Fatalf("extracting %q: error = %v, want %v", tt.entry, err, tt.err)
+                }
+                if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+                    t.Errorf("extracting %q wrote outside the target folder", tt.entry)
+                }
+            })
+        }
+    }
+}
+
+func TestExtractLimits(t *testing.T) {
+    const mb = 1 << 20
+    tests := []struct {
+        name    string
+        entries []testEntry
+        limits  ExtractLimits
+        quota   int64
+        err     error
+    }{
+        {"within limits", []testEntry{{"a.txt", mb}, {"b/", 0}, {"b/c.txt", mb}}, DefaultExtractLimits, unlimitedQuota, nil},
+        {"too many entries", []testEntry{{"a.txt", 1}, {"b.txt", 1}, {"c.txt", 1}}, ExtractLimits{MaxEntries: 2}, unlimitedQuota, ErrExtractLimit},
+        {"too many bytes", []testEntry{{"a.txt", mb}, {"b.txt", mb}}, ExtractLimits{MaxBytes: mb + 1}, unlimitedQuota, ErrExtractLimit},
+        {"over quota", []testEntry{{"a.txt", mb}}, DefaultExtractLimits, mb / 2, ErrQuotaExceeded},
+        {"ratio within grace", []testEntry{{"zeros.txt", 8 * mb}}, ExtractLimits{MaxRatio: 10}, unlimitedQuota, nil},
+        {"ratio beyond grace", []testEntry{{"zeros.txt", 24 * mb}}, ExtractLimits{MaxRatio: 10}, unlimitedQuota, ErrExtractLimit},
+        {"ratio allowed", []testEntry{{"zeros.txt", 24 * mb}}, ExtractLimits{MaxRatio: 2000}, unlimitedQuota, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            archive := filepath.Join(dir, "bundle.zip")
+            ex := &extractor{ctx: context.Background(), root: dir, limits: tt.limits, quotaLeft: tt.quota,
+                archiveSize: writeZip(t, archive, tt.entries), result: &ExtractResult{}}
+            if err := ex.extractZip(archive); !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+                t.Errorf("extractZip() error = %v, want %v", err, tt.err)
+            }
+        })
+    }
+}
+
+func TestExtractSkipsDisallowedTypes(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{AllowedFileTypes: ".pdf,.zip"})
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for name, content := range map[string]string{
+        "plan.pdf":  "%PDF-1.4\n",
+        "fake.pdf":  "MZ\x90\x00\x03\x00\x00\x00",
+        "tool.exe":  "MZ\x90\x00\x03\x00\x00\x00",
+        "empty.pdf": "",
+    } {
+        w, err := zw.Create(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := w.Write([]byte(content)); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, fs, "docs/bundle.zip", buf.String())
+
+    result, err := fs.ExtractArchive(context.Background(), "/data/docs/bundle.zip", "/data/docs", ConflictFail, "2", DefaultExtractLimits, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sort.Strings(result.Skipped)
+    if got := strings.Join(result.Skipped, " "); got != "fake.pdf tool.exe" {
+        t.Errorf("skipped = %q, want the misnamed and the disallowed file", got)
+    }
+    for _, name := range []string{"plan.pdf", "empty.pdf"} {
+        if _, err := os.Stat(diskPath(fs, "docs/"+name)); err != nil {
+            t.Errorf("%s was not extracted: %v", name, err)
+        }
+    }
+    if _, err := os.Stat(diskPath(fs, "docs/fake.pdf")); !os.IsNotExist(err) {
+        t.Error("fake.pdf was extracted")
+    }
+}
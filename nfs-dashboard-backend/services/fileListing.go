@@ -0,0 +1,498 @@
+package services
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "nfs-dashboard-backend/models"
+)
+
+// ListSort is the order of a directory listing.
+type ListSort string
+
+const (
+    SortByName     ListSort = "name"
+    SortBySize     ListSort = "size"
+    SortByModified ListSort = "mtime"
+    SortByType     ListSort = "type"
+)
+
+const (
+    // MaxListLimit bounds the page size of a directory listing.
+    MaxListLimit = 5000
+    // listBatchSize is how many entries are read at a time when streaming.
+    listBatchSize = 1000
+)
+
+var (
+    // ErrInvalidCursor is returned for cursors that are malformed or belong
+    // to a listing with different options.
+    ErrInvalidCursor = errors.New("invalid cursor for this listing")
+    // ErrInvalidPattern is returned for malformed glob filters.
+    ErrInvalidPattern = errors.New("invalid glob pattern")
+)
+
+// ParseListSort parses a sort name, defaulting to SortByName.
+func ParseListSort(s string) (ListSort, error) {
+    switch strings.ToLower(s) {
+    case "", "name":
+        return SortByName, nil
+    case "size":
+        return SortBySize, nil
+    case "mtime", "modified":
+        return SortByModified, nil
+    case "type":
+        return SortByType, nil
+    }
+    return "", fmt.Errorf("unknown sort %q", s)
+}
+
+// ListOptions controls the order, filtering and paging of a listing.
+type ListOptions struct {
+    Sort       ListSort
+    Descending bool
+    DirsFirst  bool
+    Query      string // case-insensitive name substring
+    Glob       string // shell pattern matched against the name
+    Limit      int    // entries per page, 0 for all
+    Cursor     string // NextCursor of the previous page
+    Detail     bool   // include POSIX metadata
+
+    // Scope, when set, hides entries the caller can neither read nor
+    // navigate through, before paging so pages stay full.
+    Scope *AccessScope
+}
+
+// ListPage is one page of a directory listing.
+type ListPage struct {
+    Files      []models.File
+    NextCursor string // empty on the last page
+}
+
+// listEntry is a directory entry whose metadata is read only when needed,
+// so sorting by name does not stat every file of a huge directory.
+type listEntry struct {
+    name  string
+    isDir bool
+    typ   string // content type by extension, for SortByType
+    path  *resolvedPath
+    d     os.DirEntry
+    file  *models.File
+}
+
+// listKey holds the fields entries are ordered by; cursors carry the key
+// of the last entry returned.
+type listKey struct {
+    Name  string `json:"n"`
+    IsDir bool   `json:"d,omitempty"`
+    Size  int64  `json:"s,omitempty"`
+    Mod   int64  `json:"m,omitempty"`
+    Type  string `json:"t,omitempty"`
+}
+
+type listCursor struct {
+    Options string  `json:"o"`
+    Last    listKey `json:"k"`
+}
+
+// ListDirectory lists a folder, a share root or a folder inside an archive
+// according to opts. Entries that vanish while the folder is read are left
+// out rather than failing the listing.
+func (fs *FileService) ListDirectory(p string, opts ListOptions) (*ListPage, error) {
+    entries, err := fs.listEntries(p, opts)
+    if err != nil {
+        return nil, err
+    }
+    if opts.Limit > MaxListLimit {
+        opts.Limit = MaxListLimit
+    }
+    types := fs.ContentTypes()
+    if opts.Sort == SortBySize || opts.Sort == SortByModified {
+        loaded := entries[:0]
+        for i := range entries {
+            if fs.loadEntry(&entries[i], types, false) == nil {
+                loaded = append(loaded, entries[i])
+            }
+        }
+        entries = loaded
+    }
+    if opts.Sort == SortByType {
+        for i := range entries {
+            if !entries[i].isDir {
+                entries[i].typ = types.Detect(entries[i].name, nil)
+            }
+        }
+    }
+    sort.SliceStable(entries, func(i, j int) bool {
+        return compareListKeys(entries[i].key(), entries[j].key(), opts) < 0
+    })
+
+    start := 0
+    if opts.Cursor != "" {
+        last, err := decodeListCursor(opts.Cursor, opts)
+        if err != nil {
+            return nil, err
+        }
+        start = sort.Search(len(entries), func(i int) bool {
+            return compareListKeys(entries[i].key(), last, opts) > 0
+        })
+    }
+
+    page := &ListPage{Files: []models.File{}}
+    i := start
+    for ; i < len(entries) && (opts.Limit <= 0 || len(page.Files) < opts.Limit); i++ {
+        if fs.loadEntry(&entries[i], types, opts.Detail) != nil {
+            continue
+        }
+        page.Files = append(page.Files, *entries[i].file)
+    }
+    if i < len(entries) && len(page.Files) > 0 {
+        page.NextCursor = encodeListCursor(entries[i-1].key(), opts)
+    }
+    return page, nil
+}
+
+// CountFiles counts the entries of a folder that a listing with the same
+// name filters and scope would return.
+func (fs *FileService) CountFiles(p string, opts ListOptions) (*models.DirectoryCount, error) {
+    entries, err := fs.listEntries(p, opts)
+    if err != nil {
+        return nil, err
+    }
+    count := &models.DirectoryCount{Path: p, Total: len(entries)}
+    for _, entry := range entries {
+        if entry.isDir {
+            count.Dirs++
+        } else {
+            count.Files++
+        }
+    }
+    return count, nil
+}
+
+// StreamDirectory calls fn with each entry of p in directory order while the
+// folder is read in batches, so huge folders are never held in memory. Name
+// filters and scope apply; sorting and paging do not. It stops as soon as
+// ctx is done or fn fails.
+func (fs *FileService) StreamDirectory(ctx context.Context, p string, opts ListOptions, fn func(models.File) error) error {
+    filter, err := newListFilter(opts)
+    if err != nil {
+        return err
+    }
+    preloaded, ok, err := fs.preloadedEntries(p, filter)
+    if err != nil {
+        return err
+    }
+    if ok {
+        for _, entry := range preloaded {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if err := fn(*entry.file); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+
+    dir, err := fs.resolveDirectory(p)
+    if err != nil {
+        return err
+    }
+    f, err := os.Open(dir.abs)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    types := fs.ContentTypes()
+    for {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        items, readErr := f.ReadDir(listBatchSize)
+        for _, item := range items {
+            entry, ok := filter.entry(dir, item)
+            if !ok || fs.loadEntry(&entry, types, opts.Detail) != nil {
+                continue
+            }
+            if err := fn(*entry.file); err != nil {
+                return err
+            }
+        }
+        if readErr == io.EOF {
+            return nil
+        }
+        if readErr != nil {
+            return readErr
+        }
+    }
+}
+
+// listEntries collects the entries of p that pass the name filters and scope,
+// without reading their metadata unless they are symlinks.
+func (fs *FileService) listEntries(p string, opts ListOptions) ([]listEntry, error) {
+    filter, err := newListFilter(opts)
+    if err != nil {
+        return nil, err
+    }
+    preloaded, ok, err := fs.preloadedEntries(p, filter)
+    if err != nil || ok {
+        return preloaded, err
+    }
+
+    dir, err := fs.resolveDirectory(p)
+    if err != nil {
+        return nil, err
+    }
+    items, err := os.ReadDir(dir.abs)
+    if err != nil {
+        return nil, err
+    }
+    entries := make([]listEntry, 0, len(items))
+    for _, item := range items {
+        if entry, ok := filter.entry(dir, item); ok {
+            entries = append(entries, entry)
+        }
+    }
+    return entries, nil
+}
+
+// preloadedEntries lists share roots and archive folders, whose metadata
+// comes with the listing. ok is false for ordinary folders.
+func (fs *FileService) preloadedEntries(p string, filter *listFilter) ([]listEntry, bool, error) {
+    clean, err := cleanVirtualPath(p)
+    if err != nil {
+        return nil, false, err
+    }
+    var files []models.File
+    archive, member, inArchive := fs.archivePath(clean)
+    switch {
+    case clean == "/":
+        files, err = fs.listRoots()
+    case inArchive:
+        files, err = fs.listArchive(archive, member)
+    default:
+        return nil, false, nil
+    }
+    if err != nil {
+        return nil, true, err
+    }
+    entries := []listEntry{}
+    for i := range files {
+        file := &files[i]
+        if filter.match(file.Name) && filter.visible(file.Path, file.IsDir) {
+            entry := listEntry{name: file.Name, isDir: file.IsDir, file: file}
+            if !inArchive {
+                // Share roots have POSIX metadata for detailed listings.
+                entry.path, _ = fs.resolve(file.Path)
+            }
+            entries = append(entries, entry)
+        }
+    }
+    return entries, true, nil
+}
+
+// resolveDirectory resolves p and checks that it is an existing folder.
+func (fs *FileService) resolveDirectory(p string) (*resolvedPath, error) {
+    dir, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    info, err := os.Stat(dir.abs)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, errors.New("directory does not exist")
+        }
+        return nil, err
+    }
+    if !info.IsDir() {
+        return nil, errors.New("provided path is not a directory")
+    }
+    return dir, nil
+}
+
+// listFilter holds the name filters and scope of a listing.
+type listFilter struct {
+    match func(name string) bool
+    scope *AccessScope
+}
+
+func newListFilter(opts ListOptions) (*listFilter, error) {
+    match, err := listNameMatcher(opts.Query, opts.Glob)
+    if err != nil {
+        return nil, err
+    }
+    return &listFilter{match: match, scope: opts.Scope}, nil
+}
+
+func (lf *listFilter) visible(virtual string, isDir bool) bool {
+    return lf.scope == nil || lf.scope.CanSee(virtual, isDir)
+}
+
+// entry turns an item of dir into a listing entry, reporting false for
+// items the listing leaves out.
+func (lf *listFilter) entry(dir *resolvedPath, item os.DirEntry) (listEntry, bool) {
+    name := item.Name()
+    if (dir.IsRoot() && name == internalDirName) || !lf.match(name) {
+        return listEntry{}, false
+    }
+    // Entries of a contained folder are contained unless they are
+    // symlinks, which entryInfo checks.
+    entry := listEntry{
+        name:  name,
+        isDir: item.IsDir(),
+        path:  &resolvedPath{root: dir.root, rel: path.Join(dir.rel, name), abs: filepath.Join(dir.abs, name)},
+        d:     item,
+    }
+    if item.Type()&os.ModeSymlink != 0 {
+        info, err := entryInfo(entry.path, item)
+        if err != nil {
+            // Skip symlinks that dangle or point outside the share.
+            return listEntry{}, false
+        }
+        entry.isDir = info.IsDir()
+        // Symlinks show up only if their target would.
+        if !lf.visible(entry.path.realVirtual(), entry.isDir) {
+            return listEntry{}, false
+        }
+    }
+    return entry, lf.visible(entry.path.Virtual(), entry.isDir)
+}
+
+// loadEntry reads the metadata of an entry, and with detail its POSIX
+// metadata, if that has not happened yet.
+func (fs *FileService) loadEntry(entry *listEntry, types ContentTypeDetector, detail bool) error {
+    if entry.file == nil {
+        info, err := entryInfo(entry.path, entry.d)
+        if err != nil {
+            logListError(entry, err)
+            return err
+        }
+        file := fileFromInfo(entry.path, info)
+        if !file.IsDir {
+            file.ContentType = types.Detect(file.Name, nil)
+        }
+        file.Lock = fs.locks.lockOf(entry.path)
+        entry.file = &file
+    }
+    if detail && entry.path != nil && entry.file.Posix == nil {
+        posix, err := posixInfo(entry.path)
+        if err != nil {
+            logListError(entry, err)
+            return err
+        }
+        entry.file.Posix = posix
+    }
+    return nil
+}
+
+// logListError logs why an entry was left out of a listing, unless it
+// simply vanished.
+func logListError(entry *listEntry, err error) {
+    if !os.IsNotExist(err) {
+        log.Printf("Failed to read %s while listing: %v", entry.path.Virtual(), err)
+    }
+}
+
+func (entry *listEntry) key() listKey {
+    key := listKey{Name: entry.name, IsDir: entry.isDir, Type: entry.typ}
+    if entry.file != nil {
+        key.Size = entry.file.Size
+        key.Mod = entry.file.LastModified.UnixNano()
+    }
+    return key
+}
+
+// compareListKeys orders two entries, breaking ties by name so the order
+// is total and cursors are unambiguous.
+func compareListKeys(a, b listKey, opts ListOptions) int {
+    if opts.DirsFirst && a.IsDir != b.IsDir {
+        if a.IsDir {
+            return -1
+        }
+        return 1
+    }
+    c := 0
+    switch opts.Sort {
+    case SortBySize:
+        c = compareInt64(a.Size, b.Size)
+    case SortByModified:
+        c = compareInt64(a.Mod, b.Mod)
+    case SortByType:
+        c = strings.Compare(a.Type, b.Type)
+    }
+    if c == 0 {
+        c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+    }
+    if c == 0 {
+        c = strings.Compare(a.Name, b.Name)
+    }
+    if opts.Descending {
+        c = -c
+    }
+    return c
+}
+
+func compareInt64(a, b int64) int {
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    }
+    return 0
+}
+
+// listOptionsKey identifies the options a cursor is valid for.
+func listOptionsKey(opts ListOptions) string {
+    return fmt.Sprintf("%s|%t|%t|%s|%s", opts.Sort, opts.Descending, opts.DirsFirst, opts.Query, opts.Glob)
+}
+
+func encodeListCursor(last listKey, opts ListOptions) string {
+    data, _ := json.Marshal(listCursor{Options: listOptionsKey(opts), Last: last})
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(cursor string, opts ListOptions) (listKey, error) {
+    data, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return listKey{}, ErrInvalidCursor
+    }
+    var decoded listCursor
+    if err := json.Unmarshal(data, &decoded); err != nil || decoded.Options != listOptionsKey(opts) {
+        return listKey{}, ErrInvalidCursor
+    }
+    return decoded.Last, nil
+}
+
+// listNameMatcher compiles the name filters of a listing.
+func listNameMatcher(query, glob string) (func(name string) bool, error) {
+    query, glob = strings.ToLower(query), strings.ToLower(glob)
+    if glob != "" {
+        if _, err := path.Match(glob, ""); err != nil {
+            return nil, ErrInvalidPattern
+        }
+    }
+    return func(name string) bool {
+        lower := strings.ToLower(name)
+        if query != "" && !strings.Contains(lower, query) {
+            return false
+        }
+        if glob != "" {
+            if ok, _ := path.Match(glob, lower); !ok {
+                return false
+            }
+        }
+        return true
+    }, nil
+}
@@ -0,0 +1,413 @@
+package services
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+var (
+    // ErrLocked is matched by errors reporting that a path is locked by
+    // someone else.
+    ErrLocked = errors.New("path is locked")
+    // ErrLockNotFound is returned when unlocking a path that is not locked.
+    ErrLockNotFound = errors.New("path is not locked")
+    // ErrFlockHeld is returned when another program holds an flock on a
+    // file being locked.
+    ErrFlockHeld = fmt.Errorf("%w by another program", ErrLocked)
+    // ErrFlockUnsupported is returned when an flock is requested where the
+    // platform or the kind of entry does not support one.
+    ErrFlockUnsupported = errors.New("flock is only supported on files of Linux servers")
+)
+
+const (
+    // DefaultLockDuration applies when a lock is taken without a duration.
+    DefaultLockDuration = time.Hour
+    // MaxLockDuration bounds how long a lock can be held without renewing it.
+    MaxLockDuration = 7 * 24 * time.Hour
+    // locksFile holds, inside each share's internal area, the locks of the
+    // share keyed by share-relative path.
+    locksFile = "locks.json"
+)
+
+// LockedError reports the lock that stopped an operation.
+type LockedError struct {
+    Lock models.FileLock
+}
+
+func (e *LockedError) Error() string {
+    holder := e.Lock.OwnerName
+    if holder == "" {
+        holder = "user " + e.Lock.Owner
+    }
+    return fmt.Sprintf("%s is locked by %s until %s", e.Lock.Path, holder, e.Lock.ExpiresAt.Format(time.RFC3339))
+}
+
+// Is makes errors.Is(err, ErrLocked) match.
+func (e *LockedError) Is(target error) bool {
+    return target == ErrLocked
+}
+
+// LockOptions are the settings of a new lock.
+type LockOptions struct {
+    Duration time.Duration // 0 for DefaultLockDuration
+    Note     string
+    Flock    bool // also take an flock(2) lock other tools on the share see
+}
+
+// heldLock is a lock along with the open file holding its flock, if any.
+type heldLock struct {
+    models.FileLock
+    handle *os.File
+}
+
+// release drops the flock of a lock, if it has one.
+func (l *heldLock) release() {
+    if l.handle != nil {
+        l.handle.Close()
+        l.handle = nil
+    }
+}
+
+// lockTable holds the advisory locks of every share, keyed by the entry
+// they lock so that symlinked folders cannot get around them. Locks are
+// stored in the share itself so they survive restarts, but each share's file
+// is read only on first use: dashboards serving the same share do not see
+// each other's locks. Expired locks are ignored and dropped on the next save.
+type lockTable struct {
+    mu     sync.Mutex
+    shares map[string]map[string]*heldLock // share path -> rel path -> lock
+}
+
+func newLockTable() *lockTable {
+    return &lockTable{shares: make(map[string]map[string]*heldLock)}
+}
+
+// share returns the locks of a share, loading them and taking their flocks
+// again on first use. Callers must hold mu.
+func (t *lockTable) share(root *ShareRoot) map[string]*heldLock {
+    if locks, ok := t.shares[root.Path]; ok {
+        return locks
+    }
+    locks := make(map[string]*heldLock)
+    stored := make(map[string]models.FileLock)
+    data, err := os.ReadFile(filepath.Join(root.Path, internalDirName, locksFile))
+    if err == nil {
+        if err := json.Unmarshal(data, &stored); err != nil {
+            log.Printf("Ignoring unreadable %s in share %s: %v", locksFile, root.Name, err)
+        }
+    }
+    now := time.Now()
+    for rel, lock := range stored {
+        if !now.Before(lock.ExpiresAt) {
+            continue
+        }
+        lock.Path = path.Join("/", root.Name, rel)
+        held := &heldLock{FileLock: lock}
+        if lock.Flock {
+            if held.handle, err = acquireFlock(filepath.Join(root.Path, filepath.FromSlash(rel))); err != nil {
+                log.Printf("Failed to take the flock of %s again: %v", lock.Path, err)
+            }
+        }
+        locks[rel] = held
+    }
+    t.shares[root.Path] = locks
+    return locks
+}
+
+// save persists the active locks of a share. Callers must hold mu.
+func (t *lockTable) save(root *ShareRoot) {
+    stored := make(map[string]models.FileLock)
+    now := time.Now()
+    locks := t.share(root)
+    for rel, lock := range locks {
+        if !now.Before(lock.ExpiresAt) {
+            lock.release()
+            delete(locks, rel)
+            continue
+        }
+        stored[rel] = lock.FileLock
+    }
+    dir, err := root.internalDir("")
+    if err == nil {
+        var data []byte
+        if data, err = json.Marshal(stored); err == nil {
+            tmp := filepath.Join(dir, locksFile+".tmp")
+            if err = os.WriteFile(tmp, data, 0600); err == nil {
+                err = os.Rename(tmp, filepath.Join(dir, locksFile))
+            }
+        }
+    }
+    if err != nil {
+        log.Printf("Failed to save locks of share %s: %v", root.Name, err)
+    }
+}
+
+// conflict returns an active lock of someone other than holder on p, an
+// ancestor of p or anything inside p. Callers must hold mu.
+func (t *lockTable) conflict(p *resolvedPath, holder string, now time.Time) *heldLock {
+    target := p.realRel()
+    for rel, lock := range t.share(p.root) {
+        if lock.Owner == holder || !now.Before(lock.ExpiresAt) {
+            continue
+        }
+        if relWithin(target, rel) || relWithin(rel, target) {
+            return lock
+        }
+    }
+    return nil
+}
+
+// lockOf returns the active lock on p itself, if any.
+func (t *lockTable) lockOf(p *resolvedPath) *models.FileLock {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    lock, ok := t.share(p.root)[p.realRel()]
+    if !ok || !time.Now().Before(lock.ExpiresAt) {
+        return nil
+    }
+    copied := lock.FileLock
+    return &copied
+}
+
+// moved carries the locks on and beneath src over to dst, taking their
+// flocks again on the moved files.
+func (t *lockTable) moved(src, dst *resolvedPath) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    from := t.share(src.root)
+    to := t.share(dst.root)
+    srcRel, dstRel := src.realRel(), dst.realRel()
+    moving := make(map[string]*heldLock)
+    for rel, lock := range from {
+        if relWithin(srcRel, rel) {
+            moving[rel] = lock
+            delete(from, rel)
+        }
+    }
+    changed := len(moving) > 0
+    for rel, lock := range moving {
+        newRel := path.Join(dstRel, strings.TrimPrefix(strings.TrimPrefix(rel, srcRel), "/"))
+        lock.Path = path.Join("/", dst.root.Name, newRel)
+        if lock.handle != nil {
+            lock.release()
+            handle, err := acquireFlock(filepath.Join(dst.root.Path, filepath.FromSlash(newRel)))
+            if err != nil {
+                log.Printf("Failed to take the flock of %s again after moving it: %v", lock.Path, err)
+            }
+            lock.handle = handle
+        }
+        to[newRel] = lock
+    }
+    if changed {
+        t.save(src.root)
+        if dst.root.Path != src.root.Path {
+            t.save(dst.root)
+        }
+    }
+}
+
+// replaced takes the flock of a lock on p again once the file was replaced,
+// since flocks stay with the file they were taken on.
+func (t *lockTable) replaced(p *resolvedPath) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    lock, ok := t.share(p.root)[p.realRel()]
+    if !ok || lock.handle == nil {
+        return
+    }
+    lock.release()
+    handle, err := acquireFlock(p.abs)
+    if err != nil {
+        log.Printf("Failed to take the flock of %s again after replacing it: %v", lock.Path, err)
+    }
+    lock.handle = handle
+}
+
+// removed drops the locks on and beneath p.
+func (t *lockTable) removed(p *resolvedPath) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    locks := t.share(p.root)
+    target := p.realRel()
+    changed := false
+    for rel, lock := range locks {
+        if relWithin(target, rel) {
+            lock.release()
+            delete(locks, rel)
+            changed = true
+        }
+    }
+    if changed {
+        t.save(p.root)
+    }
+}
+
+// relWithin reports whether rel is dir or lies beneath it.
+func relWithin(dir, rel string) bool {
+    return dir == "" || rel == dir || strings.HasPrefix(rel, dir+"/")
+}
+
+// LockItem locks the file or folder at p for owner, refusing while someone
+// else holds a lock on it, on a folder containing it or on anything inside
+// it. Locking a path again renews the owner's lock.
+func (fs *FileService) LockItem(p, owner, ownerName string, opts LockOptions) (*models.FileLock, error) {
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    if item.IsRoot() {
+        return nil, errors.New("cannot lock a share root")
+    }
+    info, err := os.Lstat(item.abs)
+    if os.IsNotExist(err) {
+        return nil, errors.New("item does not exist")
+    }
+    if err != nil {
+        return nil, err
+    }
+    if opts.Duration == 0 {
+        opts.Duration = DefaultLockDuration
+    }
+    if opts.Duration < 0 || opts.Duration > MaxLockDuration {
+        return nil, fmt.Errorf("lock duration must be between now and %d days", int(MaxLockDuration/(24*time.Hour)))
+    }
+    if opts.Flock && !info.Mode().IsRegular() {
+        return nil, ErrFlockUnsupported
+    }
+
+    fs.locks.mu.Lock()
+    defer fs.locks.mu.Unlock()
+    now := time.Now()
+    if held := fs.locks.conflict(item, owner, now); held != nil {
+        return nil, &LockedError{Lock: held.FileLock}
+    }
+    locks := fs.locks.share(item.root)
+    rel := item.realRel()
+    lock, renewed := locks[rel]
+    if renewed && (lock.Owner != owner || !now.Before(lock.ExpiresAt)) {
+        lock.release()
+        renewed = false
+    }
+    if !renewed {
+        lock = &heldLock{FileLock: models.FileLock{Path: path.Join("/", item.root.Name, rel), Owner: owner, LockedAt: now}}
+    }
+    if opts.Flock && lock.handle == nil {
+        if lock.handle, err = acquireFlock(item.abs); err != nil {
+            return nil, err
+        }
+    } else if !opts.Flock {
+        lock.release()
+    }
+    lock.OwnerName = ownerName
+    lock.ExpiresAt = now.Add(opts.Duration)
+    lock.Note = opts.Note
+    lock.Flock = opts.Flock
+    locks[rel] = lock
+    fs.locks.save(item.root)
+
+    if !renewed && fs.audit != nil {
+        fs.audit.RecordAuditLog("lock", owner, fmt.Sprintf("Locked %s until %s", lock.Path, lock.ExpiresAt.Format(time.RFC3339)))
+    }
+    copied := lock.FileLock
+    return &copied, nil
+}
+
+// UnlockItem releases the lock on p. Only its owner may, unless force is
+// set for admins breaking someone else's lock.
+func (fs *FileService) UnlockItem(p, user string, force bool) error {
+    item, err := fs.resolve(p)
+    if err != nil {
+        return err
+    }
+    fs.locks.mu.Lock()
+    defer fs.locks.mu.Unlock()
+    locks := fs.locks.share(item.root)
+    rel := item.realRel()
+    lock, ok := locks[rel]
+    if !ok || !time.Now().Before(lock.ExpiresAt) {
+        return ErrLockNotFound
+    }
+    if lock.Owner != user && !force {
+        return &LockedError{Lock: lock.FileLock}
+    }
+    lock.release()
+    delete(locks, rel)
+    fs.locks.save(item.root)
+
+    if fs.audit != nil {
+        details := "Unlocked " + lock.Path
+        if lock.Owner != user {
+            details = fmt.Sprintf("Broke the lock of user %s on %s", lock.Owner, lock.Path)
+        }
+        fs.audit.RecordAuditLog("unlock", user, details)
+    }
+    return nil
+}
+
+// GetLock returns the active lock on p itself.
+func (fs *FileService) GetLock(p string) (*models.FileLock, error) {
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    if lock := fs.locks.lockOf(item); lock != nil {
+        return lock, nil
+    }
+    return nil, ErrLockNotFound
+}
+
+// UserLookup finds users by ID.
+type UserLookup interface {
+    UserByID(id string) (*models.User, error)
+}
+
+// checkUnlocked fails with a LockedError when changing item would touch
+// something locked by anyone but user. Admins may change locked paths.
+func (fs *FileService) checkUnlocked(item *resolvedPath, user string) error {
+    if fs.users != nil {
+        if actor, err := fs.users.UserByID(user); err == nil && IsAdmin(actor) {
+            return nil
+        }
+    }
+    fs.locks.mu.Lock()
+    defer fs.locks.mu.Unlock()
+    if held := fs.locks.conflict(item, user, time.Now()); held != nil {
+        return &LockedError{Lock: held.FileLock}
+    }
+    return nil
+}
+
+// ExpireLocks drops expired locks, releasing their flocks.
+func (fs *FileService) ExpireLocks() {
+    fs.locks.mu.Lock()
+    defer fs.locks.mu.Unlock()
+    now := time.Now()
+    for i := range fs.roots {
+        root := &fs.roots[i]
+        for _, lock := range fs.locks.share(root) {
+            if !now.Before(lock.ExpiresAt) {
+                fs.locks.save(root)
+                break
+            }
+        }
+    }
+}
+
+// StartLockExpiry drops expired locks every interval in the background.
+func (fs *FileService) StartLockExpiry(interval time.Duration) {
+    go func() {
+        for {
+            time.Sleep(interval)
+            fs.ExpireLocks()
+        }
+    }()
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+    "os"
+    "syscall"
+)
+
+// acquireFlock takes an exclusive flock(2) lock on a file, failing with
+// ErrFlockHeld when another process holds one. The lock lasts until the
+// returned file is closed. On NFS the kernel turns it into a byte-range
+// lock, which needs the file open for writing.
+func acquireFlock(abs string) (*os.File, error) {
+    f, err := os.OpenFile(abs, os.O_RDWR, 0)
+    if err != nil {
+        return nil, err
+    }
+    if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+        f.Close()
+        if err == syscall.EWOULDBLOCK {
+            return nil, ErrFlockHeld
+        }
+        return nil, err
+    }
+    return f, nil
+}
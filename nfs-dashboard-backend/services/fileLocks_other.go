@@ -0,0 +1,10 @@
+// +build !linux
+
+package services
+
+import "os"
+
+// acquireFlock is only implemented on Linux.
+func acquireFlock(abs string) (*os.File, error) {
+    return nil, ErrFlockUnsupported
+}
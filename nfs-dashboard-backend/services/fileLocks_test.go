@@ -0,0 +1,99 @@
+package services
+
+import (
+    "errors"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestLocksHoldThroughSymlinks(t *testing.T) {
+    tests := []struct {
+        name   string
+        locked string // locked by user 1
+        change func(fs *FileService, user string) error
+        want   error // for user 2; the holder may always go ahead
+    }{
+        {"upload through alias", "/data/docs/readme.txt", func(fs *FileService, user string) error {
+            _, err := fs.UploadFile("/data/link", "readme.txt", user, strings.NewReader("new\n"), nil)
+            return err
+        }, ErrLocked},
+        {"rename through alias", "/data/docs/readme.txt", func(fs *FileService, user string) error {
+            _, err := fs.RenameItem("/data/link/readme.txt", "notes.txt", user)
+            return err
+        }, ErrLocked},
+        {"delete through alias", "/data/docs/readme.txt", func(fs *FileService, user string) error {
+            _, err := fs.DeleteItem("/data/link/readme.txt", user)
+            return err
+        }, ErrLocked},
+        {"new file in locked folder through alias", "/data/docs", func(fs *FileService, user string) error {
+            _, err := fs.UploadFile("/data/link", "new.txt", user, strings.NewReader("new\n"), nil)
+            return err
+        }, ErrLocked},
+        {"locked through alias then deleted directly", "/data/link/readme.txt", func(fs *FileService, user string) error {
+            _, err := fs.DeleteItem("/data/docs/readme.txt", user)
+            return err
+        }, ErrLocked},
+        {"symlink itself is not the locked file", "/data/docs/readme.txt", func(fs *FileService, user string) error {
+            _, err := fs.DeleteItem("/data/link", user)
+            return err
+        }, nil},
+        {"unlocked sibling", "/data/docs/readme.txt", func(fs *FileService, user string) error {
+            _, err := fs.UploadFile("/data/link", "other.txt", user, strings.NewReader("new\n"), nil)
+            return err
+        }, nil},
+    }
+    for _, tt := range tests {
+        for _, user := range []string{"2", "1"} {
+            t.Run(tt.name+" as user "+user, func(t *testing.T) {
+                fs := newTestFiles(t, models.SystemSettings{})
+                if _, err := fs.LockItem(tt.locked, "1", "", LockOptions{}); err != nil {
+                    t.Fatal(err)
+                }
+                want := tt.want
+                if user == "1" {
+                    want = nil
+                }
+                if err := tt.change(fs, user); !errors.Is(err, want) || (want == nil && err != nil) {
+                    t.Errorf("error = %v, want %v", err, want)
+                }
+            })
+        }
+    }
+}
+
+func TestLockLookupThroughSymlinks(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    if _, err := fs.LockItem("/data/link/readme.txt", "1", "", LockOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    lock, err := fs.GetLock("/data/docs/readme.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if lock.Path != "/data/docs/readme.txt" {
+        t.Errorf("lock path = %q, want the real path", lock.Path)
+    }
+    if _, err := fs.LockItem("/data/docs/readme.txt", "2", "", LockOptions{}); !errors.Is(err, ErrLocked) {
+        t.Errorf("second lock through the real path: error = %v, want %v", err, ErrLocked)
+    }
+    if err := fs.UnlockItem("/data/docs/readme.txt", "1", false); err != nil {
+        t.Errorf("unlock through the real path: %v", err)
+    }
+}
+
+func TestLockFollowsRename(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    if _, err := fs.LockItem("/data/docs/readme.txt", "1", "", LockOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.RenameItem("/data/link/readme.txt", "notes.txt", "1"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.GetLock("/data/docs/notes.txt"); err != nil {
+        t.Errorf("lock did not follow the rename: %v", err)
+    }
+    if _, err := fs.GetLock("/data/docs/readme.txt"); err != ErrLockNotFound {
+        t.Errorf("lock left on the old name: %v", err)
+    }
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+    "fmt"
+    "os"
+    "os/user"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+// statDetails is the part of a stat result that os.FileInfo does not expose.
+type statDetails struct {
+    uid, gid     uint32
+    inode, links uint64
+    device       uint64
+    accessed     int64 // Unix nanoseconds
+    changed      int64
+}
+
+// posixInfo reads the POSIX metadata of p. Like the rest of models.File it
+// describes the target of a symlink, and additionally names the target.
+func posixInfo(p *resolvedPath) (*models.PosixInfo, error) {
+    linfo, err := os.Lstat(p.abs)
+    if err != nil {
+        return nil, err
+    }
+    result := &models.PosixInfo{}
+    info := linfo
+    if linfo.Mode()&os.ModeSymlink != 0 {
+        if result.SymlinkTarget, err = os.Readlink(p.abs); err != nil {
+            return nil, err
+        }
+        if info, err = os.Stat(p.abs); err != nil {
+            return nil, err
+        }
+    }
+    result.Mode = lsMode(info.Mode())
+    result.Permissions = fmt.Sprintf("%04o", unixPermissions(info.Mode()))
+
+    details, ok := statDetailsOf(info)
+    if !ok {
+        return result, nil
+    }
+    result.UID, result.GID = details.uid, details.gid
+    result.Owner, result.Group = accountNames.user(details.uid), accountNames.group(details.gid)
+    result.Inode, result.Links = details.inode, details.links
+    result.AccessedAt, result.ChangedAt = time.Unix(0, details.accessed), time.Unix(0, details.changed)
+
+    // A folder on another device than its parent is where a file system is
+    // mounted, e.g. an NFS export inside a share. The parent of a symlinked
+    // folder is that of its target, not of the link.
+    if info.IsDir() {
+        real, err := filepath.EvalSymlinks(p.abs)
+        if err != nil {
+            return nil, err
+        }
+        if parent, err := os.Stat(filepath.Dir(real)); err == nil {
+            if parentDetails, ok := statDetailsOf(parent); ok {
+                result.IsMountPoint = parentDetails.device != details.device ||
+                    parentDetails.inode == details.inode
+            }
+        }
+    }
+    return result, nil
+}
+
+// lsMode formats a file mode the way ls -l does, e.g. "-rwsr-x--x", which
+// differs from os.FileMode.String for special bits and file types.
+func lsMode(mode os.FileMode) string {
+    buf := []byte("----------")
+    switch {
+    case mode.IsDir():
+        buf[0] = 'd'
+    case mode&os.ModeSymlink != 0:
+        buf[0] = 'l'
+    case mode&os.ModeNamedPipe != 0:
+        buf[0] = 'p'
+    case mode&os.ModeSocket != 0:
+        buf[0] = 's'
+    case mode&os.ModeCharDevice != 0:
+        buf[0] = 'c'
+    case mode&os.ModeDevice != 0:
+        buf[0] = 'b'
+    }
+    const rwx = "rwxrwxrwx"
+    for i := 0; i < 9; i++ {
+        if mode&(1<<uint(8-i)) != 0 {
+            buf[i+1] = rwx[i]
+        }
+    }
+    special := func(pos int, set bool, lower, upper byte) {
+        if !set {
+            return
+        }
+        if buf[pos] == 'x' {
+            buf[pos] = lower
+        } else {
+            buf[pos] = upper
+        }
+    }
+    special(3, mode&os.ModeSetuid != 0, 's', 'S')
+    special(6, mode&os.ModeSetgid != 0, 's', 'S')
+    special(9, mode&os.ModeSticky != 0, 't', 'T')
+    return string(buf)
+}
+
+// unixPermissions converts Go's file mode into the classic octal bits.
+func unixPermissions(mode os.FileMode) uint32 {
+    bits := uint32(mode.Perm())
+    if mode&os.ModeSetuid != 0 {
+        bits |= 04000
+    }
+    if mode&os.ModeSetgid != 0 {
+        bits |= 02000
+    }
+    if mode&os.ModeSticky != 0 {
+        bits |= 01000
+    }
+    return bits
+}
+
+// accountNames caches uid and gid lookups, which can go to LDAP or NIS on
+// NFS servers and would otherwise be repeated for every entry of a listing.
+var accountNames = &idNameCache{users: map[uint32]string{}, groups: map[uint32]string{}}
+
+type idNameCache struct {
+    mu     sync.Mutex
+    users  map[uint32]string
+    groups map[uint32]string
+}
+
+// user returns the name of uid, or "" if it has none.
+func (c *idNameCache) user(uid uint32) string {
+    return c.lookup(c.users, uid, func(id string) (string, error) {
+        u, err := user.LookupId(id)
+        if err != nil {
+            return "", err
+        }
+        return u.Username, nil
+    })
+}
+
+// group returns the name of gid, or "" if it has none.
+func (c *idNameCache) group(gid uint32) string {
+    return c.lookup(c.groups, gid, func(id string) (string, error) {
+        g, err := user.LookupGroupId(id)
+        if err != nil {
+            return "", err
+        }
+        return g.Name, nil
+    })
+}
+
+func (c *idNameCache) lookup(names map[uint32]string, id uint32, find func(string) (string, error)) string {
+    c.mu.Lock()
+    name, ok := names[id]
+    c.mu.Unlock()
+    if ok {
+        return name
+    }
+    // Unknown ids are cached as "" too, so they are not looked up again.
+    name, _ = find(strconv.FormatUint(uint64(id), 10))
+    c.mu.Lock()
+    names[id] = name
+    c.mu.Unlock()
+    return name
+}
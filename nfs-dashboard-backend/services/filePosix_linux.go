@@ -0,0 +1,24 @@
+package services
+
+import (
+    "os"
+    "syscall"
+)
+
+// statDetailsOf extracts the owner, inode, link count and times of a stat
+// result.
+func statDetailsOf(info os.FileInfo) (statDetails, bool) {
+    st, ok := info.Sys().(*syscall.Stat_t)
+    if !ok {
+        return statDetails{}, false
+    }
+    return statDetails{
+        uid:      st.Uid,
+        gid:      st.Gid,
+        inode:    st.Ino,
+        links:    uint64(st.Nlink),
+        device:   uint64(st.Dev),
+        accessed: st.Atim.Nano(),
+        changed:  st.Ctim.Nano(),
+    }, true
+}
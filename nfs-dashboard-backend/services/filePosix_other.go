@@ -0,0 +1,11 @@
+// +build !linux
+
+package services
+
+import "os"
+
+// statDetailsOf is only implemented on Linux; elsewhere file info carries
+// the mode alone.
+func statDetailsOf(info os.FileInfo) (statDetails, bool) {
+    return statDetails{}, false
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestPosixInfoMountPoints(t *testing.T) {
+    // A symlink to a folder on another file system must not look like a
+    // mount point; it needs such a file system, as /dev/shm often is.
+    other, err := os.MkdirTemp("/dev/shm", "posix")
+    if err != nil {
+        t.Skip("no /dev/shm to link to")
+    }
+    defer os.RemoveAll(other)
+    dir := t.TempDir()
+    local, err := os.Stat(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    remote, err := os.Stat(other)
+    if err != nil {
+        t.Fatal(err)
+    }
+    localDetails, _ := statDetailsOf(local)
+    remoteDetails, _ := statDetailsOf(remote)
+    if localDetails.device == remoteDetails.device {
+        t.Skip("/dev/shm is on the same file system as the temporary folder")
+    }
+
+    link := filepath.Join(dir, "link")
+    if err := os.Symlink(other, link); err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name string
+        abs  string
+        want bool
+    }{
+        {"folder", dir, false},
+        {"symlink to another file system", link, false},
+        {"mount point", "/dev/shm", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            info, err := posixInfo(&resolvedPath{abs: tt.abs})
+            if err != nil {
+                t.Fatal(err)
+            }
+            if info.IsMountPoint != tt.want {
+                t.Errorf("IsMountPoint = %v, want %v", info.IsMountPoint, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+    "errors"
+    "io"
+    "net/mail"
+    "os"
+    "strings"
+    "unicode"
+    "nfs-dashboard-backend/models"
+)
+
+var (
+    // ErrSubmitterRequired is returned when a file request submission does
+    // not name its submitter.
+    ErrSubmitterRequired = errors.New("submitter name is required")
+    // ErrInvalidEmail is returned for malformed submitter addresses.
+    ErrInvalidEmail = errors.New("submitter email is invalid")
+)
+
+// maxSubmitterFolder bounds the length of per-submitter folder names.
+const maxSubmitterFolder = 100
+
+// Submitter identifies who sent files through a file request.
+type Submitter struct {
+    Name  string
+    Email string // optional
+}
+
+// NewSubmitter validates the name and email given on a file request form.
+func NewSubmitter(name, email string) (Submitter, error) {
+    name = strings.TrimSpace(name)
+    if name == "" {
+        return Submitter{}, ErrSubmitterRequired
+    }
+    email = strings.TrimSpace(email)
+    if email != "" {
+        addr, err := mail.ParseAddress(email)
+        if err != nil || addr.Address != email {
+            return Submitter{}, ErrInvalidEmail
+        }
+    }
+    return Submitter{Name: name, Email: email}, nil
+}
+
+// String formats the submitter like a mail address, for audit entries.
+func (s Submitter) String() string {
+    if s.Email == "" {
+        return s.Name
+    }
+    return s.Name + " <" + s.Email + ">"
+}
+
+// Folder is the name of the subfolder the submitter's files go to: their
+// name and email reduced to characters that are safe in file names.
+func (s Submitter) Folder() string {
+    folder := s.Name
+    if s.Email != "" {
+        folder += " (" + s.Email + ")"
+    }
+    folder = strings.Map(func(r rune) rune {
+        switch {
+        case unicode.IsLetter(r), unicode.IsDigit(r), strings.ContainsRune(" ()@.+_-", r):
+            return r
+        case unicode.IsSpace(r):
+            return ' '
+        }
+        return -1
+    }, folder)
+    if runes := []rune(folder); len(runes) > maxSubmitterFolder {
+        folder = string(runes[:maxSubmitterFolder])
+    }
+    // Leading dots would hide the folder or name the internal one.
+    folder = strings.TrimSpace(strings.TrimLeft(folder, "."))
+    if folder == "" {
+        folder = "submitter"
+    }
+    return folder
+}
+
+// RequestPolicy is the upload policy of a file request: the system policy
+// with its size limit lowered to maxBytes, if that is smaller.
+func (fs *FileService) RequestPolicy(maxBytes int64) (UploadPolicy, error) {
+    policy, err := fs.UploadPolicy()
+    if err != nil {
+        return UploadPolicy{}, err
+    }
+    if maxBytes > 0 && (policy.MaxBytes == 0 || maxBytes < policy.MaxBytes) {
+        policy.MaxBytes = maxBytes
+    }
+    return policy, nil
+}
+
+// SubmitFile stores a file sent through a file request in the submitter's
+// subfolder of the folder at p, creating it as needed. Uploads go through
+// the same checks as UploadFile, with the size limit lowered to maxBytes,
+// and never replace an earlier submission of the same name.
+func (fs *FileService) SubmitFile(p string, submitter Submitter, filename, owner string, file io.Reader, maxBytes int64) (*models.File, error) {
+    policy, err := fs.RequestPolicy(maxBytes)
+    if err != nil {
+        return nil, err
+    }
+    dir, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    folder, err := dir.child(submitter.Folder())
+    if err != nil {
+        return nil, err
+    }
+    created := false
+    if err := os.Mkdir(folder.abs, os.ModePerm); err == nil {
+        created = true
+        fs.usage.created(folder, owner)
+    } else if !os.IsExist(err) {
+        return nil, err
+    } else if info, err := os.Lstat(folder.abs); err != nil || !info.IsDir() {
+        return nil, ErrDestinationExists
+    }
+
+    uploaded, err := fs.uploadFile(folder.Virtual(), filename, owner, file, nil, policy, false)
+    if err != nil && created {
+        // Rejected first submissions leave no empty folder behind.
+        if entries, err := os.ReadDir(folder.abs); err == nil && len(entries) == 0 {
+            fs.usage.removed(folder)
+            os.Remove(folder.abs)
+        }
+    }
+    return uploaded, err
+}
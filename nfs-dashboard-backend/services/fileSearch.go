@@ -0,0 +1,231 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+const (
+    defaultSearchLimit = 100
+    maxSearchLimit     = 1000
+)
+
+// SearchOptions describes a recursive search below Path. Empty fields do not filter.
+type SearchOptions struct {
+    Path           string
+    Query          string   // case-insensitive name substring
+    Glob           string   // shell pattern matched against the name
+    Regex          string   // regular expression matched against the name
+    Extensions     []string // e.g. ".pdf"; matched case-insensitively
+    MinSize        int64
+    MaxSize        int64 // 0 means unbounded
+    ModifiedAfter  time.Time
+    ModifiedBefore time.Time
+    Offset         int
+    Limit          int
+
+    // Scope, when set, hides entries the caller may not read and skips
+    // folders that lead to nothing readable.
+    Scope *AccessScope
+}
+
+// SearchResult is one page of search matches.
+type SearchResult struct {
+    Files   []models.File `json:"files"`
+    Offset  int           `json:"offset"`
+    Limit   int           `json:"limit"`
+    HasMore bool          `json:"hasMore"`
+}
+
+// errSearchDone stops the walk once a page is complete.
+var errSearchDone = errors.New("search complete")
+
+// Search walks the subtree below opts.Path and returns the matching entries.
+// Searching "/" covers every share. The walk stops early once the requested
+// page is filled or ctx is cancelled.
+func (fs *FileService) Search(ctx context.Context, opts SearchOptions) (*SearchResult, error) {
+    bases, err := fs.searchBases(opts.Path)
+    if err != nil {
+        return nil, err
+    }
+    match, err := newSearchMatcher(opts)
+    if err != nil {
+        return nil, err
+    }
+
+    limit := opts.Limit
+    if limit <= 0 {
+        limit = defaultSearchLimit
+    }
+    if limit > maxSearchLimit {
+        limit = maxSearchLimit
+    }
+    if opts.Offset < 0 {
+        opts.Offset = 0
+    }
+
+    result := &SearchResult{Files: []models.File{}, Offset: opts.Offset, Limit: limit}
+    skipped := 0
+    for _, base := range bases {
+        err = filepath.WalkDir(base.abs, func(p string, d os.DirEntry, walkErr error) error {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            if p == base.abs {
+                return walkErr
+            }
+            if walkErr != nil {
+                // Unreadable folders or entries removed mid-walk are skipped.
+                if d != nil && d.IsDir() {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+
+            rel, err := filepath.Rel(base.root.Path, p)
+            if err != nil {
+                return nil
+            }
+            entry := &resolvedPath{root: base.root, rel: filepath.ToSlash(rel), abs: p}
+            if isInternal(entry.rel) {
+                return filepath.SkipDir
+            }
+            virtual := entry.Virtual()
+            if d.IsDir() && opts.Scope != nil && !opts.Scope.CanTraverse(virtual) {
+                return filepath.SkipDir
+            }
+            if opts.Scope != nil && !opts.Scope.Can(ActionRead, virtual) {
+                return nil
+            }
+            if d.Type()&os.ModeSymlink != 0 && opts.Scope != nil && !opts.Scope.Can(ActionRead, entry.realVirtual()) {
+                return nil
+            }
+
+            info, err := entryInfo(entry, d)
+            if err != nil || !match(d.Name(), info) {
+                return nil
+            }
+            if skipped < opts.Offset {
+                skipped++
+                return nil
+            }
+            if len(result.Files) == limit {
+                result.HasMore = true
+                return errSearchDone
+            }
+            result.Files = append(result.Files, fileFromInfo(entry, info))
+            return nil
+        })
+        if err == errSearchDone {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    return result, nil
+}
+
+// searchBases resolves the folders a search starts from.
+func (fs *FileService) searchBases(p string) ([]*resolvedPath, error) {
+    if clean, err := cleanVirtualPath(p); err == nil && clean == "/" {
+        var bases []*resolvedPath
+        for i := range fs.roots {
+            if _, err := os.Stat(fs.roots[i].Path); err != nil {
+                continue
+            }
+            bases = append(bases, &resolvedPath{root: &fs.roots[i], abs: fs.roots[i].Path})
+        }
+        return bases, nil
+    }
+    base, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    info, err := os.Stat(base.abs)
+    if err != nil {
+        return nil, err
+    }
+    if !info.IsDir() {
+        return nil, errors.New("provided path is not a directory")
+    }
+    return []*resolvedPath{base}, nil
+}
+
+// entryInfo stats a walked entry, following symlinks that stay inside the share.
+func entryInfo(entry *resolvedPath, d os.DirEntry) (os.FileInfo, error) {
+    if d.Type()&os.ModeSymlink == 0 {
+        return d.Info()
+    }
+    if err := entry.checkContained(); err != nil {
+        return nil, err
+    }
+    return os.Stat(entry.abs)
+}
+
+// newSearchMatcher compiles the name, size and date filters of opts.
+func newSearchMatcher(opts SearchOptions) (func(name string, info os.FileInfo) bool, error) {
+    query := strings.ToLower(opts.Query)
+    glob := strings.ToLower(opts.Glob)
+    if glob != "" {
+        if _, err := path.Match(glob, ""); err != nil {
+            return nil, ErrInvalidPattern
+        }
+    }
+    var re *regexp.Regexp
+    if opts.Regex != "" {
+        var err error
+        if re, err = regexp.Compile(opts.Regex); err != nil {
+            return nil, errors.New("invalid regular expression")
+        }
+    }
+    exts := make(map[string]bool)
+    for _, ext := range opts.Extensions {
+        ext = strings.ToLower(strings.TrimSpace(ext))
+        if ext == "" {
+            continue
+        }
+        if !strings.HasPrefix(ext, ".") {
+            ext = "." + ext
+        }
+        exts[ext] = true
+    }
+    sizeFiltered := opts.MinSize > 0 || opts.MaxSize > 0
+
+    return func(name string, info os.FileInfo) bool {
+        lower := strings.ToLower(name)
+        if query != "" && !strings.Contains(lower, query) {
+            return false
+        }
+        if glob != "" {
+            if ok, _ := path.Match(glob, lower); !ok {
+                return false
+            }
+        }
+        if re != nil && !re.MatchString(name) {
+            return false
+        }
+        if len(exts) > 0 && (info.IsDir() || !exts[strings.ToLower(filepath.Ext(name))]) {
+            return false
+        }
+        if sizeFiltered {
+            if info.IsDir() || info.Size() < opts.MinSize || (opts.MaxSize > 0 && info.Size() > opts.MaxSize) {
+                return false
+            }
+        }
+        if !opts.ModifiedAfter.IsZero() && info.ModTime().Before(opts.ModifiedAfter) {
+            return false
+        }
+        if !opts.ModifiedBefore.IsZero() && info.ModTime().After(opts.ModifiedBefore) {
+            return false
+        }
+        return true
+    }, nil
+}
@@ -1,133 +1,563 @@
 package services
 
 import (
+    "bytes"
+    "encoding/hex"
     "errors"
+    "hash"
     "io"
     "os"
+    "path"
     "path/filepath"
+    "strings"
+    "sync"
     "nfs-dashboard-backend/models"
 )
 
 // FileService provides methods for file operations.
-type FileService struct{}
+// All paths are client-facing paths of the form "/<share>/<path>" and are
+// confined to the configured share roots.
+type FileService struct {
+    roots     []ShareRoot
+    settings  SettingsProvider
+    audit     AuditRecorder
+    usage     *usageLedger
+    archives  *archiveIndexCache
+    checksums *checksumCache
+    locks     *lockTable
+    users     UserLookup
+    contentMu sync.Mutex // serializes text edits
+}
 
-// NewFileService creates a new instance of FileService.
-func NewFileService() *FileService {
-    return &FileService{}
+// NewFileService creates a new instance of FileService serving the given share
+// roots. Upload limits and quotas are read from settings on every upload;
+// administrative changes to files are recorded with audit. Locks bind every
+// user but the admins found in users.
+func NewFileService(roots []ShareRoot, settings SettingsProvider, audit AuditRecorder, users UserLookup) *FileService {
+    return &FileService{roots: roots, settings: settings, audit: audit, usage: newUsageLedger(),
+        archives: newArchiveIndexCache(), checksums: newChecksumCache(), locks: newLockTable(), users: users}
 }
 
-// ListFiles lists all files and folders in a directory.
-func (fs *FileService) ListFiles(path string) ([]models.File, error) {
-    var files []models.File
+// UploadPolicy returns the upload limits currently in effect.
+func (fs *FileService) UploadPolicy() (UploadPolicy, error) {
+    return CurrentUploadPolicy(fs.settings)
+}
+
+// ContentTypes returns the content-type detector currently in effect.
+func (fs *FileService) ContentTypes() ContentTypeDetector {
+    return CurrentContentTypes(fs.settings)
+}
+
+// Roots returns the configured share roots.
+func (fs *FileService) Roots() []ShareRoot {
+    return fs.roots
+}
 
-    info, err := os.Stat(path)
+// resolve maps a client path onto the filesystem, rejecting anything that
+// escapes its share root. Paths inside archives are rejected too; only the
+// read operations that support them handle them before resolving.
+func (fs *FileService) resolve(p string) (*resolvedPath, error) {
+    clean, err := cleanVirtualPath(p)
     if err != nil {
-        if os.IsNotExist(err) {
-            return nil, errors.New("directory does not exist")
-        }
         return nil, err
     }
-    if !info.IsDir() {
-        return nil, errors.New("provided path is not a directory")
+    if clean == "/" {
+        return nil, ErrPathOutsideRoot
+    }
+    if _, _, ok := fs.archivePath(clean); ok {
+        return nil, ErrArchiveReadOnly
+    }
+    parts := strings.SplitN(strings.TrimPrefix(clean, "/"), "/", 2)
+    var root *ShareRoot
+    for i := range fs.roots {
+        if fs.roots[i].Name == parts[0] {
+            root = &fs.roots[i]
+            break
+        }
+    }
+    if root == nil {
+        return nil, ErrShareNotFound
     }
+    resolved := &resolvedPath{root: root, abs: root.Path}
+    if len(parts) == 2 {
+        if isInternal(parts[1]) {
+            return nil, ErrPathOutsideRoot
+        }
+        resolved.rel = parts[1]
+        resolved.abs = filepath.Join(root.Path, filepath.FromSlash(parts[1]))
+    }
+    if err := resolved.checkContained(); err != nil {
+        return nil, err
+    }
+    return resolved, nil
+}
 
-    items, err := os.ReadDir(path)
+// RealPath returns the client path of where p leads once symlinks are
+// followed, so that permissions can be checked on both. For a path inside an
+// archive it is that of the archive. Paths that do not resolve are returned
+// unchanged, for the operation itself to reject.
+func (fs *FileService) RealPath(p string) string {
+    if clean, err := cleanVirtualPath(p); err == nil {
+        if archive, _, ok := fs.archivePath(clean); ok {
+            p = archive
+        }
+    }
+    item, err := fs.resolve(p)
     if err != nil {
-        return nil, err
+        return p
+    }
+    return item.realVirtual()
+}
+
+// fileFromInfo builds the API representation of a resolved path.
+func fileFromInfo(p *resolvedPath, info os.FileInfo) models.File {
+    name := info.Name()
+    if p.IsRoot() {
+        name = p.root.Name
+    }
+    return models.File{
+        Name:         name,
+        Path:         p.Virtual(),
+        IsDir:        info.IsDir(),
+        Size:         info.Size(),
+        LastModified: info.ModTime(),
+        IsArchive:    !info.IsDir() && archiveKind(name) != "",
     }
+}
 
-    for _, item := range items {
-        fileInfo, err := item.Info()
+// listRoots returns the share roots as top-level folders.
+func (fs *FileService) listRoots() ([]models.File, error) {
+    files := []models.File{}
+    for i := range fs.roots {
+        root := &fs.roots[i]
+        info, err := os.Stat(root.Path)
         if err != nil {
-            return nil, err
+            continue
         }
-        files = append(files, models.File{
-            Name:         item.Name(),
-            Path:         filepath.Join(path, item.Name()),
-            IsDir:        fileInfo.IsDir(),
-            Size:         fileInfo.Size(),
-            LastModified: fileInfo.ModTime(),
-        })
+        files = append(files, fileFromInfo(&resolvedPath{root: root, abs: root.Path}, info))
     }
     return files, nil
 }
 
-// CreateFolder creates a new folder at the specified path.
-func (fs *FileService) CreateFolder(path, name string) (*models.File, error) {
-    folderPath := filepath.Join(path, name)
-    if _, err := os.Stat(folderPath); !os.IsNotExist(err) {
+// ListFiles lists all files and folders in a directory.
+// Listing "/" returns the configured share roots, and listing
+// "<archive>!/<folder>" a folder inside a zip or tar archive.
+func (fs *FileService) ListFiles(p string) ([]models.File, error) {
+    page, err := fs.ListDirectory(p, ListOptions{})
+    if err != nil {
+        return nil, err
+    }
+    return page.Files, nil
+}
+
+// CreateFolder creates a new folder at the specified path, owned by owner.
+func (fs *FileService) CreateFolder(p, name, owner string) (*models.File, error) {
+    parent, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    folder, err := parent.child(name)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := os.Lstat(folder.abs); !os.IsNotExist(err) {
         return nil, errors.New("folder already exists")
     }
-    if err := os.Mkdir(folderPath, os.ModePerm); err != nil {
+    if err := fs.checkUnlocked(folder, owner); err != nil {
+        return nil, err
+    }
+    if err := os.Mkdir(folder.abs, os.ModePerm); err != nil {
         return nil, err
     }
-    info, err := os.Stat(folderPath)
+    fs.usage.created(folder, owner)
+    info, err := os.Stat(folder.abs)
     if err != nil {
         return nil, err
     }
-    return &models.File{
-        Name:         name,
-        Path:         folderPath,
-        IsDir:        true,
-        Size:         info.Size(),
-        LastModified: info.ModTime(),
-    }, nil
+    created := fileFromInfo(folder, info)
+    return &created, nil
 }
 
-// UploadFile saves an uploaded file to the specified directory.
-func (fs *FileService) UploadFile(path, filename string, file io.Reader) (*models.File, error) {
-    if _, err := os.Stat(path); os.IsNotExist(err) {
+// UploadFile streams an uploaded file into the specified directory, enforcing
+// the size and type limits of the live system settings. Data is written to a
+// temporary file first so a rejected upload never replaces an existing file.
+// The file is attributed to owner and counts against their storage quota.
+// With an expected checksum, content that does not match is discarded.
+func (fs *FileService) UploadFile(p, filename, owner string, file io.Reader, expected *ExpectedChecksum) (*models.File, error) {
+    policy, err := fs.UploadPolicy()
+    if err != nil {
+        return nil, err
+    }
+    return fs.uploadFile(p, filename, owner, file, expected, policy, true)
+}
+
+// ReceiveFile is UploadFile for uploads through share links: it never
+// replaces an existing file, storing the upload as "name (n).ext" instead.
+func (fs *FileService) ReceiveFile(p, filename, owner string, file io.Reader) (*models.File, error) {
+    policy, err := fs.UploadPolicy()
+    if err != nil {
+        return nil, err
+    }
+    return fs.uploadFile(p, filename, owner, file, nil, policy, false)
+}
+
+// uploadFile is UploadFile under the given policy. Unless replace is set, a
+// taken name is not overwritten but gets a numbered suffix.
+func (fs *FileService) uploadFile(p, filename, owner string, file io.Reader, expected *ExpectedChecksum, policy UploadPolicy, replace bool) (*models.File, error) {
+    dir, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    if _, err := os.Stat(dir.abs); os.IsNotExist(err) {
         return nil, errors.New("target directory does not exist")
     }
-    destPath := filepath.Join(path, filename)
-    out, err := os.Create(destPath)
+    dest, err := dir.child(UploadName(filename))
     if err != nil {
         return nil, err
     }
-    defer out.Close()
+    if err := policy.CheckName(dest.abs); err != nil {
+        return nil, err
+    }
 
-    if _, err := io.Copy(out, file); err != nil {
+    var h hash.Hash
+    if expected != nil {
+        h = checksumAlgorithms[expected.Algorithm]()
+        file = io.TeeReader(file, h)
+    }
+    write := func(tmp string, quotaLeft int64) error {
+        if err := writeUpload(tmp, filepath.Base(dest.abs), file, policy, quotaLeft); err != nil {
+            return err
+        }
+        if h != nil && hex.EncodeToString(h.Sum(nil)) != expected.Value {
+            return ErrChecksumMismatch
+        }
+        return nil
+    }
+    var uploaded *models.File
+    if replace {
+        uploaded, err = fs.storeFile(dest, owner, false, write)
+    } else {
+        uploaded, err = fs.storeNewFile(dir, filepath.Base(dest.abs), owner, write)
+    }
+    if err != nil {
         return nil, err
     }
+    // The verified checksum is known without reading the file again.
+    if expected != nil {
+        stored := filepath.Join(dir.abs, uploaded.Name)
+        if info, err := os.Stat(stored); err == nil {
+            fs.checksums.put(checksumKey(stored, info, expected.Algorithm), expected.Value)
+        }
+    }
+    return uploaded, nil
+}
+
+// UploadName is the name an uploaded file is stored under: the last
+// element of the name the client sent.
+func UploadName(filename string) string {
+    return path.Base(strings.ReplaceAll(filename, "\\", "/"))
+}
 
-    info, err := os.Stat(destPath)
+// storeFile creates dest through write, which is given a temporary sibling
+// path and the bytes owner may still store, then renames it into place and
+// updates the owner's usage. Replacing one's own file frees its old size, and
+// the replaced content is kept as a version, even with versioning off when
+// backup is set. Locks are checked before the write and again before the
+// rename, as writes can take long.
+func (fs *FileService) storeFile(dest *resolvedPath, owner string, backup bool, write func(tmp string, quotaLeft int64) error) (*models.File, error) {
+    if err := fs.checkUnlocked(dest, owner); err != nil {
+        return nil, err
+    }
+    quotaLeft, err := fs.quotaLeft(owner)
     if err != nil {
         return nil, err
     }
+    var replaced int64
+    previous := ""
+    existing, err := os.Lstat(dest.abs)
+    existed := err == nil && existing.Mode().IsRegular()
+    if existed {
+        replaced = existing.Size()
+        fs.usage.mu.Lock()
+        previous = fs.usage.ownerOf(dest.root, dest.rel)
+        fs.usage.mu.Unlock()
+        if previous == owner && quotaLeft != unlimitedQuota {
+            quotaLeft += replaced
+        }
+    }
 
-    return &models.File{
-        Name:         filename,
-        Path:         destPath,
-        IsDir:        false,
-        Size:         info.Size(),
-        LastModified: info.ModTime(),
-    }, nil
+    tmp := tempSibling(dest.abs, "upload")
+    if err := write(tmp, quotaLeft); err != nil {
+        os.Remove(tmp)
+        return nil, err
+    }
+    if err := fs.checkUnlocked(dest, owner); err != nil {
+        os.Remove(tmp)
+        return nil, err
+    }
+    if existed {
+        keep := fs.keepVersion
+        if backup {
+            keep = fs.keepBackup
+        }
+        if err := keep(dest, existing, owner); err != nil {
+            os.Remove(tmp)
+            return nil, err
+        }
+    }
+    if err := os.Rename(tmp, dest.abs); err != nil {
+        os.Remove(tmp)
+        return nil, err
+    }
+    if existed {
+        fs.locks.replaced(dest)
+    }
+
+    info, err := os.Stat(dest.abs)
+    if err != nil {
+        return nil, err
+    }
+    fs.usage.stored(dest, owner, info.Size(), previous, replaced, existed)
+
+    uploaded := fileFromInfo(dest, info)
+    return &uploaded, nil
 }
 
-// RenameItem renames a file or folder.
-func (fs *FileService) RenameItem(path, newName string) (*models.File, error) {
-    dir := filepath.Dir(path)
-    newPath := filepath.Join(dir, newName)
-    if err := os.Rename(path, newPath); err != nil {
+// storeNewFile is storeFile for a file that must not replace anything. It is
+// linked into place rather than renamed, so a name taken meanwhile is never
+// overwritten but moves it on to the next free "name (n).ext".
+func (fs *FileService) storeNewFile(dir *resolvedPath, name, owner string, write func(tmp string, quotaLeft int64) error) (*models.File, error) {
+    quotaLeft, err := fs.quotaLeft(owner)
+    if err != nil {
         return nil, err
     }
-    info, err := os.Stat(newPath)
+    dest, err := dir.child(name)
     if err != nil {
         return nil, err
     }
-    return &models.File{
-        Name:         newName,
-        Path:         newPath,
-        IsDir:        info.IsDir(),
-        Size:         info.Size(),
-        LastModified: info.ModTime(),
-    }, nil
+    if err := fs.checkUnlocked(dest, owner); err != nil {
+        return nil, err
+    }
+    tmp := tempSibling(dest.abs, "upload")
+    defer os.Remove(tmp)
+    if err := write(tmp, quotaLeft); err != nil {
+        return nil, err
+    }
+    if err := fs.checkUnlocked(dest, owner); err != nil {
+        return nil, err
+    }
+    for {
+        err := os.Link(tmp, dest.abs)
+        if err == nil {
+            break
+        }
+        if !os.IsExist(err) {
+            return nil, err
+        }
+        if dest, err = freeName(dir, name); err != nil {
+            return nil, err
+        }
+    }
+
+    info, err := os.Stat(dest.abs)
+    if err != nil {
+        return nil, err
+    }
+    fs.usage.stored(dest, owner, info.Size(), "", 0, false)
+
+    uploaded := fileFromInfo(dest, info)
+    return &uploaded, nil
+}
+
+// writeUpload streams r into a new file at dst, checking the sniffed content
+// type of name and stopping as soon as the size limit or quotaLeft is exceeded.
+func writeUpload(dst, name string, r io.Reader, policy UploadPolicy, quotaLeft int64) error {
+    head := make([]byte, sniffLen)
+    n, err := io.ReadFull(r, head)
+    if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+        return err
+    }
+    head = head[:n]
+    if err := policy.CheckContent(name, head); err != nil {
+        return err
+    }
+
+    out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+    if err != nil {
+        return err
+    }
+    body := io.MultiReader(bytes.NewReader(head), r)
+    limit := policy.MaxBytes
+    if quotaLeft != unlimitedQuota && (limit == 0 || quotaLeft < limit) {
+        limit = quotaLeft
+    }
+    if limit > 0 || quotaLeft == 0 {
+        body = io.LimitReader(body, limit+1)
+    }
+    written, err := io.Copy(out, body)
+    if err != nil {
+        out.Close()
+        return err
+    }
+    if err := out.Close(); err != nil {
+        return err
+    }
+    if err := policy.CheckSize(written); err != nil {
+        return err
+    }
+    if quotaLeft != unlimitedQuota && written > quotaLeft {
+        return ErrQuotaExceeded
+    }
+    return nil
+}
+
+// RenameItem renames a file or folder on behalf of user.
+func (fs *FileService) RenameItem(p, newName, user string) (*models.File, error) {
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    if item.IsRoot() {
+        return nil, errors.New("cannot rename a share root")
+    }
+    parent, err := fs.resolve(path.Dir(item.Virtual()))
+    if err != nil {
+        return nil, err
+    }
+    target, err := parent.child(newName)
+    if err != nil {
+        return nil, err
+    }
+    if err := fs.checkRenamedType(item, newName); err != nil {
+        return nil, err
+    }
+    if err := fs.checkUnlocked(item, user); err != nil {
+        return nil, err
+    }
+    if err := fs.checkUnlocked(target, user); err != nil {
+        return nil, err
+    }
+    // Renaming never replaces anything; a case-only rename finds the item
+    // itself on case-insensitive file systems.
+    if existing, err := os.Lstat(target.abs); err == nil {
+        if current, err := os.Lstat(item.abs); err != nil || !os.SameFile(current, existing) {
+            return nil, ErrDestinationExists
+        }
+    }
+    if err := os.Rename(item.abs, target.abs); err != nil {
+        return nil, err
+    }
+    fs.usage.moved(item, target)
+    fs.locks.moved(item, target)
+    info, err := os.Stat(target.abs)
+    if err != nil {
+        return nil, err
+    }
+    renamed := fileFromInfo(target, info)
+    return &renamed, nil
 }
 
-// DeleteItem deletes a file or folder at the specified path.
-func (fs *FileService) DeleteItem(path string) error {
-    if _, err := os.Stat(path); os.IsNotExist(err) {
-        return errors.New("item does not exist")
+// checkRenamedType applies the allowed file types to a rename that changes
+// the extension of a file.
+func (fs *FileService) checkRenamedType(item *resolvedPath, newName string) error {
+    if strings.EqualFold(filepath.Ext(item.abs), filepath.Ext(newName)) {
+        return nil
+    }
+    info, err := os.Stat(item.abs)
+    if err != nil || info.IsDir() {
+        return err
+    }
+    policy, err := fs.UploadPolicy()
+    if err != nil {
+        return err
+    }
+    if err := policy.CheckName(newName); err != nil {
+        return err
+    }
+    f, err := os.Open(item.abs)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    head := make([]byte, sniffLen)
+    n, _ := io.ReadFull(f, head)
+    return policy.CheckContent(newName, head[:n])
+}
+
+// GetFileInfo returns metadata for a single file or folder, which may be
+// inside an archive. The content type of files is detected from their
+// content as well as their name.
+func (fs *FileService) GetFileInfo(p string) (*models.File, error) {
+    file, err := fs.StatFile(p)
+    if err != nil {
+        return nil, err
+    }
+    // Archive members have no POSIX metadata or locks of their own.
+    if item, err := fs.resolve(p); err == nil {
+        if file.Posix, err = posixInfo(item); err != nil {
+            return nil, err
+        }
+        file.Lock = fs.locks.lockOf(item)
+    }
+    if file.IsDir {
+        return file, nil
+    }
+    content, _, err := fs.OpenFile(p)
+    if err != nil {
+        return nil, err
     }
-    return os.RemoveAll(path)
-}
\ No newline at end of file
+    defer content.Close()
+    if file.ContentType, _, err = fs.ContentTypes().Sniff(file.Name, content); err != nil {
+        return nil, err
+    }
+    return file, nil
+}
+
+// StatFile returns metadata for a file or folder, which may be inside an
+// archive, without reading it or looking up its POSIX metadata.
+func (fs *FileService) StatFile(p string) (*models.File, error) {
+    if clean, err := cleanVirtualPath(p); err == nil {
+        if archive, member, ok := fs.archivePath(clean); ok {
+            return fs.archiveFileInfo(archive, member)
+        }
+    }
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    info, err := os.Stat(item.abs)
+    if err != nil {
+        return nil, err
+    }
+    file := fileFromInfo(item, info)
+    return &file, nil
+}
+
+// OpenFile opens a regular file, or a file inside an archive, for reading.
+// The content implements io.Seeker unless it is decompressed on the fly.
+// The caller must close it.
+func (fs *FileService) OpenFile(p string) (io.ReadCloser, os.FileInfo, error) {
+    if clean, err := cleanVirtualPath(p); err == nil {
+        if archive, member, ok := fs.archivePath(clean); ok {
+            return fs.openArchiveMember(archive, member)
+        }
+    }
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, nil, err
+    }
+    file, err := os.Open(item.abs)
+    if err != nil {
+        return nil, nil, err
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, nil, err
+    }
+    if info.IsDir() {
+        file.Close()
+        return nil, nil, errors.New("provided path is a directory")
+    }
+    return file, info, nil
+}
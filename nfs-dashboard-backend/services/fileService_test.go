@@ -0,0 +1,86 @@
+package services
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+// testSettings serves fixed system settings.
+type testSettings models.SystemSettings
+
+func (s *testSettings) GetSystemSettings() (*models.SystemSettings, error) {
+    settings := models.SystemSettings(*s)
+    return &settings, nil
+}
+
+// newTestFiles returns a FileService over the newTestShare fixture.
+func newTestFiles(t *testing.T, settings models.SystemSettings) *FileService {
+    share := newTestShare(t)
+    ts := testSettings(settings)
+    return NewFileService(share.roots, &ts, nil, nil)
+}
+
+// diskPath returns where a path inside the "data" share lives on disk.
+func diskPath(fs *FileService, rel string) string {
+    return filepath.Join(fs.roots[0].Path, filepath.FromSlash(rel))
+}
+
+func writeTestFile(t *testing.T, fs *FileService, rel, content string) {
+    if err := os.MkdirAll(filepath.Dir(diskPath(fs, rel)), 0755); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(diskPath(fs, rel), []byte(content), 0644); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func readTestFile(t *testing.T, fs *FileService, rel string) string {
+    data, err := os.ReadFile(diskPath(fs, rel))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(data)
+}
+
+func TestRenameItem(t *testing.T) {
+    tests := []struct {
+        name    string
+        from    string
+        newName string
+        err     error
+    }{
+        {"new name", "docs/readme.txt", "notes.txt", nil},
+        {"same name", "docs/readme.txt", "readme.txt", nil},
+        {"onto existing file", "docs/readme.txt", "other.txt", ErrDestinationExists},
+        {"onto empty folder", "docs/readme.txt", "empty", ErrDestinationExists},
+        {"folder onto existing file", "docs/empty", "other.txt", ErrDestinationExists},
+        {"onto symlink", "docs/readme.txt", "alias.txt", ErrDestinationExists},
+        {"invalid name", "docs/readme.txt", "../readme.txt", ErrInvalidName},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fs := newTestFiles(t, models.SystemSettings{})
+            writeTestFile(t, fs, "docs/other.txt", "other\n")
+            if err := os.Mkdir(diskPath(fs, "docs/empty"), 0755); err != nil {
+                t.Fatal(err)
+            }
+            if err := os.Symlink("other.txt", diskPath(fs, "docs/alias.txt")); err != nil {
+                t.Fatal(err)
+            }
+            _, err := fs.RenameItem("/data/"+tt.from, tt.newName, "2")
+            if err != tt.err {
+                t.Fatalf("RenameItem(%q, %q) error = %v, want %v", tt.from, tt.newName, err, tt.err)
+            }
+            if err != nil {
+                if got := readTestFile(t, fs, "docs/other.txt"); got != "other\n" {
+                    t.Errorf("existing file changed to %q", got)
+                }
+                if _, err := os.Lstat(diskPath(fs, tt.from)); err != nil {
+                    t.Errorf("refused rename moved the item: %v", err)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+// ConflictPolicy decides what happens when a destination already exists.
+type ConflictPolicy string
+
+const (
+    ConflictFail      ConflictPolicy = "fail"
+    ConflictOverwrite ConflictPolicy = "overwrite"
+    ConflictRename    ConflictPolicy = "rename"
+)
+
+// ErrDestinationExists is returned for ConflictFail when the target already exists.
+var ErrDestinationExists = errors.New("destination already exists")
+
+// maxRenameAttempts bounds the search for a free "name (n).ext" under ConflictRename.
+const maxRenameAttempts = 1000
+
+// ParseConflictPolicy parses a policy name, defaulting to ConflictFail.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+    switch ConflictPolicy(s) {
+    case "", ConflictFail:
+        return ConflictFail, nil
+    case ConflictOverwrite, ConflictRename:
+        return ConflictPolicy(s), nil
+    }
+    return "", fmt.Errorf("unknown conflict policy %q", s)
+}
+
+// CopyItem recursively copies a file or folder into destDir, preserving
+// modes and modification times. The copy is attributed to user and counts
+// against their storage quota. A file overwritten is kept as a version, or
+// goes to the trash when it cannot be versioned.
+func (fs *FileService) CopyItem(src, destDir string, policy ConflictPolicy, user string) (*models.File, error) {
+    source, target, err := fs.prepareTransfer(src, destDir, policy)
+    if err != nil {
+        return nil, err
+    }
+    if err := fs.checkCopyQuota(source, target, policy == ConflictOverwrite, user); err != nil {
+        return nil, err
+    }
+    err = fs.placeAt(target, policy == ConflictOverwrite, user, func(dst string) error {
+        return copyViaTemp(source.abs, dst)
+    })
+    if err != nil {
+        return nil, err
+    }
+    fs.usage.copied(target, user)
+    return fs.GetFileInfo(target.Virtual())
+}
+
+// checkCopyQuota reports ErrQuotaExceeded if a copy of source at target
+// would not fit in the quota of user, who the copy is attributed to. An
+// overwritten item of theirs frees its size.
+func (fs *FileService) checkCopyQuota(source, target *resolvedPath, overwrite bool, user string) error {
+    fs.usage.mu.Lock()
+    replacedOwner := fs.usage.ownerOf(target.root, target.rel)
+    fs.usage.mu.Unlock()
+
+    size := treeSize(source.abs)
+    if overwrite && replacedOwner == user {
+        if _, err := os.Lstat(target.abs); err == nil {
+            size -= treeSize(target.abs)
+        }
+    }
+    return fs.CheckQuota(user, size)
+}
+
+// MoveItem moves a file or folder into destDir. Moves across filesystems,
+// which os.Rename rejects with EXDEV, fall back to copy and delete. A file
+// overwritten is kept as a version, or goes to the trash when it cannot be
+// versioned.
+func (fs *FileService) MoveItem(src, destDir string, policy ConflictPolicy, user string) (*models.File, error) {
+    source, target, err := fs.prepareTransfer(src, destDir, policy)
+    if err != nil {
+        return nil, err
+    }
+    if err := fs.checkUnlocked(source, user); err != nil {
+        return nil, err
+    }
+    err = fs.placeAt(target, policy == ConflictOverwrite, user, func(dst string) error {
+        return renameOrCopy(source.abs, dst)
+    })
+    if err != nil {
+        return nil, err
+    }
+    fs.usage.moved(source, target)
+    fs.locks.moved(source, target)
+    return fs.GetFileInfo(target.Virtual())
+}
+
+// prepareTransfer resolves source and target and applies the conflict policy.
+func (fs *FileService) prepareTransfer(src, destDir string, policy ConflictPolicy) (*resolvedPath, *resolvedPath, error) {
+    source, err := fs.resolve(src)
+    if err != nil {
+        return nil, nil, err
+    }
+    if source.IsRoot() {
+        return nil, nil, errors.New("cannot copy or move a share root")
+    }
+    if _, err := os.Lstat(source.abs); err != nil {
+        return nil, nil, err
+    }
+    dir, err := fs.resolve(destDir)
+    if err != nil {
+        return nil, nil, err
+    }
+    info, err := os.Stat(dir.abs)
+    if err != nil {
+        return nil, nil, err
+    }
+    if !info.IsDir() {
+        return nil, nil, errors.New("destination is not a directory")
+    }
+    // Symlinked folders are followed on both sides so neither can hide that
+    // the destination lies inside the source.
+    dirReal, err := filepath.EvalSymlinks(dir.abs)
+    if err != nil {
+        return nil, nil, err
+    }
+    if isWithin(filepath.Join(source.root.Path, filepath.FromSlash(source.realRel())), dirReal) {
+        return nil, nil, errors.New("cannot copy or move a folder into itself")
+    }
+
+    target, err := dir.child(filepath.Base(source.abs))
+    if err != nil {
+        return nil, nil, err
+    }
+    if target.root == source.root && target.realRel() == source.realRel() {
+        if policy != ConflictRename {
+            return nil, nil, ErrDestinationExists
+        }
+    } else if _, err := os.Lstat(target.abs); err != nil {
+        return source, target, nil
+    }
+
+    switch policy {
+    case ConflictOverwrite:
+        return source, target, nil
+    case ConflictRename:
+        target, err = freeName(dir, filepath.Base(source.abs))
+        return source, target, err
+    }
+    return nil, nil, ErrDestinationExists
+}
+
+// freeName finds an unused "name (n).ext" inside dir.
+func freeName(dir *resolvedPath, name string) (*resolvedPath, error) {
+    ext := filepath.Ext(name)
+    stem := strings.TrimSuffix(name, ext)
+    for i := 1; i <= maxRenameAttempts; i++ {
+        candidate, err := dir.child(fmt.Sprintf("%s (%d)%s", stem, i, ext))
+        if err != nil {
+            return nil, err
+        }
+        if _, err := os.Lstat(candidate.abs); os.IsNotExist(err) {
+            return candidate, nil
+        }
+    }
+    return nil, ErrDestinationExists
+}
+
+// tempSibling returns an unused hidden path next to p.
+func tempSibling(p, tag string) string {
+    return filepath.Join(filepath.Dir(p), fmt.Sprintf(".%s.%d.%s", filepath.Base(p), time.Now().UnixNano(), tag))
+}
+
+// placeAt runs write to create dst unless others hold locks on it. When
+// overwrite is set and dst exists, a regular file is kept as a version, as
+// uploads keep them, and anything that cannot be versioned goes to the trash,
+// as deleted by user. Either way it is put back if write fails, and its locks
+// go once it is replaced.
+func (fs *FileService) placeAt(dst *resolvedPath, overwrite bool, user string, write func(dst string) error) error {
+    if err := fs.checkUnlocked(dst, user); err != nil {
+        return err
+    }
+    info, err := os.Lstat(dst.abs)
+    if err != nil || !overwrite {
+        return write(dst.abs)
+    }
+    if info.Mode().IsRegular() && fs.versionSettings().MaxVersions > 0 {
+        return fs.replaceVersioned(dst, info, user, write)
+    }
+    record, err := fs.trashItem(dst, info, user)
+    if err != nil {
+        return err
+    }
+    if err := write(dst.abs); err != nil {
+        if err := fs.untrash(dst, record); err != nil {
+            log.Printf("Failed to put back %s after a failed overwrite, it stays in the trash: %v", dst.Virtual(), err)
+        }
+        return err
+    }
+    fs.locks.removed(dst)
+    return nil
+}
+
+// replaceVersioned is placeAt for a regular file that is kept as a version.
+// The file is set aside rather than removed until write succeeds.
+func (fs *FileService) replaceVersioned(dst *resolvedPath, info os.FileInfo, user string, write func(dst string) error) error {
+    if err := fs.keepVersion(dst, info, user); err != nil {
+        return err
+    }
+    owners := fs.usage.removed(dst)
+    aside := tempSibling(dst.abs, "replaced")
+    if err := os.Rename(dst.abs, aside); err != nil {
+        fs.usage.restored(dst, owners)
+        return err
+    }
+    if err := write(dst.abs); err != nil {
+        if err := os.Rename(aside, dst.abs); err != nil {
+            log.Printf("Failed to put back %s after a failed overwrite, it is kept as a version: %v", dst.Virtual(), err)
+            os.Remove(aside)
+        }
+        fs.usage.restored(dst, owners)
+        return err
+    }
+    os.Remove(aside)
+    fs.locks.removed(dst)
+    return nil
+}
+
+// renameOrCopy renames src to dst, falling back to copy and delete when
+// they live on different filesystems.
+func renameOrCopy(src, dst string) error {
+    err := os.Rename(src, dst)
+    if err == nil || !errors.Is(err, syscall.EXDEV) {
+        return err
+    }
+    if err := copyViaTemp(src, dst); err != nil {
+        return err
+    }
+    return os.RemoveAll(src)
+}
+
+// copyViaTemp copies src next to dst under a temporary name and renames it
+// into place, so a failed copy never leaves a partial dst behind.
+func copyViaTemp(src, dst string) error {
+    tmp := tempSibling(dst, "partial")
+    if err := copyTree(src, tmp); err != nil {
+        os.RemoveAll(tmp)
+        return err
+    }
+    if err := os.Rename(tmp, dst); err != nil {
+        os.RemoveAll(tmp)
+        return err
+    }
+    return nil
+}
+
+// copyTree recursively copies src to dst, preserving modes and mtimes.
+// Symlinks are recreated rather than followed.
+func copyTree(src, dst string) error {
+    info, err := os.Lstat(src)
+    if err != nil {
+        return err
+    }
+    switch {
+    case info.Mode()&os.ModeSymlink != 0:
+        target, err := os.Readlink(src)
+        if err != nil {
+            return err
+        }
+        return os.Symlink(target, dst)
+    case info.IsDir():
+        if err := os.Mkdir(dst, 0700); err != nil {
+            return err
+        }
+        entries, err := os.ReadDir(src)
+        if err != nil {
+            return err
+        }
+        for _, entry := range entries {
+            if err := copyTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+                return err
+            }
+        }
+    case info.Mode().IsRegular():
+        if err := copyFileContents(src, dst); err != nil {
+            return err
+        }
+    default:
+        return fmt.Errorf("cannot copy special file %s", info.Name())
+    }
+    if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+        return err
+    }
+    return os.Chtimes(dst, time.Now(), info.ModTime())
+}
+
+// copyFileContents copies the bytes of a regular file.
+func copyFileContents(src, dst string) error {
+    in, err := os.Open(src)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(out, in); err != nil {
+        out.Close()
+        return err
+    }
+    return out.Close()
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestTransferIntoItself(t *testing.T) {
+    tests := []struct {
+        name string
+        src  string
+        dir  string
+    }{
+        {"directly", "/data/docs", "/data/docs/sub"},
+        {"through a symlinked destination", "/data/docs", "/data/into"},
+        {"through a symlinked parent", "/data/link/sub", "/data/into"},
+    }
+    for _, tt := range tests {
+        for _, move := range []bool{false, true} {
+            name := tt.name
+            if move {
+                name += " by move"
+            }
+            t.Run(name, func(t *testing.T) {
+                fs := newTestFiles(t, models.SystemSettings{})
+                if err := os.Mkdir(diskPath(fs, "docs/sub"), 0755); err != nil {
+                    t.Fatal(err)
+                }
+                if err := os.Symlink("docs/sub", diskPath(fs, "into")); err != nil {
+                    t.Fatal(err)
+                }
+                var err error
+                if move {
+                    _, err = fs.MoveItem(tt.src, tt.dir, ConflictFail, "2")
+                } else {
+                    _, err = fs.CopyItem(tt.src, tt.dir, ConflictFail, "2")
+                }
+                if err == nil || !strings.Contains(err.Error(), "into itself") {
+                    t.Fatalf("error = %v, want a refusal to transfer into itself", err)
+                }
+                entries, err := os.ReadDir(diskPath(fs, "docs/sub"))
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if len(entries) != 0 {
+                    t.Errorf("refused transfer left %d entries in the destination", len(entries))
+                }
+            })
+        }
+    }
+}
+
+func TestTransferConflicts(t *testing.T) {
+    tests := []struct {
+        name    string
+        policy  ConflictPolicy
+        err     error
+        target  string
+        content string
+    }{
+        {"fail", ConflictFail, ErrDestinationExists, "drafts/plan.txt", "old\n"},
+        {"rename", ConflictRename, nil, "drafts/plan (1).txt", "new\n"},
+        {"overwrite", ConflictOverwrite, nil, "drafts/plan.txt", "new\n"},
+    }
+    for _, tt := range tests {
+        for _, move := range []bool{false, true} {
+            name := tt.name
+            if move {
+                name += " by move"
+            }
+            t.Run(name, func(t *testing.T) {
+                fs := newTestFiles(t, models.SystemSettings{})
+                writeTestFile(t, fs, "docs/plan.txt", "new\n")
+                writeTestFile(t, fs, "drafts/plan.txt", "old\n")
+                var err error
+                if move {
+                    _, err = fs.MoveItem("/data/docs/plan.txt", "/data/drafts", tt.policy, "2")
+                } else {
+                    _, err = fs.CopyItem("/data/docs/plan.txt", "/data/drafts", tt.policy, "2")
+                }
+                if err != tt.err {
+                    t.Fatalf("error = %v, want %v", err, tt.err)
+                }
+                if got := readTestFile(t, fs, tt.target); got != tt.content {
+                    t.Errorf("%s = %q, want %q", tt.target, got, tt.content)
+                }
+                _, err = os.Lstat(diskPath(fs, "docs/plan.txt"))
+                if kept := err == nil; kept != (!move || tt.err != nil) {
+                    t.Errorf("source kept = %v after the transfer", kept)
+                }
+            })
+        }
+    }
+}
+
+func TestTransferOntoItselfThroughSymlink(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    // /data/link/readme.txt is /data/docs/readme.txt, so the copy lands on
+    // its own source.
+    if _, err := fs.CopyItem("/data/link/readme.txt", "/data/docs", ConflictOverwrite, "2"); err == nil {
+        t.Error("copying a file onto itself succeeded")
+    }
+    if got := readTestFile(t, fs, "docs/readme.txt"); got != "text\n" {
+        t.Errorf("readme.txt = %q after copying it onto itself", got)
+    }
+}
@@ -0,0 +1,385 @@
+package services
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+    "github.com/google/uuid"
+    "nfs-dashboard-backend/models"
+)
+
+// trashDir is the folder inside each share's internal area holding deleted items.
+const trashDir = "trash"
+
+// ErrTrashItemNotFound is returned for unknown trash IDs.
+var ErrTrashItemNotFound = errors.New("trash item not found")
+
+// trashRecord is the metadata stored as <id>.json next to a trashed item.
+// Owners keeps the item's ownership records so a restore reinstates them.
+type trashRecord struct {
+    models.TrashItem
+    Owners map[string]string `json:"owners,omitempty"`
+}
+
+// DeleteItem moves a file or folder into the trash of its share, where it
+// stays restorable until purged.
+func (fs *FileService) DeleteItem(p, deletedBy string) (*models.TrashItem, error) {
+    item, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    if item.IsRoot() {
+        return nil, errors.New("cannot delete a share root")
+    }
+    info, err := os.Lstat(item.abs)
+    if os.IsNotExist(err) {
+        return nil, errors.New("item does not exist")
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := fs.checkUnlocked(item, deletedBy); err != nil {
+        return nil, err
+    }
+    record, err := fs.trashItem(item, info, deletedBy)
+    if err != nil {
+        return nil, err
+    }
+    fs.locks.removed(item)
+    return &record.TrashItem, nil
+}
+
+// trashItem moves item into the trash of its share and out of the usage
+// ledger. Its locks are left to the caller.
+func (fs *FileService) trashItem(item *resolvedPath, info os.FileInfo, deletedBy string) (*trashRecord, error) {
+    trash, err := item.root.internalDir(trashDir)
+    if err != nil {
+        return nil, err
+    }
+
+    record := &trashRecord{
+        TrashItem: models.TrashItem{
+            ID:           uuid.New().String(),
+            Name:         info.Name(),
+            OriginalPath: item.Virtual(),
+            IsDir:        info.IsDir(),
+            Size:         treeSize(item.abs),
+            DeletedBy:    deletedBy,
+            DeletedAt:    time.Now(),
+        },
+    }
+    record.Owners = fs.usage.removed(item)
+    // Write the record first so trashed data is never left without one.
+    if err := writeTrashRecord(trash, record); err != nil {
+        fs.usage.restored(item, record.Owners)
+        return nil, err
+    }
+    if err := renameOrCopy(item.abs, filepath.Join(trash, record.ID)); err != nil {
+        os.Remove(filepath.Join(trash, record.ID+".json"))
+        fs.usage.restored(item, record.Owners)
+        return nil, err
+    }
+    return record, nil
+}
+
+// untrash undoes trashItem, putting item back where it was.
+func (fs *FileService) untrash(item *resolvedPath, record *trashRecord) error {
+    trash := filepath.Join(item.root.Path, internalDirName, trashDir)
+    if err := renameOrCopy(filepath.Join(trash, record.ID), item.abs); err != nil {
+        return err
+    }
+    os.Remove(filepath.Join(trash, record.ID+".json"))
+    fs.usage.restored(item, record.Owners)
+    return nil
+}
+
+// DeletePermanently removes a file or folder without going through the
+// trash, on behalf of user.
+func (fs *FileService) DeletePermanently(p, user string) error {
+    item, err := fs.resolve(p)
+    if err != nil {
+        return err
+    }
+    if item.IsRoot() {
+        return errors.New("cannot delete a share root")
+    }
+    if _, err := os.Lstat(item.abs); os.IsNotExist(err) {
+        return errors.New("item does not exist")
+    }
+    if err := fs.checkUnlocked(item, user); err != nil {
+        return err
+    }
+    fs.usage.removed(item)
+    fs.locks.removed(item)
+    if err := os.RemoveAll(item.abs); err != nil {
+        fs.usage.requestRescan()
+        return err
+    }
+    return nil
+}
+
+// ListTrash returns the trashed items of every share, most recent first.
+func (fs *FileService) ListTrash() ([]models.TrashItem, error) {
+    items := []models.TrashItem{}
+    for i := range fs.roots {
+        trash := filepath.Join(fs.roots[i].Path, internalDirName, trashDir)
+        entries, err := os.ReadDir(trash)
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        for _, entry := range entries {
+            id := strings.TrimSuffix(entry.Name(), ".json")
+            if id == entry.Name() {
+                continue
+            }
+            record, err := readTrashRecord(trash, id)
+            if err != nil {
+                continue
+            }
+            items = append(items, record.TrashItem)
+        }
+    }
+    sort.Slice(items, func(i, j int) bool {
+        return items[i].DeletedAt.After(items[j].DeletedAt)
+    })
+    return items, nil
+}
+
+// GetTrashItem returns a single trashed item.
+func (fs *FileService) GetTrashItem(id string) (*models.TrashItem, error) {
+    _, record, err := fs.findTrash(id)
+    if err != nil {
+        return nil, err
+    }
+    return &record.TrashItem, nil
+}
+
+// RestoreTrashItem moves an item back to its original location, recreating
+// missing parent folders. policy decides what happens if the location has
+// been taken in the meantime; an item overwritten is kept as a version or
+// goes to the trash in turn, as deleted by user. The restored files count
+// against their owners again, so a restore over an owner's quota is refused.
+func (fs *FileService) RestoreTrashItem(id string, policy ConflictPolicy, user string) (*models.File, error) {
+    trash, record, err := fs.findTrash(id)
+    if err != nil {
+        return nil, err
+    }
+    parent, err := fs.resolve(path.Dir(record.OriginalPath))
+    if err != nil {
+        return nil, err
+    }
+    target, err := parent.child(record.Name)
+    if err != nil {
+        return nil, err
+    }
+    if err := fs.checkUnlocked(target, user); err != nil {
+        return nil, err
+    }
+    if _, err := os.Lstat(target.abs); err == nil {
+        switch policy {
+        case ConflictRename:
+            if target, err = freeName(parent, record.Name); err != nil {
+                return nil, err
+            }
+        case ConflictOverwrite:
+        default:
+            return nil, ErrDestinationExists
+        }
+    }
+    if err := fs.checkRestoreQuota(filepath.Join(trash, record.ID), record, target, policy == ConflictOverwrite); err != nil {
+        return nil, err
+    }
+    if err := os.MkdirAll(parent.abs, os.ModePerm); err != nil {
+        return nil, err
+    }
+
+    err = fs.placeAt(target, policy == ConflictOverwrite, user, func(dst string) error {
+        return renameOrCopy(filepath.Join(trash, record.ID), dst)
+    })
+    if err != nil {
+        return nil, err
+    }
+    os.Remove(filepath.Join(trash, record.ID+".json"))
+    fs.usage.restored(target, record.Owners)
+    return fs.GetFileInfo(target.Virtual())
+}
+
+// checkRestoreQuota reports ErrQuotaExceeded if restoring the trashed tree
+// at abs to target would take an owner in record over their quota. An own
+// item the restore overwrites frees its size first.
+func (fs *FileService) checkRestoreQuota(abs string, record *trashRecord, target *resolvedPath, overwrite bool) error {
+    sizes := make(map[string]int64)
+    filepath.Walk(abs, func(p string, info os.FileInfo, err error) error {
+        if err != nil || !info.Mode().IsRegular() {
+            return nil
+        }
+        rel, err := filepath.Rel(abs, p)
+        if err != nil {
+            return nil
+        }
+        // Owners holds records relative to the item, the item's own under "".
+        for rel = filepath.ToSlash(rel); ; rel = path.Dir(rel) {
+            if rel == "." {
+                rel = ""
+            }
+            if owner, ok := record.Owners[rel]; ok {
+                sizes[owner] += info.Size()
+                break
+            }
+            if rel == "" {
+                break
+            }
+        }
+        return nil
+    })
+    if overwrite {
+        if _, err := os.Lstat(target.abs); err == nil {
+            fs.usage.mu.Lock()
+            replacedOwner := fs.usage.ownerOf(target.root, target.rel)
+            fs.usage.mu.Unlock()
+            if _, ok := sizes[replacedOwner]; ok {
+                sizes[replacedOwner] -= treeSize(target.abs)
+            }
+        }
+    }
+    for owner, size := range sizes {
+        if err := fs.CheckQuota(owner, size); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// PurgeTrashItem permanently deletes one trashed item.
+func (fs *FileService) PurgeTrashItem(id string) error {
+    trash, record, err := fs.findTrash(id)
+    if err != nil {
+        return err
+    }
+    return removeTrashed(trash, record.ID)
+}
+
+// EmptyTrash permanently deletes every item trashed before cutoff and
+// returns how many were removed.
+func (fs *FileService) EmptyTrash(cutoff time.Time) (int, error) {
+    items, err := fs.ListTrash()
+    if err != nil {
+        return 0, err
+    }
+    purged := 0
+    for _, item := range items {
+        if !item.DeletedAt.Before(cutoff) {
+            continue
+        }
+        if err := fs.PurgeTrashItem(item.ID); err != nil && !errors.Is(err, ErrTrashItemNotFound) {
+            return purged, err
+        }
+        purged++
+    }
+    return purged, nil
+}
+
+// PurgeExpiredTrash empties items older than SystemSettings.TrashRetentionDays.
+// A retention of 0 keeps items until they are purged by hand.
+func (fs *FileService) PurgeExpiredTrash() {
+    if fs.settings == nil {
+        return
+    }
+    settings, err := fs.settings.GetSystemSettings()
+    if err != nil || settings.TrashRetentionDays <= 0 {
+        return
+    }
+    cutoff := time.Now().AddDate(0, 0, -settings.TrashRetentionDays)
+    if purged, err := fs.EmptyTrash(cutoff); err != nil {
+        log.Printf("Failed to purge expired trash: %v", err)
+    } else if purged > 0 {
+        log.Printf("Purged %d expired trash items", purged)
+    }
+}
+
+// StartTrashPurge purges expired trash every interval in the background.
+func (fs *FileService) StartTrashPurge(interval time.Duration) {
+    go func() {
+        for {
+            fs.PurgeExpiredTrash()
+            time.Sleep(interval)
+        }
+    }()
+}
+
+// findTrash locates a trash record across all shares.
+func (fs *FileService) findTrash(id string) (string, *trashRecord, error) {
+    if _, err := uuid.Parse(id); err != nil {
+        return "", nil, ErrTrashItemNotFound
+    }
+    for i := range fs.roots {
+        trash := filepath.Join(fs.roots[i].Path, internalDirName, trashDir)
+        record, err := readTrashRecord(trash, id)
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return "", nil, err
+        }
+        return trash, record, nil
+    }
+    return "", nil, ErrTrashItemNotFound
+}
+
+func readTrashRecord(trash, id string) (*trashRecord, error) {
+    data, err := os.ReadFile(filepath.Join(trash, id+".json"))
+    if err != nil {
+        return nil, err
+    }
+    var record trashRecord
+    if err := json.Unmarshal(data, &record); err != nil {
+        return nil, err
+    }
+    return &record, nil
+}
+
+// writeTrashRecord saves the record atomically.
+func writeTrashRecord(trash string, record *trashRecord) error {
+    data, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    tmp := filepath.Join(trash, record.ID+".json.tmp")
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, filepath.Join(trash, record.ID+".json"))
+}
+
+// removeTrashed deletes the data before the record so a failure leaves the
+// item listed rather than orphaned.
+func removeTrashed(trash, id string) error {
+    if err := os.RemoveAll(filepath.Join(trash, id)); err != nil {
+        return err
+    }
+    if err := os.Remove(filepath.Join(trash, id+".json")); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    return nil
+}
+
+// treeSize returns the total size of the regular files beneath p.
+func treeSize(p string) int64 {
+    var size int64
+    filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+        if err == nil && info.Mode().IsRegular() {
+            size += info.Size()
+        }
+        return nil
+    })
+    return size
+}
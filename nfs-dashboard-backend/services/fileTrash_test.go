@@ -0,0 +1,91 @@
+package services
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestTrashRestore(t *testing.T) {
+    tests := []struct {
+        name     string
+        policy   ConflictPolicy
+        retaken  bool // the original location is taken before the restore
+        err      error
+        restored string
+        content  string
+    }{
+        {"free location", ConflictFail, false, nil, "docs/plan.txt", "original\n"},
+        {"taken then fail", ConflictFail, true, ErrDestinationExists, "", ""},
+        {"taken then rename", ConflictRename, true, nil, "docs/plan (1).txt", "original\n"},
+        {"taken then overwrite", ConflictOverwrite, true, nil, "docs/plan.txt", "original\n"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fs := newTestFiles(t, models.SystemSettings{})
+            writeTestFile(t, fs, "docs/plan.txt", "original\n")
+            item, err := fs.DeleteItem("/data/docs/plan.txt", "2")
+            if err != nil {
+                t.Fatal(err)
+            }
+            if _, err := os.Lstat(diskPath(fs, "docs/plan.txt")); !os.IsNotExist(err) {
+                t.Fatal("deleted file is still in place")
+            }
+            if tt.retaken {
+                writeTestFile(t, fs, "docs/plan.txt", "newer\n")
+            }
+
+            _, err = fs.RestoreTrashItem(item.ID, tt.policy, "2")
+            if err != tt.err {
+                t.Fatalf("RestoreTrashItem() error = %v, want %v", err, tt.err)
+            }
+            trash, err := fs.ListTrash()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if tt.err != nil {
+                if len(trash) != 1 || trash[0].ID != item.ID {
+                    t.Errorf("refused restore left trash %v, want just the deleted item", trash)
+                }
+                return
+            }
+            if got := readTestFile(t, fs, tt.restored); got != tt.content {
+                t.Errorf("%s = %q, want %q", tt.restored, got, tt.content)
+            }
+            // Whatever the restore replaced is in the trash in turn.
+            if tt.policy == ConflictOverwrite {
+                if len(trash) != 1 || trash[0].OriginalPath != "/data/docs/plan.txt" {
+                    t.Errorf("trash after overwrite = %v, want the replaced file", trash)
+                }
+            } else if tt.retaken && readTestFile(t, fs, "docs/plan.txt") != "newer\n" {
+                t.Error("restore changed the file that took the original location")
+            }
+        })
+    }
+}
+
+func TestDeleteKeepsUsage(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    if _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader("0123456789"), nil); err != nil {
+        t.Fatal(err)
+    }
+    if used := fs.Usage("2").UsedBytes; used != 10 {
+        t.Fatalf("usage after upload = %d, want 10", used)
+    }
+    item, err := fs.DeleteItem("/data/docs/plan.txt", "2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if used := fs.Usage("2").UsedBytes; used != 0 {
+        t.Errorf("usage after delete = %d, want 0", used)
+    }
+    if _, err := fs.RestoreTrashItem(item.ID, ConflictFail, "2"); err != nil {
+        t.Fatal(err)
+    }
+    // Restored files are counted again by the rescan a restore requests.
+    fs.RescanUsage()
+    if used := fs.Usage("2").UsedBytes; used != 10 {
+        t.Errorf("usage after restore = %d, want 10", used)
+    }
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+    "github.com/google/uuid"
+    "nfs-dashboard-backend/models"
+)
+
+// versionsDir is the folder inside each share's internal area holding
+// earlier contents of overwritten files, one subfolder per file path.
+const versionsDir = "versions"
+
+// ErrVersionNotFound is returned for unknown version IDs.
+var ErrVersionNotFound = errors.New("version not found")
+
+// versionFolder returns where the versions of p are kept. Paths are hashed so
+// the layout does not depend on the share's folder structure.
+func versionFolder(p *resolvedPath) string {
+    sum := sha1.Sum([]byte(p.rel))
+    return filepath.Join(p.root.Path, internalDirName, versionsDir, hex.EncodeToString(sum[:]))
+}
+
+// keepVersion copies the current content of p into its versions folder
+// before it is overwritten, then prunes old versions. The content is copied
+// rather than linked so later in-place edits cannot alter it.
+func (fs *FileService) keepVersion(p *resolvedPath, info os.FileInfo, replacedBy string) error {
+    settings := fs.versionSettings()
+    if settings.MaxVersions <= 0 {
+        return nil
+    }
+    return storeVersion(p, info, replacedBy, settings)
+}
+
+// keepBackup is keepVersion for edits, which always keep the previous
+// content: with versioning off it is kept as the only version.
+func (fs *FileService) keepBackup(p *resolvedPath, info os.FileInfo, replacedBy string) error {
+    settings := fs.versionSettings()
+    if settings.MaxVersions <= 0 {
+        settings.MaxVersions = 1
+    }
+    return storeVersion(p, info, replacedBy, settings)
+}
+
+// storeVersion does the work of keepVersion under the given settings.
+func storeVersion(p *resolvedPath, info os.FileInfo, replacedBy string, settings models.SystemSettings) error {
+    if _, err := p.root.internalDir(versionsDir); err != nil {
+        return err
+    }
+    dir := versionFolder(p)
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return err
+    }
+
+    version := &models.FileVersion{
+        ID:           uuid.New().String(),
+        Path:         p.Virtual(),
+        Size:         info.Size(),
+        LastModified: info.ModTime(),
+        ReplacedAt:   time.Now(),
+        ReplacedBy:   replacedBy,
+    }
+    tmp := filepath.Join(dir, version.ID+".tmp")
+    if err := copyFileContents(p.abs, tmp); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    os.Chtimes(tmp, time.Now(), info.ModTime())
+    if err := writeVersionRecord(dir, version); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    if err := os.Rename(tmp, filepath.Join(dir, version.ID)); err != nil {
+        os.Remove(tmp)
+        os.Remove(filepath.Join(dir, version.ID+".json"))
+        return err
+    }
+    pruneVersionFolder(dir, settings)
+    return nil
+}
+
+// versionSettings returns the live versioning settings, falling back to
+// keeping nothing when they cannot be read.
+func (fs *FileService) versionSettings() models.SystemSettings {
+    if fs.settings == nil {
+        return models.SystemSettings{}
+    }
+    settings, err := fs.settings.GetSystemSettings()
+    if err != nil {
+        log.Printf("Failed to read versioning settings: %v", err)
+        return models.SystemSettings{}
+    }
+    return *settings
+}
+
+// ListVersions returns the stored versions of a file, newest first.
+func (fs *FileService) ListVersions(p string) ([]models.FileVersion, error) {
+    file, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    return readVersions(versionFolder(file))
+}
+
+// OpenVersion opens the content of one version of a file.
+func (fs *FileService) OpenVersion(p, id string) (*os.File, *models.FileVersion, error) {
+    file, err := fs.resolve(p)
+    if err != nil {
+        return nil, nil, err
+    }
+    dir := versionFolder(file)
+    version, err := readVersionRecord(dir, id)
+    if err != nil {
+        return nil, nil, err
+    }
+    content, err := os.Open(filepath.Join(dir, version.ID))
+    if os.IsNotExist(err) {
+        return nil, nil, ErrVersionNotFound
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    return content, version, nil
+}
+
+// RestoreVersion makes a version the current content of its file. The
+// content being replaced is kept as a new version, so a restore can itself
+// be undone. The restored file is attributed to user like an upload.
+func (fs *FileService) RestoreVersion(p, id, user string) (*models.File, error) {
+    content, _, err := fs.OpenVersion(p, id)
+    if err != nil {
+        return nil, err
+    }
+    defer content.Close()
+    dest, err := fs.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    if dest.IsRoot() {
+        return nil, ErrInvalidName
+    }
+    mode := os.FileMode(0644)
+    if info, err := os.Stat(dest.abs); err == nil {
+        if info.IsDir() {
+            return nil, errors.New("path is a folder")
+        }
+        mode = info.Mode().Perm()
+    }
+    if _, err := os.Stat(filepath.Dir(dest.abs)); err != nil {
+        return nil, err
+    }
+
+    // Copy from the open handle: keeping the current content as a version may
+    // prune the very version being restored.
+    return fs.storeFile(dest, user, false, func(tmp string, quotaLeft int64) error {
+        info, err := content.Stat()
+        if err != nil {
+            return err
+        }
+        if quotaLeft != unlimitedQuota && info.Size() > quotaLeft {
+            return ErrQuotaExceeded
+        }
+        out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+        if err != nil {
+            return err
+        }
+        if _, err := io.Copy(out, content); err != nil {
+            out.Close()
+            return err
+        }
+        return out.Close()
+    })
+}
+
+// PruneVersions applies the count and age limits to the versions of every
+// file in every share.
+func (fs *FileService) PruneVersions() {
+    settings := fs.versionSettings()
+    for i := range fs.roots {
+        base := filepath.Join(fs.roots[i].Path, internalDirName, versionsDir)
+        entries, err := os.ReadDir(base)
+        if err != nil {
+            continue
+        }
+        for _, entry := range entries {
+            if entry.IsDir() {
+                pruneVersionFolder(filepath.Join(base, entry.Name()), settings)
+            }
+        }
+    }
+}
+
+// StartVersionPrune prunes versions every interval in the background, so
+// age limits apply to files that are no longer being overwritten.
+func (fs *FileService) StartVersionPrune(interval time.Duration) {
+    go func() {
+        for {
+            fs.PruneVersions()
+            time.Sleep(interval)
+        }
+    }()
+}
+
+// pruneVersionFolder keeps at most MaxVersions versions no older than
+// VersionRetentionDays and removes the folder once it is empty.
+func pruneVersionFolder(dir string, settings models.SystemSettings) {
+    versions, err := readVersions(dir)
+    if err != nil {
+        return
+    }
+    var cutoff time.Time
+    if settings.VersionRetentionDays > 0 {
+        cutoff = time.Now().AddDate(0, 0, -settings.VersionRetentionDays)
+    }
+    kept := 0
+    for _, version := range versions {
+        // With versioning disabled, existing versions only expire by age.
+        withinCount := settings.MaxVersions <= 0 || kept < settings.MaxVersions
+        if withinCount && !version.ReplacedAt.Before(cutoff) {
+            kept++
+            continue
+        }
+        for _, name := range []string{version.ID, version.ID + ".json"} {
+            if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+                log.Printf("Failed to prune version %s: %v", version.ID, err)
+            }
+        }
+    }
+    if kept == 0 {
+        os.Remove(dir)
+    }
+}
+
+// readVersions reads every version record in dir, newest first.
+func readVersions(dir string) ([]models.FileVersion, error) {
+    versions := []models.FileVersion{}
+    entries, err := os.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return versions, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    for _, entry := range entries {
+        id := strings.TrimSuffix(entry.Name(), ".json")
+        if id == entry.Name() {
+            continue
+        }
+        version, err := readVersionRecord(dir, id)
+        if err != nil {
+            continue
+        }
+        versions = append(versions, *version)
+    }
+    sort.Slice(versions, func(i, j int) bool {
+        return versions[i].ReplacedAt.After(versions[j].ReplacedAt)
+    })
+    return versions, nil
+}
+
+func readVersionRecord(dir, id string) (*models.FileVersion, error) {
+    if _, err := uuid.Parse(id); err != nil {
+        return nil, ErrVersionNotFound
+    }
+    data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+    if os.IsNotExist(err) {
+        return nil, ErrVersionNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    var version models.FileVersion
+    if err := json.Unmarshal(data, &version); err != nil {
+        return nil, err
+    }
+    return &version, nil
+}
+
+func writeVersionRecord(dir string, version *models.FileVersion) error {
+    data, err := json.Marshal(version)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(filepath.Join(dir, version.ID+".json"), data, 0600)
+}
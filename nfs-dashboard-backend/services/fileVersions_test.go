@@ -0,0 +1,153 @@
+package services
+
+import (
+    "io"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+// versionContents returns the stored versions of p, newest first.
+func versionContents(t *testing.T, fs *FileService, p string) []string {
+    versions, err := fs.ListVersions(p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var contents []string
+    for _, version := range versions {
+        content, _, err := fs.OpenVersion(p, version.ID)
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, err := io.ReadAll(content)
+        content.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        contents = append(contents, string(data))
+    }
+    return contents
+}
+
+func TestOverwriteKeepsPreviousContent(t *testing.T) {
+    tests := []struct {
+        name      string
+        overwrite func(fs *FileService) error
+        current   string
+        versions  []string
+    }{
+        {"upload", func(fs *FileService) error {
+            _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader("new\n"), nil)
+            return err
+        }, "new\n", []string{"old\n"}},
+        {"copy", func(fs *FileService) error {
+            _, err := fs.CopyItem("/data/drafts/plan.txt", "/data/docs", ConflictOverwrite, "2")
+            return err
+        }, "draft\n", []string{"old\n"}},
+        {"move", func(fs *FileService) error {
+            _, err := fs.MoveItem("/data/drafts/plan.txt", "/data/docs", ConflictOverwrite, "2")
+            return err
+        }, "draft\n", []string{"old\n"}},
+        // Renames refuse to replace anything rather than keep a version.
+        {"rename", func(fs *FileService) error {
+            _, err := fs.RenameItem("/data/docs/other.txt", "plan.txt", "2")
+            if err != ErrDestinationExists {
+                t.Errorf("rename onto an existing file: error = %v, want %v", err, ErrDestinationExists)
+            }
+            return nil
+        }, "old\n", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fs := newTestFiles(t, models.SystemSettings{MaxVersions: 5})
+            writeTestFile(t, fs, "docs/plan.txt", "old\n")
+            writeTestFile(t, fs, "docs/other.txt", "other\n")
+            writeTestFile(t, fs, "drafts/plan.txt", "draft\n")
+            if err := tt.overwrite(fs); err != nil {
+                t.Fatal(err)
+            }
+            if got := readTestFile(t, fs, "docs/plan.txt"); got != tt.current {
+                t.Errorf("content = %q, want %q", got, tt.current)
+            }
+            got := versionContents(t, fs, "/data/docs/plan.txt")
+            if strings.Join(got, "|") != strings.Join(tt.versions, "|") {
+                t.Errorf("versions = %q, want %q", got, tt.versions)
+            }
+            // A versioned file is not kept a second time in the trash.
+            if trash, err := fs.ListTrash(); err != nil || len(trash) != 0 {
+                t.Errorf("trash = %v, %v, want it empty", trash, err)
+            }
+        })
+    }
+}
+
+func TestVersionsArePrunedAndRestorable(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{MaxVersions: 2})
+    for _, content := range []string{"v1\n", "v2\n", "v3\n", "v4\n"} {
+        if _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader(content), nil); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if got := versionContents(t, fs, "/data/docs/plan.txt"); strings.Join(got, "") != "v3\nv2\n" {
+        t.Fatalf("versions = %q, want the newest two replaced", got)
+    }
+
+    versions, err := fs.ListVersions("/data/docs/plan.txt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.RestoreVersion("/data/docs/plan.txt", versions[1].ID, "2"); err != nil {
+        t.Fatal(err)
+    }
+    if got := readTestFile(t, fs, "docs/plan.txt"); got != "v2\n" {
+        t.Errorf("content after restore = %q, want %q", got, "v2\n")
+    }
+    // The restore kept what it replaced, so it can be undone.
+    if got := versionContents(t, fs, "/data/docs/plan.txt"); len(got) == 0 || got[0] != "v4\n" {
+        t.Errorf("versions after restore = %q, want v4 first", got)
+    }
+}
+
+func TestVersioningDisabled(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    writeTestFile(t, fs, "docs/plan.txt", "old\n")
+    if _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader("new\n"), nil); err != nil {
+        t.Fatal(err)
+    }
+    if got := versionContents(t, fs, "/data/docs/plan.txt"); len(got) != 0 {
+        t.Errorf("versions with max_versions 0 = %q, want none", got)
+    }
+}
+
+func TestOverwriteTrashesWhatCannotBeVersioned(t *testing.T) {
+    tests := []struct {
+        name     string
+        versions int
+        replaced string // the item in docs that is overwritten
+    }{
+        {"file with versioning off", 0, "plan.txt"},
+        {"folder", 5, "plans"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fs := newTestFiles(t, models.SystemSettings{MaxVersions: tt.versions})
+            writeTestFile(t, fs, "docs/plan.txt", "old\n")
+            writeTestFile(t, fs, "docs/plans/a.txt", "old\n")
+            writeTestFile(t, fs, "drafts/plan.txt", "draft\n")
+            writeTestFile(t, fs, "drafts/plans/b.txt", "draft\n")
+            if _, err := fs.CopyItem("/data/drafts/"+tt.replaced, "/data/docs", ConflictOverwrite, "2"); err != nil {
+                t.Fatal(err)
+            }
+            trash, err := fs.ListTrash()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(trash) != 1 || trash[0].OriginalPath != "/data/docs/"+tt.replaced || trash[0].DeletedBy != "2" {
+                t.Errorf("trash = %v, want the replaced item", trash)
+            }
+            if got := versionContents(t, fs, "/data/docs/"+tt.replaced); len(got) != 0 {
+                t.Errorf("versions = %q, want none", got)
+            }
+        })
+    }
+}
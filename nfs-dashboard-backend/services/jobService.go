@@ -0,0 +1,147 @@
+package services
+
+import (
+    "context"
+    "errors"
+    "sort"
+    "sync"
+    "time"
+    "github.com/google/uuid"
+    "nfs-dashboard-backend/models"
+)
+
+// ErrJobNotFound is returned for unknown or expired job IDs.
+var ErrJobNotFound = errors.New("job not found")
+
+// finishedJobRetention is how long a finished job stays available for polling.
+const finishedJobRetention = time.Hour
+
+// ProgressReporter receives progress updates from a long-running operation.
+type ProgressReporter interface {
+    Progress(done, total int64)
+}
+
+// JobService runs long operations in the background and keeps their
+// progress in memory so clients can poll it.
+type JobService struct {
+    mu      sync.Mutex
+    jobs    map[string]*models.Job
+    cancels map[string]context.CancelFunc
+}
+
+// NewJobService creates an empty JobService.
+func NewJobService() *JobService {
+    return &JobService{
+        jobs:    make(map[string]*models.Job),
+        cancels: make(map[string]context.CancelFunc),
+    }
+}
+
+// jobReporter forwards progress to the job it belongs to.
+type jobReporter struct {
+    js *JobService
+    id string
+}
+
+func (r jobReporter) Progress(done, total int64) {
+    r.js.mu.Lock()
+    defer r.js.mu.Unlock()
+    if job, ok := r.js.jobs[r.id]; ok {
+        job.Done, job.Total = done, total
+    }
+}
+
+// Start runs fn in the background as a job of the given type owned by owner.
+// The value fn returns becomes the job's result.
+func (js *JobService) Start(kind, owner string, fn func(ctx context.Context, progress ProgressReporter) (interface{}, error)) models.Job {
+    ctx, cancel := context.WithCancel(context.Background())
+    job := &models.Job{
+        ID:        uuid.New().String(),
+        Type:      kind,
+        Owner:     owner,
+        Status:    models.JobRunning,
+        CreatedAt: time.Now(),
+    }
+
+    js.mu.Lock()
+    js.purgeFinished()
+    js.jobs[job.ID] = job
+    js.cancels[job.ID] = cancel
+    snapshot := *job
+    js.mu.Unlock()
+
+    go func() {
+        result, err := fn(ctx, jobReporter{js: js, id: job.ID})
+        js.mu.Lock()
+        defer js.mu.Unlock()
+        now := time.Now()
+        job.FinishedAt = &now
+        job.Result = result
+        switch {
+        case err == nil:
+            job.Status = models.JobSucceeded
+        case ctx.Err() != nil:
+            job.Status = models.JobCancelled
+            job.Error = err.Error()
+        default:
+            job.Status = models.JobFailed
+            job.Error = err.Error()
+        }
+        delete(js.cancels, job.ID)
+        cancel()
+    }()
+    return snapshot
+}
+
+// Get returns a snapshot of a job.
+func (js *JobService) Get(id string) (models.Job, error) {
+    js.mu.Lock()
+    defer js.mu.Unlock()
+    job, ok := js.jobs[id]
+    if !ok {
+        return models.Job{}, ErrJobNotFound
+    }
+    return *job, nil
+}
+
+// List returns the jobs of owner, or of everyone when owner is empty,
+// newest first.
+func (js *JobService) List(owner string) []models.Job {
+    js.mu.Lock()
+    defer js.mu.Unlock()
+    js.purgeFinished()
+    jobs := []models.Job{}
+    for _, job := range js.jobs {
+        if owner == "" || job.Owner == owner {
+            jobs = append(jobs, *job)
+        }
+    }
+    sort.Slice(jobs, func(i, j int) bool {
+        return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+    })
+    return jobs
+}
+
+// Cancel asks a running job to stop. Finished jobs are left as they are.
+func (js *JobService) Cancel(id string) error {
+    js.mu.Lock()
+    defer js.mu.Unlock()
+    if _, ok := js.jobs[id]; !ok {
+        return ErrJobNotFound
+    }
+    if cancel, ok := js.cancels[id]; ok {
+        cancel()
+    }
+    return nil
+}
+
+// purgeFinished forgets jobs finished longer ago than finishedJobRetention.
+// Callers must hold mu.
+func (js *JobService) purgeFinished() {
+    cutoff := time.Now().Add(-finishedJobRetention)
+    for id, job := range js.jobs {
+        if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+            delete(js.jobs, id)
+        }
+    }
+}
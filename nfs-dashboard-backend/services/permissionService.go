@@ -0,0 +1,326 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "path"
+    "strings"
+    "nfs-dashboard-backend/models"
+)
+
+// Action is a file operation that can be granted to a role.
+type Action string
+
+const (
+    ActionRead   Action = "read"
+    ActionWrite  Action = "write"
+    ActionDelete Action = "delete"
+)
+
+// ErrRoleNotFound is returned for users whose role was deleted.
+var ErrRoleNotFound = errors.New("role no longer exists")
+
+// PermissionError explains why an action was denied.
+type PermissionError struct {
+    Role    string
+    Action  Action
+    Path    string
+    Deleted bool // the role no longer exists
+}
+
+func (e *PermissionError) Error() string {
+    if e.Role == "" {
+        return fmt.Sprintf("no role assigned; cannot %s %s", e.Action, e.Path)
+    }
+    if e.Deleted {
+        return fmt.Sprintf("role %q no longer exists; cannot %s %s", e.Role, e.Action, e.Path)
+    }
+    return fmt.Sprintf("role %q is not allowed to %s %s", e.Role, e.Action, e.Path)
+}
+
+// RoleProvider supplies the current role definitions.
+type RoleProvider interface {
+    GetRoles() ([]models.Role, error)
+}
+
+// PathResolver maps share paths to where their symlinks lead and tells
+// archives from folders named like them.
+type PathResolver interface {
+    RealPath(p string) string
+    IsArchive(p string) bool
+}
+
+// PermissionService evaluates role permissions such as "read:/data" against
+// share paths. A permission has the form "<action>:<path or glob>", where
+// either part may be "*"; a bare "*" grants everything and a bare action
+// grants that action everywhere. A grant on a directory covers its subtree.
+// Paths reached through symlinks need the permission on their target too.
+type PermissionService struct {
+    roles RoleProvider
+    paths PathResolver
+}
+
+// NewPermissionService creates a PermissionService backed by the given roles,
+// following symlinks through paths.
+func NewPermissionService(roles RoleProvider, paths PathResolver) *PermissionService {
+    return &PermissionService{roles: roles, paths: paths}
+}
+
+// RoleFor returns the current definition of the user's role. Roles are looked
+// up by name in the role store so edits made by admins apply immediately. The
+// copy embedded in the user record is never used: a role that was deleted
+// yields ErrRoleNotFound, so it no longer grants anything.
+func (ps *PermissionService) RoleFor(user *models.User) (*models.Role, error) {
+    if user == nil || user.Role == nil {
+        return nil, nil
+    }
+    roles, err := ps.roles.GetRoles()
+    if err != nil {
+        return nil, err
+    }
+    for i := range roles {
+        if roles[i].Name == user.Role.Name {
+            return &roles[i], nil
+        }
+    }
+    return nil, fmt.Errorf("%w: %q", ErrRoleNotFound, user.Role.Name)
+}
+
+// Check returns nil if the user may perform action on p, or a *PermissionError.
+func (ps *PermissionService) Check(user *models.User, action Action, p string) error {
+    role, err := ps.RoleFor(user)
+    if errors.Is(err, ErrRoleNotFound) {
+        return &PermissionError{Role: user.Role.Name, Action: action, Path: p, Deleted: true}
+    }
+    if err != nil {
+        return err
+    }
+    if role == nil {
+        return &PermissionError{Action: action, Path: p}
+    }
+    if !Allows(role.Permissions, action, governingPath(ps.paths, p)) || !Allows(role.Permissions, action, ps.realPath(p)) {
+        return &PermissionError{Role: role.Name, Action: action, Path: p}
+    }
+    return nil
+}
+
+// realPath returns where p leads once symlinks are followed.
+func (ps *PermissionService) realPath(p string) string {
+    if ps.paths == nil {
+        return p
+    }
+    return ps.paths.RealPath(p)
+}
+
+// CheckList is like Check for ActionRead but also admits ancestors of granted
+// paths, so users can navigate down to the folders they were given.
+func (ps *PermissionService) CheckList(user *models.User, p string) error {
+    role, err := ps.RoleFor(user)
+    if errors.Is(err, ErrRoleNotFound) {
+        return &PermissionError{Role: user.Role.Name, Action: ActionRead, Path: p, Deleted: true}
+    }
+    if err != nil {
+        return err
+    }
+    if role == nil {
+        return &PermissionError{Action: ActionRead, Path: p}
+    }
+    for _, candidate := range []string{governingPath(ps.paths, p), ps.realPath(p)} {
+        if !Allows(role.Permissions, ActionRead, candidate) && !grantsBeneath(role.Permissions, ActionRead, candidate) {
+            return &PermissionError{Role: role.Name, Action: ActionRead, Path: p}
+        }
+    }
+    return nil
+}
+
+// FilterVisible drops entries the user can neither read nor navigate through.
+func (ps *PermissionService) FilterVisible(user *models.User, files []models.File) []models.File {
+    scope, err := ps.ScopeFor(user)
+    if err != nil {
+        return []models.File{}
+    }
+    visible := []models.File{}
+    for _, f := range files {
+        if scope.CanSee(f.Path, f.IsDir) {
+            visible = append(visible, f)
+        }
+    }
+    return visible
+}
+
+// AccessScope is a snapshot of a user's role for evaluating many paths
+// without reloading the role store, e.g. while walking a directory tree.
+type AccessScope struct {
+    permissions []string
+    within      []string // if set, only paths in or beneath these
+    paths       PathResolver
+}
+
+// ScopeFor returns the access scope of the user's current role. Users
+// without a role, or whose role was deleted, get an empty scope that allows
+// nothing.
+func (ps *PermissionService) ScopeFor(user *models.User) (*AccessScope, error) {
+    role, err := ps.RoleFor(user)
+    if err != nil && !errors.Is(err, ErrRoleNotFound) {
+        return nil, err
+    }
+    if role == nil {
+        return &AccessScope{}, nil
+    }
+    return &AccessScope{permissions: role.Permissions, paths: ps.paths}, nil
+}
+
+// Within returns a copy of the scope limited to the given paths and what
+// lies beneath them.
+func (s *AccessScope) Within(paths ...string) *AccessScope {
+    limited := *s
+    limited.within = paths
+    return &limited
+}
+
+// contains reports whether p lies within the limits of the scope.
+func (s *AccessScope) contains(p string) bool {
+    if len(s.within) == 0 {
+        return true
+    }
+    clean, err := cleanVirtualPath(governingPath(s.paths, p))
+    if err != nil {
+        return false
+    }
+    for _, limit := range s.within {
+        limit = path.Clean("/" + limit)
+        if clean == limit || strings.HasPrefix(clean, strings.TrimSuffix(limit, "/")+"/") {
+            return true
+        }
+    }
+    return false
+}
+
+// Can reports whether the scope grants action on p.
+func (s *AccessScope) Can(action Action, p string) bool {
+    return s.contains(p) && Allows(s.permissions, action, governingPath(s.paths, p))
+}
+
+// CanTraverse reports whether p is readable or leads to something readable.
+func (s *AccessScope) CanTraverse(p string) bool {
+    governing := governingPath(s.paths, p)
+    return s.contains(p) && (Allows(s.permissions, ActionRead, governing) || grantsBeneath(s.permissions, ActionRead, governing))
+}
+
+// CanSee reports whether an entry at p shows up in listings: readable
+// entries do, and so do folders leading to something readable.
+func (s *AccessScope) CanSee(p string, isDir bool) bool {
+    return s.Can(ActionRead, p) || (isDir && s.CanTraverse(p))
+}
+
+// governingPath returns the path whose permissions govern p: the archive
+// for a path inside one, else p itself. Without paths to tell archives from
+// folders named like them, names alone decide.
+func governingPath(paths PathResolver, p string) string {
+    clean, err := cleanVirtualPath(p)
+    if err != nil {
+        return p
+    }
+    var isArchive func(string) bool
+    if paths != nil {
+        isArchive = paths.IsArchive
+    }
+    if archive, _, ok := splitArchivePath(clean, isArchive); ok {
+        return archive
+    }
+    return clean
+}
+
+// Allows reports whether a permission list grants action on path p. Paths
+// inside an archive are passed through governingPath first.
+func Allows(permissions []string, action Action, p string) bool {
+    clean, err := cleanVirtualPath(p)
+    if err != nil {
+        return false
+    }
+    for _, perm := range permissions {
+        permAction, pattern := splitPermission(perm)
+        if permAction != "*" && permAction != string(action) {
+            continue
+        }
+        if matchesPathOrAncestor(pattern, clean) {
+            return true
+        }
+    }
+    return false
+}
+
+// splitPermission splits "read:/data" into its action and path pattern.
+func splitPermission(perm string) (string, string) {
+    perm = strings.TrimSpace(perm)
+    if perm == "*" {
+        return "*", "*"
+    }
+    if i := strings.Index(perm, ":"); i >= 0 {
+        return perm[:i], perm[i+1:]
+    }
+    return perm, "*"
+}
+
+// matchesPathOrAncestor reports whether pattern matches p or one of its parents.
+func matchesPathOrAncestor(pattern, p string) bool {
+    if pattern == "*" || pattern == "" {
+        return true
+    }
+    pattern = path.Clean("/" + pattern)
+    for {
+        if matchPattern(pattern, p) {
+            return true
+        }
+        if p == "/" {
+            return false
+        }
+        p = path.Dir(p)
+    }
+}
+
+// matchPattern matches a single path against a prefix or glob pattern.
+func matchPattern(pattern, p string) bool {
+    if strings.ContainsAny(pattern, "*?[") {
+        ok, err := path.Match(pattern, p)
+        return err == nil && ok
+    }
+    return pattern == p
+}
+
+// grantsBeneath reports whether any grant for action lies strictly below p.
+func grantsBeneath(permissions []string, action Action, p string) bool {
+    clean, err := cleanVirtualPath(p)
+    if err != nil {
+        return false
+    }
+    prefix := strings.TrimSuffix(clean, "/") + "/"
+    for _, perm := range permissions {
+        permAction, pattern := splitPermission(perm)
+        if permAction != "*" && permAction != string(action) {
+            continue
+        }
+        if pattern == "*" || pattern == "" {
+            return true
+        }
+        pattern = path.Clean("/" + pattern)
+        if strings.HasPrefix(pattern, prefix) {
+            return true
+        }
+        // A glob such as "/data/*/public" may match beneath p.
+        if strings.ContainsAny(pattern, "*?[") {
+            depth := strings.Count(clean, "/")
+            if clean == "/" {
+                depth = 0
+            }
+            segs := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+            if len(segs) > depth {
+                head := "/" + strings.Join(segs[:depth], "/")
+                if ok, err := path.Match(head, clean); err == nil && ok {
+                    return true
+                }
+            }
+        }
+    }
+    return false
+}
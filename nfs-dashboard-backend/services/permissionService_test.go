@@ -0,0 +1,145 @@
+package services
+
+import (
+    "errors"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestAllows(t *testing.T) {
+    tests := []struct {
+        name        string
+        permissions []string
+        action      Action
+        path        string
+        want        bool
+    }{
+        {"everything", []string{"*"}, ActionDelete, "/data/a/b", true},
+        {"bare action", []string{"read"}, ActionRead, "/data/a", true},
+        {"bare action other action", []string{"read"}, ActionWrite, "/data/a", false},
+        {"any action on path", []string{"*:/data"}, ActionDelete, "/data/a", true},
+        {"prefix itself", []string{"read:/data"}, ActionRead, "/data", true},
+        {"prefix subtree", []string{"read:/data"}, ActionRead, "/data/a/b.txt", true},
+        {"prefix sibling", []string{"read:/data"}, ActionRead, "/database", false},
+        {"prefix parent", []string{"read:/data/a"}, ActionRead, "/data", false},
+        {"wrong action", []string{"read:/data"}, ActionWrite, "/data/a", false},
+        {"trailing slash", []string{"read:/data/"}, ActionRead, "/data/a", true},
+        {"traversal", []string{"read:/data/public"}, ActionRead, "/data/public/../private", false},
+        {"glob segment", []string{"read:/data/*/public"}, ActionRead, "/data/alice/public", true},
+        {"glob subtree", []string{"read:/data/*/public"}, ActionRead, "/data/alice/public/a.txt", true},
+        {"glob other folder", []string{"read:/data/*/public"}, ActionRead, "/data/alice/private", false},
+        {"glob does not cross segments", []string{"read:/data/*/public"}, ActionRead, "/data/a/b/public", false},
+        {"glob parent", []string{"read:/data/*/public"}, ActionRead, "/data/alice", false},
+        {"question mark", []string{"read:/data/team?"}, ActionRead, "/data/team1/a", true},
+        {"character class", []string{"read:/data/[ab]"}, ActionRead, "/data/c", false},
+        {"malformed glob", []string{"read:/data/[a"}, ActionRead, "/data/[a", false},
+        {"archive member", []string{"read:/data/a"}, ActionRead, "/data/a/b.zip!/c.txt", true},
+        {"archive outside grant", []string{"read:/data/a"}, ActionRead, "/data/b.zip!/a/c.txt", false},
+        {"no permissions", nil, ActionRead, "/data", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := Allows(tt.permissions, tt.action, tt.path); got != tt.want {
+                t.Errorf("Allows(%q, %s, %q) = %v, want %v", tt.permissions, tt.action, tt.path, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestGrantsBeneath(t *testing.T) {
+    tests := []struct {
+        permissions []string
+        path        string
+        want        bool
+    }{
+        {[]string{"read:/data/a/b"}, "/data", true},
+        {[]string{"read:/data/a/b"}, "/data/a", true},
+        {[]string{"read:/data/a/b"}, "/data/a/b", false},
+        {[]string{"read:/data/a/b"}, "/data/c", false},
+        {[]string{"read:/data/ab"}, "/data/a", false},
+        {[]string{"read:/data/*/public"}, "/data/alice", true},
+        {[]string{"read:/data/*/public"}, "/other", false},
+        {[]string{"write:/data/a/b"}, "/data", false},
+        {[]string{"read:/data/a"}, "/data/../etc", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.path, func(t *testing.T) {
+            if got := grantsBeneath(tt.permissions, ActionRead, tt.path); got != tt.want {
+                t.Errorf("grantsBeneath(%q, %q) = %v, want %v", tt.permissions, tt.path, got, tt.want)
+            }
+        })
+    }
+}
+
+type stubRoles []models.Role
+
+func (r stubRoles) GetRoles() ([]models.Role, error) {
+    return r, nil
+}
+
+func TestPermissionCheck(t *testing.T) {
+    roles := stubRoles{{Name: "staff", Permissions: []string{"read:/data/docs", "write:/data/docs"}}}
+    ps := NewPermissionService(roles, newTestShare(t))
+    staff := &models.User{ID: "2", Role: &models.Role{Name: "staff", Permissions: []string{"*"}}}
+    removed := &models.User{ID: "3", Role: &models.Role{Name: "former", Permissions: []string{"*"}}}
+    tests := []struct {
+        name   string
+        user   *models.User
+        action Action
+        path   string
+        denied bool
+    }{
+        {"granted", staff, ActionRead, "/data/docs/readme.txt", false},
+        {"not granted", staff, ActionDelete, "/data/docs/readme.txt", true},
+        {"outside grant", staff, ActionRead, "/data/escape.txt", true},
+        {"link outside grant to granted folder", staff, ActionRead, "/data/link/readme.txt", true},
+        {"embedded role ignored", staff, ActionRead, "/data/other", true},
+        {"deleted role", removed, ActionRead, "/data/docs/readme.txt", true},
+        {"no role", &models.User{ID: "4"}, ActionRead, "/data/docs", true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            err := ps.Check(tt.user, tt.action, tt.path)
+            var denied *PermissionError
+            if tt.denied != errors.As(err, &denied) {
+                t.Errorf("Check(%s, %q) = %v, want denied %v", tt.action, tt.path, err, tt.denied)
+            }
+        })
+    }
+}
+
+func TestPermissionCheckFollowsSymlinks(t *testing.T) {
+    roles := stubRoles{{Name: "staff", Permissions: []string{"read:/data/link"}}}
+    ps := NewPermissionService(roles, newTestShare(t))
+    staff := &models.User{ID: "2", Role: &models.Role{Name: "staff"}}
+    // /data/link leads to /data/docs, which the role cannot read.
+    if err := ps.Check(staff, ActionRead, "/data/link/readme.txt"); err == nil {
+        t.Error("Check allowed reading through a symlink to an unreadable folder")
+    }
+    if err := ps.CheckList(staff, "/data/link"); err == nil {
+        t.Error("CheckList allowed listing through a symlink to an unreadable folder")
+    }
+}
+
+func TestAccessScopeWithin(t *testing.T) {
+    scope := (&AccessScope{permissions: []string{"*"}}).Within("/data/docs")
+    tests := []struct {
+        path string
+        want bool
+    }{
+        {"/data/docs", true},
+        {"/data/docs/readme.txt", true},
+        {"/data/docs.zip", false},
+        {"/data/docs/a.zip!/b.txt", true},
+        {"/data", false},
+        {"/data/other", false},
+        {"/data/docs/../other", false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.path, func(t *testing.T) {
+            if got := scope.Can(ActionRead, tt.path); got != tt.want {
+                t.Errorf("Can(read, %q) = %v, want %v", tt.path, got, tt.want)
+            }
+        })
+    }
+}
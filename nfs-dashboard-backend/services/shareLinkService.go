@@ -0,0 +1,369 @@
+package services
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "nfs-dashboard-backend/models"
+    "nfs-dashboard-backend/repositories"
+
+    "github.com/google/uuid"
+    "golang.org/x/crypto/bcrypt"
+)
+
+var (
+    // ErrShareLinkNotFound is returned for unknown, forged or revoked links.
+    ErrShareLinkNotFound = errors.New("share link not found")
+    // ErrShareLinkExpired is returned for links past their expiry.
+    ErrShareLinkExpired = errors.New("share link has expired")
+    // ErrShareLinkExhausted is returned once a link's downloads are used up.
+    ErrShareLinkExhausted = errors.New("share link download limit reached")
+    // ErrSharePassword is returned when a link's password is missing or wrong.
+    ErrSharePassword = errors.New("share link password is missing or wrong")
+    // ErrShareLinkMode is returned for operations the link's mode does not allow.
+    ErrShareLinkMode = errors.New("share link does not allow this operation")
+)
+
+const (
+    // DefaultShareLinkExpiry applies when a link is created without expiry.
+    DefaultShareLinkExpiry = 7 * 24 * time.Hour
+    // MaxShareLinkExpiry bounds how long a link can stay valid.
+    MaxShareLinkExpiry = 365 * 24 * time.Hour
+    // shareLinkRetention is how long expired and revoked links are kept
+    // so their audit entries still refer to something.
+    shareLinkRetention = 30 * 24 * time.Hour
+    // DownloadSessionLength is how long a counted download can be resumed
+    // in ranges without counting again.
+    DownloadSessionLength = time.Hour
+)
+
+// ShareLinkOptions are the settings of a new share link.
+type ShareLinkOptions struct {
+    Mode         string
+    ExpiresIn    time.Duration // 0 for DefaultShareLinkExpiry
+    Password     string        // empty for none
+    MaxDownloads int           // 0 for unlimited
+    MaxFileSize  int64         // bytes per upload, 0 for the system limit
+}
+
+// ShareLinkService manages share links. A link's token is its ID signed
+// with an HMAC, so tokens cannot be guessed or forged, and revoking a link
+// invalidates its token.
+type ShareLinkService struct {
+    repo   *repositories.ShareLinkFileRepository
+    secret []byte
+    audit  AuditRecorder
+
+    mu    sync.Mutex
+    links []models.ShareLink
+}
+
+// NewShareLinkService loads the stored links. Tokens are signed with a key
+// derived from secret; without one a random key is used and links stop
+// working on restart.
+func NewShareLinkService(repo *repositories.ShareLinkFileRepository, secret string, audit AuditRecorder) (*ShareLinkService, error) {
+    links, err := repo.GetShareLinks()
+    if err != nil {
+        return nil, err
+    }
+    key := sha256.Sum256([]byte("share-links\x00" + secret))
+    if secret == "" {
+        log.Printf("No secret configured; share links will not survive a restart")
+        if _, err := rand.Read(key[:]); err != nil {
+            return nil, err
+        }
+    }
+    return &ShareLinkService{repo: repo, secret: key[:], audit: audit, links: links}, nil
+}
+
+// Create creates a link to the file or folder at p for owner. Upload and
+// request links must point to folders.
+func (s *ShareLinkService) Create(p string, isDir bool, owner string, opts ShareLinkOptions) (*models.ShareLink, error) {
+    switch opts.Mode {
+    case "":
+        opts.Mode = models.ShareLinkRead
+    case models.ShareLinkRead, models.ShareLinkUpload, models.ShareLinkRequest:
+    default:
+        return nil, fmt.Errorf("mode must be %q, %q or %q", models.ShareLinkRead, models.ShareLinkUpload, models.ShareLinkRequest)
+    }
+    if opts.Mode != models.ShareLinkRead && !isDir {
+        return nil, fmt.Errorf("%s links must point to a folder", opts.Mode)
+    }
+    if opts.ExpiresIn == 0 {
+        opts.ExpiresIn = DefaultShareLinkExpiry
+    }
+    if opts.ExpiresIn < 0 || opts.ExpiresIn > MaxShareLinkExpiry {
+        return nil, fmt.Errorf("expiry must be between now and %d days", int(MaxShareLinkExpiry/(24*time.Hour)))
+    }
+    if opts.MaxDownloads < 0 {
+        return nil, errors.New("maxDownloads must not be negative")
+    }
+    if opts.MaxFileSize < 0 {
+        return nil, errors.New("maxFileSize must not be negative")
+    }
+    if opts.MaxFileSize > 0 && opts.Mode != models.ShareLinkRequest {
+        return nil, errors.New("maxFileSize only applies to request links")
+    }
+
+    now := time.Now()
+    link := models.ShareLink{
+        ID:           uuid.New().String(),
+        Path:         p,
+        IsDir:        isDir,
+        Mode:         opts.Mode,
+        CreatedBy:    owner,
+        CreatedAt:    now,
+        ExpiresAt:    now.Add(opts.ExpiresIn),
+        MaxDownloads: opts.MaxDownloads,
+        MaxFileSize:  opts.MaxFileSize,
+    }
+    if opts.Password != "" {
+        hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+        if err != nil {
+            return nil, err
+        }
+        link.PasswordHash, link.HasPassword = string(hash), true
+    }
+
+    s.mu.Lock()
+    s.links = append(s.links, link)
+    err := s.saveLocked()
+    s.mu.Unlock()
+    if err != nil {
+        return nil, err
+    }
+    s.record("create_share_link", owner, fmt.Sprintf("Created %s link %s for %s", link.Mode, link.ID, link.Path))
+    return s.public(link), nil
+}
+
+// List returns the active links created by owner, or all active links if
+// owner is empty.
+func (s *ShareLinkService) List(owner string) []models.ShareLink {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    now := time.Now()
+    links := []models.ShareLink{}
+    for _, link := range s.links {
+        if (owner == "" || link.CreatedBy == owner) && activeError(link, now) == nil {
+            links = append(links, *s.public(link))
+        }
+    }
+    return links
+}
+
+// Get returns the link with the given ID, active or not.
+func (s *ShareLinkService) Get(id string) (*models.ShareLink, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, link := range s.links {
+        if link.ID == id {
+            return s.public(link), nil
+        }
+    }
+    return nil, ErrShareLinkNotFound
+}
+
+// Revoke disables a link for good; actor is recorded in the audit log.
+func (s *ShareLinkService) Revoke(id, actor string) error {
+    s.mu.Lock()
+    var revoked *models.ShareLink
+    for i := range s.links {
+        if s.links[i].ID == id && s.links[i].RevokedAt == nil {
+            now := time.Now()
+            s.links[i].RevokedAt = &now
+            revoked = &s.links[i]
+            break
+        }
+    }
+    if revoked == nil {
+        s.mu.Unlock()
+        return ErrShareLinkNotFound
+    }
+    path := revoked.Path
+    err := s.saveLocked()
+    s.mu.Unlock()
+    if err != nil {
+        return err
+    }
+    s.record("revoke_share_link", actor, fmt.Sprintf("Revoked link %s for %s", id, path))
+    return nil
+}
+
+// Resolve returns the active link a token belongs to after checking its
+// signature and password. The link is returned along with errors about its
+// state, so failed accesses can be audited against it.
+func (s *ShareLinkService) Resolve(token, password string) (*models.ShareLink, error) {
+    i := strings.LastIndex(token, ".")
+    if i < 0 || !hmac.Equal([]byte(token), []byte(s.token(token[:i]))) {
+        return nil, ErrShareLinkNotFound
+    }
+    link, err := s.Get(token[:i])
+    if err != nil {
+        return nil, err
+    }
+    if link.RevokedAt != nil {
+        return nil, ErrShareLinkNotFound
+    }
+    if err := activeError(*link, time.Now()); err != nil {
+        return link, err
+    }
+    if link.HasPassword {
+        s.mu.Lock()
+        hash := s.passwordHash(link.ID)
+        s.mu.Unlock()
+        if password == "" || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+            return link, ErrSharePassword
+        }
+    }
+    return link, nil
+}
+
+// ConsumeDownload counts a download of the link, failing once its limit is
+// reached.
+func (s *ShareLinkService) ConsumeDownload(id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for i := range s.links {
+        link := &s.links[i]
+        if link.ID != id {
+            continue
+        }
+        if err := activeError(*link, time.Now()); err != nil {
+            return err
+        }
+        link.Downloads++
+        return s.saveLocked()
+    }
+    return ErrShareLinkNotFound
+}
+
+// StartDownload counts a download of rel through the link and returns a
+// session under which ranges of it can be fetched without counting again.
+func (s *ShareLinkService) StartDownload(id, rel string) (string, error) {
+    if err := s.ConsumeDownload(id); err != nil {
+        return "", err
+    }
+    return s.downloadSession(id, rel, time.Now().Add(DownloadSessionLength).Unix()), nil
+}
+
+// ResumesDownload reports whether session was issued by StartDownload for
+// rel through the link and has not expired.
+func (s *ShareLinkService) ResumesDownload(id, rel, session string) bool {
+    i := strings.Index(session, ".")
+    if i < 0 {
+        return false
+    }
+    expires, err := strconv.ParseInt(session[:i], 36, 64)
+    if err != nil || time.Now().Unix() > expires {
+        return false
+    }
+    return hmac.Equal([]byte(session), []byte(s.downloadSession(id, rel, expires)))
+}
+
+// downloadSession signs a download of rel through a link until expires.
+func (s *ShareLinkService) downloadSession(id, rel string, expires int64) string {
+    exp := strconv.FormatInt(expires, 36)
+    mac := hmac.New(sha256.New, s.secret)
+    mac.Write([]byte("download\x00" + id + "\x00" + rel + "\x00" + exp))
+    return exp + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// RecordAccess writes the audit log entry of an access through a link.
+// Entries are attributed to the link's creator.
+func (s *ShareLinkService) RecordAccess(link *models.ShareLink, operation, remote string, err error) {
+    outcome := "ok"
+    if err != nil {
+        outcome = err.Error()
+    }
+    s.record("access_share_link", link.CreatedBy,
+        fmt.Sprintf("Link %s: %s from %s: %s", link.ID, operation, remote, outcome))
+}
+
+// Prune forgets links that expired or were revoked long ago.
+func (s *ShareLinkService) Prune() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    cutoff := time.Now().Add(-shareLinkRetention)
+    kept := s.links[:0]
+    for _, link := range s.links {
+        ended := link.ExpiresAt
+        if link.RevokedAt != nil && link.RevokedAt.Before(ended) {
+            ended = *link.RevokedAt
+        }
+        if ended.After(cutoff) {
+            kept = append(kept, link)
+        }
+    }
+    if len(kept) == len(s.links) {
+        return
+    }
+    s.links = kept
+    if err := s.saveLocked(); err != nil {
+        log.Printf("Failed to prune share links: %v", err)
+    }
+}
+
+// StartPrune prunes old links every interval in the background.
+func (s *ShareLinkService) StartPrune(interval time.Duration) {
+    go func() {
+        for {
+            s.Prune()
+            time.Sleep(interval)
+        }
+    }()
+}
+
+// activeError reports why a link can no longer be used, if it cannot.
+func activeError(link models.ShareLink, now time.Time) error {
+    switch {
+    case link.RevokedAt != nil:
+        return ErrShareLinkNotFound
+    case now.After(link.ExpiresAt):
+        return ErrShareLinkExpired
+    case link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads:
+        return ErrShareLinkExhausted
+    }
+    return nil
+}
+
+// token signs a link ID.
+func (s *ShareLinkService) token(id string) string {
+    mac := hmac.New(sha256.New, s.secret)
+    mac.Write([]byte(id))
+    return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// public returns a copy of link as clients see it: with its token and
+// without the password hash.
+func (s *ShareLinkService) public(link models.ShareLink) *models.ShareLink {
+    link.PasswordHash = ""
+    link.Token = s.token(link.ID)
+    return &link
+}
+
+func (s *ShareLinkService) passwordHash(id string) string {
+    for _, link := range s.links {
+        if link.ID == id {
+            return link.PasswordHash
+        }
+    }
+    return ""
+}
+
+func (s *ShareLinkService) saveLocked() error {
+    return s.repo.SaveShareLinks(s.links)
+}
+
+func (s *ShareLinkService) record(action, userID, details string) {
+    if s.audit != nil {
+        s.audit.RecordAuditLog(action, userID, details)
+    }
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    "nfs-dashboard-backend/repositories"
+)
+
+func newTestShareLinks(t *testing.T, secret string) *ShareLinkService {
+    repo := repositories.NewShareLinkFileRepository(filepath.Join(t.TempDir(), "share_links.json"))
+    s, err := NewShareLinkService(repo, secret, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return s
+}
+
+func TestShareLinkResolve(t *testing.T) {
+    s := newTestShareLinks(t, "secret")
+    open, err := s.Create("/data/docs", true, "1", ShareLinkOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    locked, err := s.Create("/data/docs/plan.pdf", false, "1", ShareLinkOptions{Password: "hunter2"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    expired, err := s.Create("/data/old", true, "1", ShareLinkOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    exhausted, err := s.Create("/data/once.txt", false, "1", ShareLinkOptions{MaxDownloads: 1})
+    if err != nil {
+        t.Fatal(err)
+    }
+    revoked, err := s.Create("/data/gone", true, "1", ShareLinkOptions{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := range s.links {
+        if s.links[i].ID == expired.ID {
+            s.links[i].ExpiresAt = time.Now().Add(-time.Minute)
+        }
+    }
+    if err := s.ConsumeDownload(exhausted.ID); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Revoke(revoked.ID, "1"); err != nil {
+        t.Fatal(err)
+    }
+
+    signature := open.Token[strings.LastIndex(open.Token, ".")+1:]
+    flipped := []byte(open.Token)
+    if flipped[len(flipped)-1] == 'A' {
+        flipped[len(flipped)-1] = 'B'
+    } else {
+        flipped[len(flipped)-1] = 'A'
+    }
+    tests := []struct {
+        name     string
+        token    string
+        password string
+        err      error
+    }{
+        {"valid", open.Token, "", nil},
+        {"password ignored when unset", open.Token, "anything", nil},
+        {"tampered signature", string(flipped), "", ErrShareLinkNotFound},
+        {"truncated signature", open.Token[:len(open.Token)-2], "", ErrShareLinkNotFound},
+        {"signature of another link", locked.ID + "." + signature, "", ErrShareLinkNotFound},
+        {"signed by another secret", newTestShareLinks(t, "other").token(open.ID), "", ErrShareLinkNotFound},
+        {"unknown link", s.token("00000000-0000-0000-0000-000000000000"), "", ErrShareLinkNotFound},
+        {"bare ID", open.ID, "", ErrShareLinkNotFound},
+        {"empty", "", "", ErrShareLinkNotFound},
+        {"expired", expired.Token, "", ErrShareLinkExpired},
+        {"downloads used up", exhausted.Token, "", ErrShareLinkExhausted},
+        {"revoked", revoked.Token, "", ErrShareLinkNotFound},
+        {"right password", locked.Token, "hunter2", nil},
+        {"missing password", locked.Token, "", ErrSharePassword},
+        {"wrong password", locked.Token, "hunter3", ErrSharePassword},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if _, err := s.Resolve(tt.token, tt.password); err != tt.err {
+                t.Errorf("Resolve(%q, %q) error = %v, want %v", tt.token, tt.password, err, tt.err)
+            }
+        })
+    }
+}
+
+func TestShareLinkTokensSurviveRestart(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "share_links.json")
+    first, err := NewShareLinkService(repositories.NewShareLinkFileRepository(file), "secret", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    link, err := first.Create("/data/docs", true, "1", ShareLinkOptions{Password: "hunter2"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if link.PasswordHash != "" {
+        t.Error("Create returned the password hash")
+    }
+    second, err := NewShareLinkService(repositories.NewShareLinkFileRepository(file), "secret", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := second.Resolve(link.Token, "hunter2"); err != nil {
+        t.Errorf("Resolve after restart: %v", err)
+    }
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+var (
+    // ErrPathOutsideRoot is returned when a requested path escapes its share root.
+    ErrPathOutsideRoot = errors.New("path is outside of the configured share roots")
+    // ErrShareNotFound is returned when the first path segment does not name a share.
+    ErrShareNotFound = errors.New("share not found")
+    // ErrInvalidName is returned when a file or folder name is not a single path segment.
+    ErrInvalidName = errors.New("invalid file name")
+)
+
+// defaultShareRoots is used when SHARE_ROOTS is not set.
+const defaultShareRoots = "/data"
+
+// internalDirName is a hidden folder at the top of every share where the
+// dashboard keeps its own state. It is never exposed to clients.
+const internalDirName = ".nfs-dashboard"
+
+// ShareRoot is a directory on the server that is exposed through the dashboard.
+// Clients address files as "/<Name>/<path inside the share>".
+type ShareRoot struct {
+    Name string `json:"name"`
+    Path string `json:"-"`
+}
+
+// ParseShareRoots parses a comma-separated list of share roots. Each entry is
+// either "name=/abs/path" or just "/abs/path", in which case the base name of
+// the directory is used as the share name.
+func ParseShareRoots(spec string) ([]ShareRoot, error) {
+    if strings.TrimSpace(spec) == "" {
+        spec = defaultShareRoots
+    }
+    var roots []ShareRoot
+    seen := make(map[string]bool)
+    for _, entry := range strings.Split(spec, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        name, dir := "", entry
+        if i := strings.Index(entry, "="); i >= 0 {
+            name, dir = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+        }
+        if !filepath.IsAbs(dir) {
+            return nil, fmt.Errorf("share root %q must be an absolute path", dir)
+        }
+        dir = filepath.Clean(dir)
+        if name == "" {
+            name = filepath.Base(dir)
+        }
+        if !isValidName(name) {
+            return nil, fmt.Errorf("invalid share name %q", name)
+        }
+        if seen[name] {
+            return nil, fmt.Errorf("duplicate share name %q", name)
+        }
+        seen[name] = true
+        // Resolve symlinks once so containment checks compare real paths.
+        if real, err := filepath.EvalSymlinks(dir); err == nil {
+            dir = real
+        }
+        roots = append(roots, ShareRoot{Name: name, Path: dir})
+    }
+    if len(roots) == 0 {
+        return nil, errors.New("no share roots configured")
+    }
+    return roots, nil
+}
+
+// internalDir returns (creating if needed) a folder inside the share's
+// internal area, e.g. internalDir("uploads").
+func (r *ShareRoot) internalDir(sub string) (string, error) {
+    dir := filepath.Join(r.Path, internalDirName, sub)
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return "", err
+    }
+    return dir, nil
+}
+
+// isInternal reports whether a share-relative path lies in the internal area.
+func isInternal(rel string) bool {
+    return rel == internalDirName || strings.HasPrefix(rel, internalDirName+"/")
+}
+
+// resolvedPath is a client path mapped onto the filesystem.
+type resolvedPath struct {
+    root *ShareRoot
+    rel  string // slash-separated path inside the share, "" for the share itself
+    abs  string // real filesystem path
+}
+
+// Virtual returns the client-facing path, e.g. "/data/reports/q1.csv".
+func (p *resolvedPath) Virtual() string {
+    return path.Join("/", p.root.Name, p.rel)
+}
+
+// IsRoot reports whether the path is the share root itself.
+func (p *resolvedPath) IsRoot() bool {
+    return p.rel == ""
+}
+
+// child resolves a single name inside this directory.
+func (p *resolvedPath) child(name string) (*resolvedPath, error) {
+    if !isValidName(name) || (p.IsRoot() && name == internalDirName) {
+        return nil, ErrInvalidName
+    }
+    c := &resolvedPath{
+        root: p.root,
+        rel:  path.Join(p.rel, name),
+        abs:  filepath.Join(p.abs, name),
+    }
+    if err := c.checkContained(); err != nil {
+        return nil, err
+    }
+    return c, nil
+}
+
+// checkContained verifies that following symlinks neither leaves the share
+// nor leads into its internal area. Paths that do not exist yet are checked
+// through their nearest existing ancestor.
+func (p *resolvedPath) checkContained() error {
+    target := p.abs
+    for {
+        real, err := filepath.EvalSymlinks(target)
+        if err == nil {
+            if !isWithin(p.root.Path, real) || isWithin(filepath.Join(p.root.Path, internalDirName), real) {
+                return ErrPathOutsideRoot
+            }
+            return nil
+        }
+        if !os.IsNotExist(err) {
+            return err
+        }
+        // A dangling symlink would be followed on create, so refuse it.
+        if _, lerr := os.Lstat(target); lerr == nil {
+            return ErrPathOutsideRoot
+        }
+        parent := filepath.Dir(target)
+        if parent == target || !isWithin(p.root.Path, parent) {
+            return ErrPathOutsideRoot
+        }
+        target = parent
+    }
+}
+
+// realVirtual returns the client path of where p leads once symlinks are
+// followed, which checkContained keeps inside the share. Elements that do not
+// exist yet are kept as they are.
+func (p *resolvedPath) realVirtual() string {
+    target, rest := p.abs, ""
+    for {
+        real, err := filepath.EvalSymlinks(target)
+        if err == nil {
+            rel, err := filepath.Rel(p.root.Path, real)
+            if err != nil {
+                return p.Virtual()
+            }
+            return path.Join("/", p.root.Name, filepath.ToSlash(rel), rest)
+        }
+        parent := filepath.Dir(target)
+        if parent == target || !isWithin(p.root.Path, parent) {
+            return p.Virtual()
+        }
+        rest = path.Join(filepath.Base(target), rest)
+        target = parent
+    }
+}
+
+// realRel returns the share-relative path of the entry that operations on p
+// act on: that of p once the symlinks of its parent folders are followed.
+// A symlink at p itself is the entry, so it is not followed.
+func (p *resolvedPath) realRel() string {
+    if p.IsRoot() {
+        return ""
+    }
+    parent := &resolvedPath{root: p.root, rel: path.Dir(p.rel), abs: filepath.Dir(p.abs)}
+    if parent.rel == "." {
+        parent.rel = ""
+    }
+    dir := strings.TrimPrefix(strings.TrimPrefix(parent.realVirtual(), "/"+p.root.Name), "/")
+    return path.Join(dir, path.Base(p.rel))
+}
+
+// cleanVirtualPath normalises a client path and rejects ".." segments.
+func cleanVirtualPath(p string) (string, error) {
+    p = strings.ReplaceAll(p, "\\", "/")
+    for _, seg := range strings.Split(p, "/") {
+        if seg == ".." {
+            return "", ErrPathOutsideRoot
+        }
+    }
+    return path.Clean("/" + p), nil
+}
+
+// isValidName reports whether name is usable as a single path segment.
+func isValidName(name string) bool {
+    return name != "" && name != "." && name != ".." &&
+        !strings.ContainsAny(name, "/\\\x00")
+}
+
+// isWithin reports whether target equals base or lies beneath it.
+func isWithin(base, target string) bool {
+    rel, err := filepath.Rel(base, target)
+    if err != nil {
+        return false
+    }
+    return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// newTestShare lays out a share "data" next to a folder outside of it:
+//
+//     data/docs/readme.txt
+//     data/docs/out -> outside
+//     data/docs/internal -> data/.nfs-dashboard
+//     data/link -> data/docs
+//     data/escape.txt -> outside/secret.txt
+//     outside/secret.txt
+func newTestShare(t *testing.T) *FileService {
+    dir, err := filepath.EvalSymlinks(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, outside := filepath.Join(dir, "data"), filepath.Join(dir, "outside")
+    for _, d := range []string{filepath.Join(data, "docs"), filepath.Join(data, internalDirName), outside} {
+        if err := os.MkdirAll(d, 0755); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, f := range []string{filepath.Join(data, "docs", "readme.txt"), filepath.Join(outside, "secret.txt")} {
+        if err := os.WriteFile(f, []byte("text\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    links := map[string]string{
+        filepath.Join(data, "docs", "out"):      outside,
+        filepath.Join(data, "docs", "internal"): filepath.Join(data, internalDirName),
+        filepath.Join(data, "link"):             filepath.Join(data, "docs"),
+        filepath.Join(data, "escape.txt"):       filepath.Join(outside, "secret.txt"),
+    }
+    for name, target := range links {
+        if err := os.Symlink(target, name); err != nil {
+            t.Fatal(err)
+        }
+    }
+    roots, err := ParseShareRoots(data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &FileService{roots: roots}
+}
+
+func TestCleanVirtualPath(t *testing.T) {
+    tests := []struct {
+        in   string
+        want string
+        err  error
+    }{
+        {"/data/docs", "/data/docs", nil},
+        {"data/docs/", "/data/docs", nil},
+        {"//data///docs/./readme.txt", "/data/docs/readme.txt", nil},
+        {`\data\docs`, "/data/docs", nil},
+        {"", "/", nil},
+        {"/data/..", "", ErrPathOutsideRoot},
+        {"/data/docs/../../etc/passwd", "", ErrPathOutsideRoot},
+        {"../data", "", ErrPathOutsideRoot},
+        {`\data\..\..\etc`, "", ErrPathOutsideRoot},
+        {"/data/..hidden", "/data/..hidden", nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.in, func(t *testing.T) {
+            got, err := cleanVirtualPath(tt.in)
+            if got != tt.want || err != tt.err {
+                t.Errorf("cleanVirtualPath(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+            }
+        })
+    }
+}
+
+func TestResolve(t *testing.T) {
+    fs := newTestShare(t)
+    tests := []struct {
+        in  string
+        rel string
+        err error
+    }{
+        {"/data", "", nil},
+        {"/data/docs/readme.txt", "docs/readme.txt", nil},
+        {"/data/docs/new.txt", "docs/new.txt", nil},
+        {"/data/link/readme.txt", "link/readme.txt", nil},
+        {"/", "", ErrPathOutsideRoot},
+        {"/other/docs", "", ErrShareNotFound},
+        {"/data/../outside/secret.txt", "", ErrPathOutsideRoot},
+        {"/data/docs/../../outside", "", ErrPathOutsideRoot},
+        {"/data/.nfs-dashboard", "", ErrPathOutsideRoot},
+        {"/data/.nfs-dashboard/owners.json", "", ErrPathOutsideRoot},
+        {"/data/docs/out", "", ErrPathOutsideRoot},
+        {"/data/docs/out/secret.txt", "", ErrPathOutsideRoot},
+        {"/data/docs/out/new.txt", "", ErrPathOutsideRoot},
+        {"/data/escape.txt", "", ErrPathOutsideRoot},
+        {"/data/docs/internal", "", ErrPathOutsideRoot},
+        {"/data/docs/internal/owners.json", "", ErrPathOutsideRoot},
+    }
+    for _, tt := range tests {
+        t.Run(tt.in, func(t *testing.T) {
+            got, err := fs.resolve(tt.in)
+            if err != tt.err {
+                t.Fatalf("resolve(%q) error = %v, want %v", tt.in, err, tt.err)
+            }
+            if err == nil && got.rel != tt.rel {
+                t.Errorf("resolve(%q).rel = %q, want %q", tt.in, got.rel, tt.rel)
+            }
+        })
+    }
+}
+
+func TestRealPath(t *testing.T) {
+    fs := newTestShare(t)
+    // Only paths into an existing file are taken for archive paths.
+    writeTestFile(t, fs, "docs/bundle.zip", "")
+    tests := []struct {
+        in   string
+        want string
+    }{
+        {"/data/docs/readme.txt", "/data/docs/readme.txt"},
+        {"/data/link", "/data/docs"},
+        {"/data/link/readme.txt", "/data/docs/readme.txt"},
+        {"/data/link/new/file.txt", "/data/docs/new/file.txt"},
+        {"/data/link/bundle.zip!/inner.txt", "/data/docs/bundle.zip"},
+        {"/data/link/other.zip!/inner.txt", "/data/docs/other.zip!/inner.txt"},
+        {"/data/docs/out/secret.txt", "/data/docs/out/secret.txt"},
+        {"/data/../outside", "/data/../outside"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.in, func(t *testing.T) {
+            if got := fs.RealPath(tt.in); got != tt.want {
+                t.Errorf("RealPath(%q) = %q, want %q", tt.in, got, tt.want)
+            }
+        })
+    }
+}
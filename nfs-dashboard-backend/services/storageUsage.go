@@ -0,0 +1,495 @@
+package services
+
+import (
+    "encoding/json"
+    "errors"
+    "log"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+// ErrQuotaExceeded is returned when a write would take a user past
+// SystemSettings.MaxStoragePerUser.
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// ownersFile holds, inside each share's internal area, the user that created
+// each file or folder, keyed by share-relative path.
+const ownersFile = "owners.json"
+
+// unlimitedQuota marks a user without a storage limit.
+const unlimitedQuota int64 = -1
+
+// usageTotal is the running consumption of one user.
+type usageTotal struct {
+    bytes int64
+    files int
+}
+
+// usageLedger attributes files to the users that wrote them. A file belongs
+// to the owner recorded for it or, failing that, for its nearest recorded
+// ancestor folder, so files added outside the dashboard to a user's folder
+// count against that user once a rescan sees them. Totals are adjusted on
+// every write and rebuilt by a full rescan.
+type usageLedger struct {
+    mu        sync.Mutex
+    owners    map[string]map[string]string // share path -> rel path -> user ID
+    totals    map[string]*usageTotal
+    scan      *usageScan // the rescan in progress, if any
+    scannedAt time.Time
+    rescan    chan struct{}
+    scanMu    sync.Mutex // serializes rescans
+}
+
+// usageScan tracks how far a rescan got, so that changes to files it has
+// already walked past can be carried over into the totals it builds.
+type usageScan struct {
+    done    map[string]bool // share paths walked completely
+    root    string          // share path being walked
+    at      string          // rel path visited last in root
+    pending map[string]*usageTotal
+}
+
+// passed reports whether the scan has walked past rel in the share at
+// root. filepath.Walk visits names in lexical order, depth first.
+func (s *usageScan) passed(root, rel string) bool {
+    if s.done[root] {
+        return true
+    }
+    if root != s.root || s.at == "" {
+        return false
+    }
+    at := strings.Split(s.at, "/")
+    for i, name := range strings.Split(rel, "/") {
+        if i == len(at) {
+            // rel lies beneath the folder being entered.
+            return false
+        }
+        if name != at[i] {
+            return name < at[i]
+        }
+    }
+    // rel is the path visited last or a folder above it.
+    return true
+}
+
+func newUsageLedger() *usageLedger {
+    return &usageLedger{
+        owners: make(map[string]map[string]string),
+        totals: make(map[string]*usageTotal),
+        rescan: make(chan struct{}, 1),
+    }
+}
+
+// shareOwners returns the ownership records of a share, loading them on
+// first use. Callers must hold mu.
+func (l *usageLedger) shareOwners(root *ShareRoot) map[string]string {
+    if owners, ok := l.owners[root.Path]; ok {
+        return owners
+    }
+    owners := make(map[string]string)
+    data, err := os.ReadFile(filepath.Join(root.Path, internalDirName, ownersFile))
+    if err == nil {
+        if err := json.Unmarshal(data, &owners); err != nil {
+            log.Printf("Ignoring unreadable %s in share %s: %v", ownersFile, root.Name, err)
+            owners = make(map[string]string)
+        }
+    }
+    l.owners[root.Path] = owners
+    return owners
+}
+
+// save persists the ownership records of a share. Callers must hold mu.
+func (l *usageLedger) save(root *ShareRoot) {
+    dir, err := root.internalDir("")
+    if err == nil {
+        var data []byte
+        if data, err = json.Marshal(l.shareOwners(root)); err == nil {
+            tmp := filepath.Join(dir, ownersFile+".tmp")
+            if err = os.WriteFile(tmp, data, 0600); err == nil {
+                err = os.Rename(tmp, filepath.Join(dir, ownersFile))
+            }
+        }
+    }
+    if err != nil {
+        log.Printf("Failed to save file owners of share %s: %v", root.Name, err)
+    }
+}
+
+// ownerOf returns the user a path is attributed to, or "" if nobody.
+// Callers must hold mu.
+func (l *usageLedger) ownerOf(root *ShareRoot, rel string) string {
+    owners := l.shareOwners(root)
+    for rel != "" && rel != "." {
+        if owner, ok := owners[rel]; ok {
+            return owner
+        }
+        rel = path.Dir(rel)
+    }
+    return ""
+}
+
+// adjust changes a user's totals for a change to the file rel of root.
+// Callers must hold mu.
+func (l *usageLedger) adjust(root *ShareRoot, rel, owner string, bytes int64, files int) {
+    addUsage(l.totals, owner, bytes, files)
+    if l.scan != nil && l.scan.passed(root.Path, rel) {
+        addUsage(l.scan.pending, owner, bytes, files)
+    }
+}
+
+// addUsage adds to the totals of owner in totals.
+func addUsage(totals map[string]*usageTotal, owner string, bytes int64, files int) {
+    if owner == "" {
+        return
+    }
+    total, ok := totals[owner]
+    if !ok {
+        total = &usageTotal{}
+        totals[owner] = total
+    }
+    total.bytes += bytes
+    total.files += files
+}
+
+// used returns how many bytes are attributed to owner.
+func (l *usageLedger) used(owner string) int64 {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if total, ok := l.totals[owner]; ok {
+        return total.bytes
+    }
+    return 0
+}
+
+// stored records that owner wrote a file of size bytes at p, replacing
+// a file of replaced bytes that belonged to previous.
+func (l *usageLedger) stored(p *resolvedPath, owner string, size int64, previous string, replaced int64, existed bool) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if existed {
+        l.adjust(p.root, p.rel, previous, -replaced, -1)
+    }
+    l.adjust(p.root, p.rel, owner, size, 1)
+    l.setOwner(p, owner)
+}
+
+// created records that owner created the folder p.
+func (l *usageLedger) created(p *resolvedPath, owner string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.setOwner(p, owner)
+}
+
+// setOwner records owner for p when it differs from what p inherits.
+// Callers must hold mu.
+func (l *usageLedger) setOwner(p *resolvedPath, owner string) {
+    if owner == "" || l.ownerOf(p.root, p.rel) == owner {
+        return
+    }
+    l.shareOwners(p.root)[p.rel] = owner
+    l.save(p.root)
+}
+
+// removed releases the usage of everything beneath p, which must still exist,
+// and drops its ownership records. The records are returned relative to p,
+// with the effective owner of p itself under "", so they can be reattached.
+func (l *usageLedger) removed(p *resolvedPath) map[string]string {
+    // The tree is walked before locking, so a large one does not hold up
+    // every other write.
+    sizes := make(map[string]int64)
+    filepath.Walk(p.abs, func(abs string, info os.FileInfo, err error) error {
+        if err != nil || !info.Mode().IsRegular() {
+            return nil
+        }
+        if rel, err := filepath.Rel(p.root.Path, abs); err == nil {
+            sizes[filepath.ToSlash(rel)] = info.Size()
+        }
+        return nil
+    })
+
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    for rel, size := range sizes {
+        l.adjust(p.root, rel, l.ownerOf(p.root, rel), -size, -1)
+    }
+
+    detached := make(map[string]string)
+    if owner := l.ownerOf(p.root, p.rel); owner != "" {
+        detached[""] = owner
+    }
+    for rel, owner := range l.shareOwners(p.root) {
+        if strings.HasPrefix(rel, p.rel+"/") {
+            detached[strings.TrimPrefix(rel, p.rel+"/")] = owner
+        }
+    }
+    if l.dropOwners(p) {
+        l.save(p.root)
+    }
+    return detached
+}
+
+// restored reattaches records returned by removed to p and schedules a
+// rescan to count the files again.
+func (l *usageLedger) restored(p *resolvedPath, owners map[string]string) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    records := l.shareOwners(p.root)
+    for rel, owner := range owners {
+        records[path.Join(p.rel, rel)] = owner
+    }
+    if len(owners) > 0 {
+        l.save(p.root)
+    }
+    l.requestRescan()
+}
+
+// dropOwners deletes the records of p and everything beneath it.
+// Callers must hold mu.
+func (l *usageLedger) dropOwners(p *resolvedPath) bool {
+    owners := l.shareOwners(p.root)
+    changed := false
+    for rel := range owners {
+        if rel == p.rel || strings.HasPrefix(rel, p.rel+"/") {
+            delete(owners, rel)
+            changed = true
+        }
+    }
+    return changed
+}
+
+// moved carries the ownership records of src over to dst. The effective
+// owner of src is pinned on dst so that it survives leaving its parent.
+func (l *usageLedger) moved(src, dst *resolvedPath) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    from := l.shareOwners(src.root)
+    to := l.shareOwners(dst.root)
+    owner := l.ownerOf(src.root, src.rel)
+    moved := make(map[string]string)
+    for rel, o := range from {
+        if rel == src.rel || strings.HasPrefix(rel, src.rel+"/") {
+            moved[dst.rel+strings.TrimPrefix(rel, src.rel)] = o
+        }
+    }
+    l.dropOwners(src)
+    l.dropOwners(dst)
+    for rel, o := range moved {
+        to[rel] = o
+    }
+    if owner != "" {
+        to[dst.rel] = owner
+    }
+    l.save(src.root)
+    if dst.root != src.root {
+        l.save(dst.root)
+    }
+}
+
+// copied records that owner copied the tree now at p and counts it
+// against them.
+func (l *usageLedger) copied(p *resolvedPath, owner string) {
+    sizes := make(map[string]int64)
+    filepath.Walk(p.abs, func(abs string, info os.FileInfo, err error) error {
+        if err != nil || !info.Mode().IsRegular() {
+            return nil
+        }
+        if rel, err := filepath.Rel(p.root.Path, abs); err == nil {
+            sizes[filepath.ToSlash(rel)] = info.Size()
+        }
+        return nil
+    })
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    for rel, size := range sizes {
+        l.adjust(p.root, rel, owner, size, 1)
+    }
+    l.setOwner(p, owner)
+}
+
+// requestRescan asks the background loop for a rescan without waiting.
+func (l *usageLedger) requestRescan() {
+    select {
+    case l.rescan <- struct{}{}:
+    default:
+    }
+}
+
+// quotaLeft returns how many more bytes owner may store, or unlimitedQuota.
+func (fs *FileService) quotaLeft(owner string) (int64, error) {
+    if owner == "" || fs.settings == nil {
+        return unlimitedQuota, nil
+    }
+    settings, err := fs.settings.GetSystemSettings()
+    if err != nil {
+        return 0, err
+    }
+    if settings.MaxStoragePerUser <= 0 {
+        return unlimitedQuota, nil
+    }
+    left := int64(settings.MaxStoragePerUser)<<30 - fs.usage.used(owner)
+    if left < 0 {
+        left = 0
+    }
+    return left, nil
+}
+
+// quotaBytes returns the configured per-user limit, 0 meaning unlimited.
+func (fs *FileService) quotaBytes() int64 {
+    if fs.settings == nil {
+        return 0
+    }
+    settings, err := fs.settings.GetSystemSettings()
+    if err != nil || settings.MaxStoragePerUser <= 0 {
+        return 0
+    }
+    return int64(settings.MaxStoragePerUser) << 30
+}
+
+// CheckQuota reports ErrQuotaExceeded if owner cannot store size more bytes.
+func (fs *FileService) CheckQuota(owner string, size int64) error {
+    left, err := fs.quotaLeft(owner)
+    if err != nil {
+        return err
+    }
+    if left != unlimitedQuota && size > left {
+        return ErrQuotaExceeded
+    }
+    return nil
+}
+
+// Usage returns the storage attributed to a user.
+func (fs *FileService) Usage(userID string) models.StorageUsage {
+    fs.usage.mu.Lock()
+    defer fs.usage.mu.Unlock()
+    usage := models.StorageUsage{
+        UserID:     userID,
+        QuotaBytes: fs.quotaBytes(),
+        ScannedAt:  fs.usage.scannedAt,
+    }
+    if total, ok := fs.usage.totals[userID]; ok {
+        usage.UsedBytes = total.bytes
+        usage.Files = total.files
+    }
+    return usage
+}
+
+// AllUsage returns the storage attributed to every user owning files,
+// largest consumers first.
+func (fs *FileService) AllUsage() []models.StorageUsage {
+    fs.usage.mu.Lock()
+    ids := make([]string, 0, len(fs.usage.totals))
+    for id := range fs.usage.totals {
+        ids = append(ids, id)
+    }
+    fs.usage.mu.Unlock()
+
+    usage := make([]models.StorageUsage, 0, len(ids))
+    for _, id := range ids {
+        usage = append(usage, fs.Usage(id))
+    }
+    sort.Slice(usage, func(i, j int) bool {
+        if usage[i].UsedBytes != usage[j].UsedBytes {
+            return usage[i].UsedBytes > usage[j].UsedBytes
+        }
+        return usage[i].UserID < usage[j].UserID
+    })
+    return usage
+}
+
+// RescanUsage walks every share and rebuilds the per-user totals from disk,
+// picking up changes made outside the dashboard and dropping ownership
+// records of files that no longer exist. Changes made through the dashboard
+// while it runs are kept.
+func (fs *FileService) RescanUsage() {
+    fs.usage.scanMu.Lock()
+    defer fs.usage.scanMu.Unlock()
+    scan := &usageScan{done: make(map[string]bool), pending: make(map[string]*usageTotal)}
+    fs.usage.mu.Lock()
+    fs.usage.scan = scan
+    fs.usage.mu.Unlock()
+
+    totals := make(map[string]*usageTotal)
+    for i := range fs.roots {
+        root := &fs.roots[i]
+        fs.usage.mu.Lock()
+        scan.root, scan.at = root.Path, ""
+        owners := make(map[string]string)
+        for rel, owner := range fs.usage.shareOwners(root) {
+            owners[rel] = owner
+        }
+        fs.usage.mu.Unlock()
+
+        filepath.Walk(root.Path, func(abs string, info os.FileInfo, err error) error {
+            if err != nil {
+                return nil
+            }
+            rel, err := filepath.Rel(root.Path, abs)
+            if err != nil {
+                return nil
+            }
+            rel = filepath.ToSlash(rel)
+            if isInternal(rel) {
+                return filepath.SkipDir
+            }
+            if rel != "." {
+                fs.usage.mu.Lock()
+                scan.at = rel
+                fs.usage.mu.Unlock()
+            }
+            if !info.Mode().IsRegular() {
+                return nil
+            }
+            for p := rel; p != "." && p != ""; p = path.Dir(p) {
+                if owner, ok := owners[p]; ok {
+                    addUsage(totals, owner, info.Size(), 1)
+                    break
+                }
+            }
+            return nil
+        })
+
+        fs.usage.mu.Lock()
+        scan.done[root.Path] = true
+        current := fs.usage.shareOwners(root)
+        changed := false
+        for rel := range current {
+            if _, err := os.Lstat(filepath.Join(root.Path, filepath.FromSlash(rel))); os.IsNotExist(err) {
+                delete(current, rel)
+                changed = true
+            }
+        }
+        if changed {
+            fs.usage.save(root)
+        }
+        fs.usage.mu.Unlock()
+    }
+
+    fs.usage.mu.Lock()
+    for owner, change := range scan.pending {
+        addUsage(totals, owner, change.bytes, change.files)
+    }
+    fs.usage.totals = totals
+    fs.usage.scan = nil
+    fs.usage.scannedAt = time.Now()
+    fs.usage.mu.Unlock()
+}
+
+// StartUsageRescan reconciles usage now and then every interval in the
+// background, or sooner when a change could not be accounted for directly.
+func (fs *FileService) StartUsageRescan(interval time.Duration) {
+    go func() {
+        for {
+            fs.RescanUsage()
+            select {
+            case <-time.After(interval):
+            case <-fs.usage.rescan:
+            }
+        }
+    }()
+}
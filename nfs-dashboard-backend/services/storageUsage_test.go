@@ -0,0 +1,143 @@
+package services
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+// useQuota attributes bytes to owner without writing them, leaving left
+// bytes of a 1 GiB quota.
+func useQuota(fs *FileService, owner string, left int64) {
+    used := fs.usage.used(owner)
+    fs.usage.mu.Lock()
+    defer fs.usage.mu.Unlock()
+    addUsage(fs.usage.totals, owner, 1<<30-left-used, 0)
+}
+
+func TestUploadQuota(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{MaxStoragePerUser: 1})
+    if _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader("0123456789"), nil); err != nil {
+        t.Fatal(err)
+    }
+    useQuota(fs, "2", 5)
+
+    if _, err := fs.UploadFile("/data/docs", "big.txt", "2", strings.NewReader("012345"), nil); err != ErrQuotaExceeded {
+        t.Errorf("upload over quota: error = %v, want %v", err, ErrQuotaExceeded)
+    }
+    if _, err := fs.UploadFile("/data/docs", "small.txt", "2", strings.NewReader("01234"), nil); err != nil {
+        t.Errorf("upload within quota: %v", err)
+    }
+    // Replacing their own file frees its size first.
+    if _, err := fs.UploadFile("/data/docs", "plan.txt", "2", strings.NewReader("0123456789"), nil); err != nil {
+        t.Errorf("overwrite of an own file: %v", err)
+    }
+    // Other users are not affected.
+    if _, err := fs.UploadFile("/data/docs", "other.txt", "3", strings.NewReader("0123456789"), nil); err != nil {
+        t.Errorf("upload by another user: %v", err)
+    }
+    if err := fs.CheckQuota("2", 1); err != ErrQuotaExceeded {
+        t.Errorf("CheckQuota() on a full quota = %v, want %v", err, ErrQuotaExceeded)
+    }
+    if usage := fs.Usage("2"); usage.QuotaBytes != 1<<30 || usage.UsedBytes != 1<<30 {
+        t.Errorf("usage = %d of %d, want a full quota", usage.UsedBytes, usage.QuotaBytes)
+    }
+}
+
+func TestCopyQuota(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{MaxStoragePerUser: 1})
+    if _, err := fs.CreateFolder("/data", "mine", "2"); err != nil {
+        t.Fatal(err)
+    }
+    useQuota(fs, "2", 0)
+    useQuota(fs, "3", 5)
+
+    // Copies count against the user making them, not the owner of the
+    // destination folder.
+    if _, err := fs.CopyItem("/data/docs/readme.txt", "/data/mine", ConflictFail, "3"); err != nil {
+        t.Fatalf("copy within quota: %v", err)
+    }
+    if used := fs.Usage("3").UsedBytes; used != 1<<30 {
+        t.Errorf("usage of the copier = %d, want %d", used, 1<<30)
+    }
+    if used := fs.Usage("2").UsedBytes; used != 1<<30 {
+        t.Errorf("usage of the folder owner = %d, want it unchanged", used)
+    }
+    if _, err := fs.CopyItem("/data/docs/readme.txt", "/data/mine", ConflictRename, "3"); err != ErrQuotaExceeded {
+        t.Errorf("copy over quota: error = %v, want %v", err, ErrQuotaExceeded)
+    }
+    if _, err := fs.CopyItem("/data/docs/readme.txt", "/data/mine", ConflictOverwrite, "3"); err != nil {
+        t.Errorf("copy replacing an own file of the same size: %v", err)
+    }
+}
+
+func TestCopyQuotaIntoUnownedFolder(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{MaxStoragePerUser: 1})
+    if err := os.Mkdir(diskPath(fs, "shared"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    useQuota(fs, "3", 0)
+
+    for _, dir := range []string{"/data", "/data/shared"} {
+        if _, err := fs.CopyItem("/data/docs/readme.txt", dir, ConflictFail, "3"); err != ErrQuotaExceeded {
+            t.Errorf("copy into %s over quota: error = %v, want %v", dir, err, ErrQuotaExceeded)
+        }
+    }
+    if _, err := os.Stat(diskPath(fs, "shared/readme.txt")); !os.IsNotExist(err) {
+        t.Error("the copy was made")
+    }
+}
+
+func TestRestoreQuota(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{MaxStoragePerUser: 1})
+    if _, err := fs.CreateFolder("/data", "proj", "2"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.CreateFolder("/data/proj", "sub", "2"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.UploadFile("/data/proj/sub", "mine.txt", "2", strings.NewReader("0123456789"), nil); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.UploadFile("/data/proj/sub", "theirs.txt", "3", strings.NewReader("0123456789"), nil); err != nil {
+        t.Fatal(err)
+    }
+    sub, err := fs.DeleteItem("/data/proj/sub", "2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := fs.DeleteItem("/data/proj", "2"); err != nil {
+        t.Fatal(err)
+    }
+    useQuota(fs, "3", 5)
+
+    // The restore is refused for the owner of one file, whoever restores it,
+    // and leaves no parent folders behind.
+    if _, err := fs.RestoreTrashItem(sub.ID, ConflictFail, "2"); err != ErrQuotaExceeded {
+        t.Errorf("restore over an owner's quota: error = %v, want %v", err, ErrQuotaExceeded)
+    }
+    if _, err := os.Lstat(diskPath(fs, "proj")); !os.IsNotExist(err) {
+        t.Error("a refused restore created the parent folders")
+    }
+    useQuota(fs, "3", 10)
+    if _, err := fs.RestoreTrashItem(sub.ID, ConflictFail, "2"); err != nil {
+        t.Errorf("restore within quota: %v", err)
+    }
+}
+
+func TestRescanAttributesFilesToFolderOwner(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    if _, err := fs.CreateFolder("/data", "mine", "2"); err != nil {
+        t.Fatal(err)
+    }
+    // Files added outside the dashboard belong to the folder's owner.
+    writeTestFile(t, fs, "mine/sub/added.txt", "0123456789")
+    fs.RescanUsage()
+    if usage := fs.Usage("2"); usage.UsedBytes != 10 || usage.Files != 1 {
+        t.Errorf("usage = %d bytes in %d files, want 10 in 1", usage.UsedBytes, usage.Files)
+    }
+    if usage := fs.Usage(""); usage.UsedBytes != 0 {
+        t.Errorf("unowned files counted as %d bytes", usage.UsedBytes)
+    }
+}
@@ -0,0 +1,361 @@
+package services
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "image"
+    "image/color"
+    "image/gif"
+    "image/jpeg"
+    "image/png"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+var (
+    // ErrThumbnailUnsupported is returned for files that are not JPEG, PNG or GIF images.
+    ErrThumbnailUnsupported = errors.New("thumbnails are only available for JPEG, PNG and GIF images")
+    // ErrImageTooLarge is returned for images too large to decode safely.
+    ErrImageTooLarge = errors.New("image is too large for a thumbnail")
+)
+
+const (
+    // DefaultThumbnailSize is used when no size is requested.
+    DefaultThumbnailSize = 256
+    // maxThumbnailSource bounds the file size of images that are decoded.
+    maxThumbnailSource = 64 << 20
+    // maxThumbnailPixels bounds the decoded size of an image, which can be far
+    // larger than its file.
+    maxThumbnailPixels = 50000000
+)
+
+// thumbnailSizes are the edge lengths thumbnails are rendered at; requested
+// sizes are rounded up to one of them so the cache stays small.
+var thumbnailSizes = []int{64, 128, 256, 512, 1024}
+
+// Thumbnail identifies a cached thumbnail. ETag changes whenever the source
+// file does, so clients may cache thumbnails indefinitely.
+type Thumbnail struct {
+    Path        string
+    Size        int
+    ETag        string
+    ContentType string
+    key         string
+}
+
+// ThumbnailService renders small previews of images and caches them on local
+// disk, since reading full images over NFS for every listing is slow.
+type ThumbnailService struct {
+    files    *FileService
+    cacheDir string
+    slots    chan struct{}
+
+    mu       sync.Mutex
+    inflight map[string]*thumbnailRender
+}
+
+// thumbnailRender is a render in progress, which requests for the same
+// thumbnail wait for and share the outcome of.
+type thumbnailRender struct {
+    done chan struct{}
+    err  error // set before done is closed
+}
+
+// NewThumbnailService creates a ThumbnailService caching in cacheDir and
+// rendering at most maxConcurrent thumbnails at a time. The cache must not
+// overlap a share: clients could read or replace cached thumbnails there, and
+// pruning the cache would delete their files.
+func NewThumbnailService(files *FileService, cacheDir string, maxConcurrent int) (*ThumbnailService, error) {
+    abs, err := filepath.Abs(cacheDir)
+    if err != nil {
+        return nil, err
+    }
+    if err := checkOutsideShares(files.roots, abs); err != nil {
+        return nil, err
+    }
+    if err := os.MkdirAll(abs, 0700); err != nil {
+        return nil, err
+    }
+    // Check again once symlinks in the path can be resolved.
+    real, err := filepath.EvalSymlinks(abs)
+    if err != nil {
+        return nil, err
+    }
+    if err := checkOutsideShares(files.roots, real); err != nil {
+        return nil, err
+    }
+    if maxConcurrent < 1 {
+        maxConcurrent = 1
+    }
+    return &ThumbnailService{
+        files:    files,
+        cacheDir: real,
+        slots:    make(chan struct{}, maxConcurrent),
+        inflight: make(map[string]*thumbnailRender),
+    }, nil
+}
+
+// checkOutsideShares returns an error if dir lies inside a share root or
+// holds one.
+func checkOutsideShares(roots []ShareRoot, dir string) error {
+    for _, root := range roots {
+        if isWithin(root.Path, dir) || isWithin(dir, root.Path) {
+            return fmt.Errorf("thumbnail cache %s overlaps share %q at %s", dir, root.Name, root.Path)
+        }
+    }
+    return nil
+}
+
+// thumbnailSize rounds a requested size up to a rendered size.
+func thumbnailSize(requested int) int {
+    if requested <= 0 {
+        return DefaultThumbnailSize
+    }
+    for _, size := range thumbnailSizes {
+        if requested <= size {
+            return size
+        }
+    }
+    return thumbnailSizes[len(thumbnailSizes)-1]
+}
+
+// thumbnailType returns the MIME type of the thumbnail for a source file
+// name, or "" if no thumbnail can be made. PNG and GIF sources become PNG
+// thumbnails to keep transparency.
+func thumbnailType(name string) string {
+    switch strings.ToLower(filepath.Ext(name)) {
+    case ".jpg", ".jpeg":
+        return "image/jpeg"
+    case ".png", ".gif":
+        return "image/png"
+    }
+    return ""
+}
+
+// Lookup describes the thumbnail of p at the given size without rendering
+// it, so unchanged thumbnails can be answered with 304 cheaply.
+func (ts *ThumbnailService) Lookup(p string, size int) (*Thumbnail, error) {
+    info, err := ts.files.StatFile(p)
+    if err != nil {
+        return nil, err
+    }
+    contentType := thumbnailType(info.Name)
+    if info.IsDir || contentType == "" {
+        return nil, ErrThumbnailUnsupported
+    }
+    if info.Size > maxThumbnailSource {
+        return nil, ErrImageTooLarge
+    }
+    size = thumbnailSize(size)
+    sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", info.Path, info.LastModified.UnixNano(), info.Size, size)))
+    key := hex.EncodeToString(sum[:])
+    return &Thumbnail{
+        Path:        info.Path,
+        Size:        size,
+        ETag:        `"` + key[:32] + `"`,
+        ContentType: contentType,
+        key:         key,
+    }, nil
+}
+
+// Open returns the cached thumbnail, rendering it first if needed. The
+// caller must close it.
+func (ts *ThumbnailService) Open(thumb *Thumbnail) (*os.File, error) {
+    cached := filepath.Join(ts.cacheDir, thumb.key[:2], thumb.key)
+    if f, err := os.Open(cached); err == nil {
+        now := time.Now()
+        os.Chtimes(cached, now, now)
+        return f, nil
+    }
+
+    // Render each thumbnail once even if many clients ask for it together.
+    ts.mu.Lock()
+    if running, ok := ts.inflight[thumb.key]; ok {
+        ts.mu.Unlock()
+        <-running.done
+        if running.err != nil {
+            return nil, running.err
+        }
+        return os.Open(cached)
+    }
+    running := &thumbnailRender{done: make(chan struct{})}
+    ts.inflight[thumb.key] = running
+    ts.mu.Unlock()
+    defer func() {
+        ts.mu.Lock()
+        delete(ts.inflight, thumb.key)
+        ts.mu.Unlock()
+        close(running.done)
+    }()
+
+    ts.slots <- struct{}{}
+    running.err = ts.render(thumb, cached)
+    <-ts.slots
+    if running.err != nil {
+        return nil, running.err
+    }
+    return os.Open(cached)
+}
+
+// render decodes the source image and writes the thumbnail atomically.
+func (ts *ThumbnailService) render(thumb *Thumbnail, cached string) error {
+    src, _, err := ts.files.OpenFile(thumb.Path)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+    img, err := decodeImage(src)
+    if err != nil {
+        return err
+    }
+    scaled := scaleImage(img, thumb.Size)
+
+    if err := os.MkdirAll(filepath.Dir(cached), 0700); err != nil {
+        return err
+    }
+    tmp := cached + ".tmp"
+    out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+    if err != nil {
+        return err
+    }
+    if thumb.ContentType == "image/jpeg" {
+        err = jpeg.Encode(out, scaled, &jpeg.Options{Quality: 85})
+    } else {
+        err = png.Encode(out, scaled)
+    }
+    if closeErr := out.Close(); err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return os.Rename(tmp, cached)
+}
+
+// decodeImage decodes a JPEG, PNG or GIF after checking its dimensions, so
+// a small file declaring a huge canvas is refused before allocating it.
+// Only the first frame of an animated GIF is used.
+func decodeImage(r io.Reader) (image.Image, error) {
+    head := &recordingReader{r: r}
+    config, format, err := image.DecodeConfig(head)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrThumbnailUnsupported, err)
+    }
+    if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxThumbnailPixels {
+        return nil, ErrImageTooLarge
+    }
+    full := io.MultiReader(bytes.NewReader(head.buf), r)
+    var img image.Image
+    switch format {
+    case "jpeg":
+        img, err = jpeg.Decode(full)
+    case "png":
+        img, err = png.Decode(full)
+    case "gif":
+        img, err = gif.Decode(full)
+    default:
+        return nil, ErrThumbnailUnsupported
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrThumbnailUnsupported, err)
+    }
+    return img, nil
+}
+
+// recordingReader keeps what was read so decoding can restart from the
+// beginning of a stream that cannot seek.
+type recordingReader struct {
+    r   io.Reader
+    buf []byte
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+    n, err := rr.r.Read(p)
+    rr.buf = append(rr.buf, p[:n]...)
+    return n, err
+}
+
+// scaleImage shrinks img to fit within size x size by averaging the source
+// pixels that fall into each target pixel, sampling at most a few per axis
+// so huge photos stay cheap. Smaller images are not enlarged.
+func scaleImage(img image.Image, size int) image.Image {
+    bounds := img.Bounds()
+    w, h := bounds.Dx(), bounds.Dy()
+    if w <= size && h <= size {
+        return img
+    }
+    tw, th := size, size
+    if w > h {
+        th = max1(h * size / w)
+    } else {
+        tw = max1(w * size / h)
+    }
+
+    dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+    for ty := 0; ty < th; ty++ {
+        y0, y1 := ty*h/th, (ty+1)*h/th
+        for tx := 0; tx < tw; tx++ {
+            x0, x1 := tx*w/tw, (tx+1)*w/tw
+            // Colours from RGBA are alpha-premultiplied, so transparent
+            // pixels do not darken the average.
+            var r, g, b, a, n uint64
+            for y := y0; y < y1; y += max1((y1 - y0) / 4) {
+                for x := x0; x < x1; x += max1((x1 - x0) / 4) {
+                    cr, cg, cb, ca := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+                    r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+                    n++
+                }
+            }
+            if a == 0 {
+                continue
+            }
+            dst.SetNRGBA(tx, ty, color.NRGBA{
+                R: uint8(r * 0xffff / a >> 8),
+                G: uint8(g * 0xffff / a >> 8),
+                B: uint8(b * 0xffff / a >> 8),
+                A: uint8(a / n >> 8),
+            })
+        }
+    }
+    return dst
+}
+
+func max1(v int) int {
+    if v < 1 {
+        return 1
+    }
+    return v
+}
+
+// PruneCache removes thumbnails not used within maxAge, which covers those
+// of files that have since changed or been deleted.
+func (ts *ThumbnailService) PruneCache(maxAge time.Duration) {
+    cutoff := time.Now().Add(-maxAge)
+    filepath.Walk(ts.cacheDir, func(p string, info os.FileInfo, err error) error {
+        if err == nil && !info.IsDir() && info.ModTime().Before(cutoff) {
+            if err := os.Remove(p); err != nil {
+                log.Printf("Failed to prune thumbnail %s: %v", p, err)
+            }
+        }
+        return nil
+    })
+}
+
+// StartCachePrune prunes the thumbnail cache every interval in the background.
+func (ts *ThumbnailService) StartCachePrune(interval, maxAge time.Duration) {
+    go func() {
+        for {
+            ts.PruneCache(maxAge)
+            time.Sleep(interval)
+        }
+    }()
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestThumbnailRenderErrors(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    ts, err := NewThumbnailService(fs, t.TempDir(), 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    writeTestFile(t, fs, "docs/broken.png", "not an image")
+    thumb, err := ts.Lookup("/data/docs/broken.png", 100)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := ts.Open(thumb); !errors.Is(err, ErrThumbnailUnsupported) {
+        t.Errorf("Open() error = %v, want %v", err, ErrThumbnailUnsupported)
+    }
+
+    // Requests that waited for a failed render get its error, not a
+    // missing cache file.
+    failed := &thumbnailRender{done: make(chan struct{}), err: ErrImageTooLarge}
+    close(failed.done)
+    ts.inflight[thumb.key] = failed
+    if _, err := ts.Open(thumb); err != ErrImageTooLarge {
+        t.Errorf("Open() while a render failed: error = %v, want %v", err, ErrImageTooLarge)
+    }
+}
+
+func TestThumbnailCacheOutsideShares(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    outside := t.TempDir()
+    link := filepath.Join(outside, "link")
+    if err := os.Symlink(diskPath(fs, "docs"), link); err != nil {
+        t.Fatal(err)
+    }
+    tests := []struct {
+        name string
+        dir  string
+        ok   bool
+    }{
+        {"outside", filepath.Join(outside, "cache"), true},
+        {"inside a share", diskPath(fs, "cache"), false},
+        {"the share itself", diskPath(fs, ""), false},
+        {"holding a share", filepath.Dir(diskPath(fs, "")), false},
+        {"through a symlink into a share", filepath.Join(link, "cache"), false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := NewThumbnailService(fs, tt.dir, 1)
+            if (err == nil) != tt.ok {
+                t.Errorf("NewThumbnailService(%s) error = %v, want ok %v", tt.dir, err, tt.ok)
+            }
+        })
+    }
+    if _, err := os.Stat(diskPath(fs, "cache")); err == nil {
+        t.Error("a refused cache folder was created inside the share")
+    }
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+    "errors"
+    "mime"
+    "net/http"
+    "path/filepath"
+    "sort"
+    "strings"
+    "nfs-dashboard-backend/models"
+)
+
+var (
+    // ErrFileTooLarge is returned when an upload exceeds SystemSettings.MaxFileSize.
+    ErrFileTooLarge = errors.New("file exceeds the maximum upload size")
+    // ErrFileTypeNotAllowed is returned when an extension is not in
+    // SystemSettings.AllowedFileTypes or the content does not match it.
+    ErrFileTypeNotAllowed = errors.New("file type is not allowed")
+)
+
+// sniffLen is how many leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// SettingsProvider supplies the live system settings.
+type SettingsProvider interface {
+    GetSystemSettings() (*models.SystemSettings, error)
+}
+
+// AuditRecorder records entries in the audit log.
+type AuditRecorder interface {
+    RecordAuditLog(action, userID, details string)
+}
+
+// UploadPolicy is the set of upload restrictions derived from the system settings.
+type UploadPolicy struct {
+    MaxBytes   int64           // 0 means unlimited
+    Extensions map[string]bool // empty means every type is allowed
+}
+
+// NewUploadPolicy builds a policy from settings. MaxFileSize is in megabytes
+// and AllowedFileTypes is a comma-separated list such as ".jpg,.pdf".
+func NewUploadPolicy(settings *models.SystemSettings) UploadPolicy {
+    policy := UploadPolicy{Extensions: make(map[string]bool)}
+    if settings == nil {
+        return policy
+    }
+    if settings.MaxFileSize > 0 {
+        policy.MaxBytes = int64(settings.MaxFileSize) << 20
+    }
+    for _, ext := range strings.Split(settings.AllowedFileTypes, ",") {
+        ext = strings.ToLower(strings.TrimSpace(ext))
+        if ext == "*" {
+            policy.Extensions = make(map[string]bool)
+            break
+        }
+        if ext == "" {
+            continue
+        }
+        if !strings.HasPrefix(ext, ".") {
+            ext = "." + ext
+        }
+        policy.Extensions[ext] = true
+    }
+    return policy
+}
+
+// CurrentUploadPolicy reads the live settings into an UploadPolicy. A nil
+// provider yields an unrestricted policy.
+func CurrentUploadPolicy(settings SettingsProvider) (UploadPolicy, error) {
+    if settings == nil {
+        return NewUploadPolicy(nil), nil
+    }
+    current, err := settings.GetSystemSettings()
+    if err != nil {
+        return UploadPolicy{}, err
+    }
+    return NewUploadPolicy(current), nil
+}
+
+// CheckSize rejects sizes above the limit.
+func (p UploadPolicy) CheckSize(size int64) error {
+    if p.MaxBytes > 0 && size > p.MaxBytes {
+        return ErrFileTooLarge
+    }
+    return nil
+}
+
+// CheckName rejects file names whose extension is not allowed.
+func (p UploadPolicy) CheckName(name string) error {
+    if len(p.Extensions) == 0 {
+        return nil
+    }
+    if !p.Extensions[strings.ToLower(filepath.Ext(name))] {
+        return ErrFileTypeNotAllowed
+    }
+    return nil
+}
+
+// AllowedTypes lists the extensions the policy accepts, or nil if every
+// type is allowed.
+func (p UploadPolicy) AllowedTypes() []string {
+    var types []string
+    for ext := range p.Extensions {
+        types = append(types, ext)
+    }
+    sort.Strings(types)
+    return types
+}
+
+// CheckContent rejects files whose leading bytes clearly contradict their
+// extension, e.g. an executable renamed to ".jpg".
+func (p UploadPolicy) CheckContent(name string, head []byte) error {
+    if len(p.Extensions) == 0 || len(head) == 0 {
+        return nil
+    }
+    if !contentMatchesExtension(filepath.Ext(name), http.DetectContentType(head)) {
+        return ErrFileTypeNotAllowed
+    }
+    return nil
+}
+
+// zipContainers are extensions whose files are zip archives underneath.
+var zipContainers = map[string]bool{
+    ".docx": true, ".xlsx": true, ".pptx": true, ".docm": true, ".xlsm": true,
+    ".pptm": true, ".odt": true, ".ods": true, ".odp": true, ".odg": true,
+    ".jar": true, ".war": true, ".apk": true, ".epub": true, ".cbz": true,
+    ".zip": true,
+}
+
+// markupExtensions are text formats whose content may start out like HTML
+// or XML, which is all sniffing can tell of them.
+var markupExtensions = map[string]bool{
+    ".html": true, ".htm": true, ".xhtml": true, ".xml": true, ".xsl": true,
+    ".xslt": true, ".svg": true, ".rss": true, ".atom": true, ".txt": true,
+    ".md": true, ".markdown": true, ".csv": true, ".log": true,
+}
+
+// signatureTypes are types http.DetectContentType always recognises, so a
+// file claiming one of them must carry its signature.
+var signatureTypes = map[string]bool{
+    "image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true,
+    "image/bmp": true, "application/pdf": true,
+}
+
+// sniffedExtensions lists, for each type http.DetectContentType reports from
+// a signature, the extensions such files carry. It is kept here rather than
+// looked up in the host's MIME database, which may lack an extension or name
+// its type differently, e.g. application/gzip where sniffing reports
+// application/x-gzip.
+var sniffedExtensions = map[string][]string{
+    "image/jpeg":                    {".jpg", ".jpeg", ".jpe", ".jfif"},
+    "image/png":                     {".png"},
+    "image/gif":                     {".gif"},
+    "image/webp":                    {".webp"},
+    "image/bmp":                     {".bmp", ".dib"},
+    "image/x-icon":                  {".ico", ".cur"},
+    "application/pdf":               {".pdf", ".ai"},
+    "application/postscript":        {".ps", ".eps", ".ai"},
+    "application/x-gzip":            {".gz", ".tgz", ".gzip", ".svgz"},
+    "application/x-rar-compressed":  {".rar", ".cbr"},
+    "application/wasm":              {".wasm"},
+    "application/vnd.ms-fontobject": {".eot"},
+    "application/ogg":               {".ogg", ".oga", ".ogv", ".ogx", ".opus", ".spx"},
+    "audio/mpeg":                    {".mp3"},
+    "audio/wave":                    {".wav"},
+    "audio/aiff":                    {".aif", ".aiff", ".aifc"},
+    "audio/basic":                   {".au", ".snd"},
+    "audio/midi":                    {".mid", ".midi"},
+    "video/avi":                     {".avi"},
+    "video/mp4":                     {".mp4", ".m4v", ".m4a", ".m4b", ".mov", ".3gp"},
+    "video/webm":                    {".webm", ".mkv", ".mka"},
+    "font/ttf":                      {".ttf"},
+    "font/otf":                      {".otf"},
+    "font/collection":               {".ttc"},
+    "font/woff":                     {".woff"},
+    "font/woff2":                    {".woff2"},
+}
+
+// extensionSniffedTypes inverts sniffedExtensions.
+var extensionSniffedTypes = func() map[string][]string {
+    types := make(map[string][]string)
+    for sniffed, exts := range sniffedExtensions {
+        for _, ext := range exts {
+            types[ext] = append(types[ext], sniffed)
+        }
+    }
+    return types
+}()
+
+// contentMatchesExtension reports whether a sniffed type is plausible for ext.
+// Generic results such as application/octet-stream are accepted unless ext
+// names a format with a well-known signature.
+func contentMatchesExtension(ext, sniffed string) bool {
+    sniffedType, _, _ := mime.ParseMediaType(sniffed)
+    ext = strings.ToLower(ext)
+    expected := extensionSniffedTypes[ext]
+    switch sniffedType {
+    case "application/octet-stream", "text/plain":
+        for _, t := range expected {
+            if signatureTypes[t] {
+                return false
+            }
+        }
+        return true
+    case "application/zip":
+        return zipContainers[ext]
+    case "text/html", "text/xml":
+        return markupExtensions[ext]
+    }
+    family := strings.SplitN(sniffedType, "/", 2)[0]
+    for _, t := range expected {
+        if t == sniffedType {
+            return true
+        }
+        // Same family is close enough, e.g. PNG content named ".jpg", but
+        // application types are unrelated formats.
+        if family != "application" && strings.SplitN(t, "/", 2)[0] == family {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+    "net/http"
+    "path/filepath"
+    "testing"
+    "nfs-dashboard-backend/models"
+)
+
+func TestContentMatchesExtension(t *testing.T) {
+    var (
+        gzip = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\x03")
+        rar  = []byte("Rar!\x1a\x07\x00\xcf\x90\x73\x00\x00\x0d")
+        zip  = []byte("PK\x03\x04\x14\x00\x06\x00\x08\x00")
+        ole  = []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1\x00\x00\x00\x00")
+        pdf  = []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+        png  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+        jpeg = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+        exe  = []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff")
+        html = []byte("<!DOCTYPE html><html><body></body></html>")
+        text = []byte("plain notes\n")
+    )
+    tests := []struct {
+        name string
+        file string
+        head []byte
+        want bool
+    }{
+        {"gzip", "logs.gz", gzip, true},
+        {"gzipped tarball", "backup.tgz", gzip, true},
+        {"gzip upper-case extension", "LOGS.GZ", gzip, true},
+        {"rar", "photos.rar", rar, true},
+        {"zip", "photos.zip", zip, true},
+        {"docx", "report.docx", zip, true},
+        {"xlsx", "budget.xlsx", zip, true},
+        {"pptx", "slides.pptx", zip, true},
+        {"odt", "letter.odt", zip, true},
+        {"legacy doc", "report.doc", ole, true},
+        {"legacy xls", "budget.xls", ole, true},
+        {"pdf", "plan.pdf", pdf, true},
+        {"text", "notes.txt", text, true},
+        {"html as text", "page.txt", html, true},
+        {"png named jpg", "photo.jpg", png, true},
+        {"jpeg", "photo.jpeg", jpeg, true},
+        {"executable named jpg", "photo.jpg", exe, false},
+        {"executable named pdf", "plan.pdf", exe, false},
+        {"zip named pdf", "plan.pdf", zip, false},
+        {"zip named gz", "logs.gz", zip, false},
+        {"gzip named docx", "report.docx", gzip, false},
+        {"rar named zip", "photos.zip", rar, false},
+        {"html named png", "photo.png", html, false},
+        {"pdf named png", "photo.png", pdf, false},
+        {"png named gz", "logs.gz", png, false},
+        {"gzip named unknown extension", "data.bin", gzip, false},
+        {"text named unknown extension", "data.bin", text, true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            sniffed := http.DetectContentType(tt.head)
+            ext := filepath.Ext(tt.file)
+            if got := contentMatchesExtension(ext, sniffed); got != tt.want {
+                t.Errorf("contentMatchesExtension(%q, %q) = %v, want %v", ext, sniffed, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestCheckContent(t *testing.T) {
+    policy := NewUploadPolicy(&models.SystemSettings{AllowedFileTypes: ".gz,.rar,.docx,.doc,.pdf"})
+    tests := []struct {
+        file string
+        head []byte
+        want error
+    }{
+        {"logs.gz", []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00"), nil},
+        {"photos.rar", []byte("Rar!\x1a\x07\x00"), nil},
+        {"report.docx", []byte("PK\x03\x04\x14\x00\x06\x00"), nil},
+        {"report.doc", []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1"), nil},
+        {"plan.pdf", []byte("MZ\x90\x00\x03\x00\x00\x00"), ErrFileTypeNotAllowed},
+        {"empty.pdf", nil, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.file, func(t *testing.T) {
+            if err := policy.CheckContent(tt.file, tt.head); err != tt.want {
+                t.Errorf("CheckContent(%q) = %v, want %v", tt.file, err, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,406 @@
+package services
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+    "github.com/google/uuid"
+    "nfs-dashboard-backend/models"
+)
+
+// uploadsDir is the folder inside each share's internal area holding partial uploads.
+const uploadsDir = "uploads"
+
+// DefaultUploadExpiry is how long an untouched partial upload is kept.
+const DefaultUploadExpiry = 24 * time.Hour
+
+var (
+    // ErrUploadNotFound is returned for unknown or expired upload IDs.
+    ErrUploadNotFound = errors.New("upload not found")
+    // ErrOffsetMismatch is returned when a chunk does not start at the current offset.
+    ErrOffsetMismatch = errors.New("upload offset does not match")
+    // ErrUploadBusy is returned when another chunk for the same upload is in flight.
+    ErrUploadBusy = errors.New("upload is already receiving data")
+)
+
+// UploadService implements resumable uploads in the style of the tus protocol.
+// Partial data is staged in the internal area of the target share, next to a
+// JSON record of its offset, so uploads survive restarts and the final move
+// into the target folder is a rename on the same filesystem.
+type UploadService struct {
+    files  *FileService
+    expiry time.Duration
+
+    mu     sync.Mutex
+    active map[string]bool
+}
+
+// NewUploadService creates an UploadService that drops partial uploads
+// untouched for longer than expiry.
+func NewUploadService(files *FileService, expiry time.Duration) *UploadService {
+    if expiry <= 0 {
+        expiry = DefaultUploadExpiry
+    }
+    return &UploadService{
+        files:  files,
+        expiry: expiry,
+        active: make(map[string]bool),
+    }
+}
+
+// Expiry returns how long an untouched upload is kept.
+func (us *UploadService) Expiry() time.Duration {
+    return us.expiry
+}
+
+// ParseUploadMetadata decodes a tus Upload-Metadata header
+// ("key base64value,key base64value").
+func ParseUploadMetadata(header string) (map[string]string, error) {
+    meta := make(map[string]string)
+    for _, pair := range strings.Split(header, ",") {
+        pair = strings.TrimSpace(pair)
+        if pair == "" {
+            continue
+        }
+        parts := strings.SplitN(pair, " ", 2)
+        value := ""
+        if len(parts) == 2 {
+            decoded, err := base64.StdEncoding.DecodeString(parts[1])
+            if err != nil {
+                return nil, fmt.Errorf("invalid metadata value for %q", parts[0])
+            }
+            value = string(decoded)
+        }
+        meta[parts[0]] = value
+    }
+    return meta, nil
+}
+
+// Create starts a new upload of length bytes for filename into folder p.
+func (us *UploadService) Create(p, filename string, length int64, owner string) (*models.Upload, error) {
+    if length < 0 {
+        return nil, errors.New("invalid upload length")
+    }
+    dir, err := us.files.resolve(p)
+    if err != nil {
+        return nil, err
+    }
+    info, err := os.Stat(dir.abs)
+    if err != nil {
+        return nil, err
+    }
+    if !info.IsDir() {
+        return nil, errors.New("provided path is not a directory")
+    }
+    dest, err := dir.child(filename)
+    if err != nil {
+        return nil, err
+    }
+    policy, err := us.files.UploadPolicy()
+    if err != nil {
+        return nil, err
+    }
+    if err := policy.CheckSize(length); err != nil {
+        return nil, err
+    }
+    if err := policy.CheckName(dest.abs); err != nil {
+        return nil, err
+    }
+    if err := us.files.CheckQuota(owner, length); err != nil {
+        return nil, err
+    }
+    if err := us.files.checkUnlocked(dest, owner); err != nil {
+        return nil, err
+    }
+
+    staging, err := dir.root.internalDir(uploadsDir)
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    upload := &models.Upload{
+        ID:        uuid.New().String(),
+        Path:      dir.Virtual(),
+        Filename:  filename,
+        Length:    length,
+        Owner:     owner,
+        CreatedAt: now,
+        UpdatedAt: now,
+    }
+    part, err := os.OpenFile(filepath.Join(staging, upload.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return nil, err
+    }
+    part.Close()
+    if err := writeUploadRecord(staging, upload); err != nil {
+        os.Remove(filepath.Join(staging, upload.ID))
+        return nil, err
+    }
+    return upload, nil
+}
+
+// Get returns the state of an upload.
+func (us *UploadService) Get(id string) (*models.Upload, error) {
+    _, upload, err := us.find(id)
+    return upload, err
+}
+
+// ExpiresAt returns when an upload will be discarded if left untouched.
+func (us *UploadService) ExpiresAt(upload *models.Upload) time.Time {
+    return upload.UpdatedAt.Add(us.expiry)
+}
+
+// Append writes a chunk starting at offset. When the upload is complete the
+// file is moved into its target folder and returned; otherwise the returned
+// file is nil.
+func (us *UploadService) Append(id string, offset int64, chunk io.Reader) (*models.Upload, *models.File, error) {
+    if !us.acquire(id) {
+        return nil, nil, ErrUploadBusy
+    }
+    defer us.release(id)
+
+    staging, upload, err := us.find(id)
+    if err != nil {
+        return nil, nil, err
+    }
+    if offset != upload.Offset {
+        return upload, nil, ErrOffsetMismatch
+    }
+    // Chunks for a locked file are refused but the upload is kept, so it
+    // can resume once the lock is gone.
+    dest, err := us.target(upload)
+    if err != nil {
+        return nil, nil, err
+    }
+    if err := us.files.checkUnlocked(dest, upload.Owner); err != nil {
+        return upload, nil, err
+    }
+
+    partPath := filepath.Join(staging, upload.ID)
+    part, err := os.OpenFile(partPath, os.O_WRONLY, 0600)
+    if err != nil {
+        return nil, nil, err
+    }
+    // Trust the record over the file size: a chunk interrupted before its
+    // record was saved is simply overwritten by the retry.
+    if _, err := part.Seek(upload.Offset, io.SeekStart); err != nil {
+        part.Close()
+        return nil, nil, err
+    }
+    written, copyErr := io.Copy(part, io.LimitReader(chunk, upload.Length-upload.Offset))
+    closeErr := part.Close()
+
+    upload.Offset += written
+    upload.UpdatedAt = time.Now()
+    if err := os.Truncate(partPath, upload.Offset); err != nil {
+        return nil, nil, err
+    }
+    if err := writeUploadRecord(staging, upload); err != nil {
+        return nil, nil, err
+    }
+    if copyErr != nil {
+        return upload, nil, copyErr
+    }
+    if closeErr != nil {
+        return upload, nil, closeErr
+    }
+    if upload.Offset < upload.Length {
+        return upload, nil, nil
+    }
+
+    file, err := us.finish(staging, upload, dest)
+    return upload, file, err
+}
+
+// Cancel discards an upload.
+func (us *UploadService) Cancel(id string) error {
+    if !us.acquire(id) {
+        return ErrUploadBusy
+    }
+    defer us.release(id)
+
+    staging, upload, err := us.find(id)
+    if err != nil {
+        return err
+    }
+    return removeUpload(staging, upload.ID)
+}
+
+// target resolves the file an upload is for.
+func (us *UploadService) target(upload *models.Upload) (*resolvedPath, error) {
+    dir, err := us.files.resolve(upload.Path)
+    if err != nil {
+        return nil, err
+    }
+    return dir.child(upload.Filename)
+}
+
+// finish moves a completed upload into its target folder at dest.
+func (us *UploadService) finish(staging string, upload *models.Upload, dest *resolvedPath) (*models.File, error) {
+    partPath := filepath.Join(staging, upload.ID)
+    if err := us.checkContent(partPath, dest.abs); err != nil {
+        removeUpload(staging, upload.ID)
+        return nil, err
+    }
+    file, err := us.files.storeFile(dest, upload.Owner, false, func(tmp string, quotaLeft int64) error {
+        if quotaLeft != unlimitedQuota && upload.Length > quotaLeft {
+            return ErrQuotaExceeded
+        }
+        return renameOrCopy(partPath, tmp)
+    })
+    if errors.Is(err, ErrQuotaExceeded) {
+        // A complete upload cannot be retried, so do not keep it around.
+        removeUpload(staging, upload.ID)
+    }
+    if err != nil {
+        return nil, err
+    }
+    os.Remove(filepath.Join(staging, upload.ID+".json"))
+    return file, nil
+}
+
+// checkContent sniffs the start of a completed upload against the allowed
+// file types, which may have changed since the upload was created.
+func (us *UploadService) checkContent(partPath, dest string) error {
+    policy, err := us.files.UploadPolicy()
+    if err != nil {
+        return err
+    }
+    if err := policy.CheckName(dest); err != nil {
+        return err
+    }
+    part, err := os.Open(partPath)
+    if err != nil {
+        return err
+    }
+    defer part.Close()
+    head := make([]byte, sniffLen)
+    n, _ := io.ReadFull(part, head)
+    return policy.CheckContent(dest, head[:n])
+}
+
+// PurgeExpired removes partial uploads untouched for longer than the expiry.
+func (us *UploadService) PurgeExpired() {
+    cutoff := time.Now().Add(-us.expiry)
+    for i := range us.files.roots {
+        staging := filepath.Join(us.files.roots[i].Path, internalDirName, uploadsDir)
+        entries, err := os.ReadDir(staging)
+        if err != nil {
+            continue
+        }
+        seen := make(map[string]bool)
+        for _, entry := range entries {
+            id := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".tmp"), ".json")
+            if seen[id] {
+                continue
+            }
+            seen[id] = true
+            if _, err := uuid.Parse(id); err != nil || !us.acquire(id) {
+                continue
+            }
+            expired := false
+            if upload, err := readUploadRecord(staging, id); err == nil {
+                expired = upload.UpdatedAt.Before(cutoff)
+            } else if info, err := entry.Info(); err == nil {
+                // Orphaned data without a readable record ages by mtime.
+                expired = info.ModTime().Before(cutoff)
+            }
+            if expired {
+                if err := removeUpload(staging, id); err != nil {
+                    log.Printf("Failed to purge upload %s: %v", id, err)
+                }
+            }
+            us.release(id)
+        }
+    }
+}
+
+// StartCleanup purges expired uploads every interval in the background.
+func (us *UploadService) StartCleanup(interval time.Duration) {
+    go func() {
+        for {
+            us.PurgeExpired()
+            time.Sleep(interval)
+        }
+    }()
+}
+
+// find locates an upload record across all shares.
+func (us *UploadService) find(id string) (string, *models.Upload, error) {
+    if _, err := uuid.Parse(id); err != nil {
+        return "", nil, ErrUploadNotFound
+    }
+    for i := range us.files.roots {
+        staging := filepath.Join(us.files.roots[i].Path, internalDirName, uploadsDir)
+        upload, err := readUploadRecord(staging, id)
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return "", nil, err
+        }
+        if time.Since(upload.UpdatedAt) > us.expiry {
+            return "", nil, ErrUploadNotFound
+        }
+        return staging, upload, nil
+    }
+    return "", nil, ErrUploadNotFound
+}
+
+func (us *UploadService) acquire(id string) bool {
+    us.mu.Lock()
+    defer us.mu.Unlock()
+    if us.active[id] {
+        return false
+    }
+    us.active[id] = true
+    return true
+}
+
+func (us *UploadService) release(id string) {
+    us.mu.Lock()
+    defer us.mu.Unlock()
+    delete(us.active, id)
+}
+
+func readUploadRecord(staging, id string) (*models.Upload, error) {
+    data, err := os.ReadFile(filepath.Join(staging, id+".json"))
+    if err != nil {
+        return nil, err
+    }
+    var upload models.Upload
+    if err := json.Unmarshal(data, &upload); err != nil {
+        return nil, err
+    }
+    return &upload, nil
+}
+
+// writeUploadRecord saves the record atomically so a crash never leaves it half written.
+func writeUploadRecord(staging string, upload *models.Upload) error {
+    data, err := json.Marshal(upload)
+    if err != nil {
+        return err
+    }
+    tmp := filepath.Join(staging, upload.ID+".json.tmp")
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, filepath.Join(staging, upload.ID+".json"))
+}
+
+func removeUpload(staging, id string) error {
+    for _, name := range []string{id, id + ".json.tmp", id + ".json"} {
+        if err := os.Remove(filepath.Join(staging, name)); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    return nil
+}
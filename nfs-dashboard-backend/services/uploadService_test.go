@@ -0,0 +1,110 @@
+package services
+
+import (
+    "os"
+    "strings"
+    "testing"
+    "time"
+    "nfs-dashboard-backend/models"
+)
+
+func TestUploadResumesInChunks(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    uploads := NewUploadService(fs, 0)
+    upload, err := uploads.Create("/data/docs", "plan.txt", 10, "2")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if _, file, err := uploads.Append(upload.ID, 0, strings.NewReader("01234")); err != nil || file != nil {
+        t.Fatalf("first chunk: file = %v, error = %v", file, err)
+    }
+    if _, err := os.Lstat(diskPath(fs, "docs/plan.txt")); !os.IsNotExist(err) {
+        t.Fatal("partial upload is visible in the target folder")
+    }
+    // A retry of the first chunk is refused with the offset to resume from.
+    got, _, err := uploads.Append(upload.ID, 0, strings.NewReader("01234"))
+    if err != ErrOffsetMismatch || got.Offset != 5 {
+        t.Fatalf("repeated chunk: offset = %d, error = %v, want 5 and %v", got.Offset, err, ErrOffsetMismatch)
+    }
+
+    // Partial uploads are kept on disk, so a restarted service resumes them.
+    uploads = NewUploadService(fs, 0)
+    got, err = uploads.Get(upload.ID)
+    if err != nil || got.Offset != 5 {
+        t.Fatalf("after restart: offset = %v, error = %v, want 5", got, err)
+    }
+    // Anything past the declared length is ignored.
+    _, file, err := uploads.Append(upload.ID, 5, strings.NewReader("56789extra"))
+    if err != nil || file == nil {
+        t.Fatalf("last chunk: file = %v, error = %v", file, err)
+    }
+    if content := readTestFile(t, fs, "docs/plan.txt"); content != "0123456789" {
+        t.Errorf("uploaded content = %q", content)
+    }
+    if _, err := uploads.Get(upload.ID); err != ErrUploadNotFound {
+        t.Errorf("finished upload: error = %v, want %v", err, ErrUploadNotFound)
+    }
+}
+
+func TestUploadCreateChecksPolicy(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{MaxFileSize: 1})
+    uploads := NewUploadService(fs, 0)
+    if _, err := uploads.Create("/data/docs", "plan.txt", 1<<20+1, "2"); err != ErrFileTooLarge {
+        t.Errorf("oversized upload: error = %v, want %v", err, ErrFileTooLarge)
+    }
+    if _, err := uploads.Create("/data/docs", "../plan.txt", 1, "2"); err == nil {
+        t.Error("upload with an unsafe name was accepted")
+    }
+    if _, err := uploads.Create("/data/docs/readme.txt", "plan.txt", 1, "2"); err == nil {
+        t.Error("upload into a file was accepted")
+    }
+}
+
+func TestUploadCancelAndExpiry(t *testing.T) {
+    fs := newTestFiles(t, models.SystemSettings{})
+    uploads := NewUploadService(fs, time.Millisecond)
+    cancelled, err := uploads.Create("/data/docs", "a.txt", 10, "2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := uploads.Cancel(cancelled.ID); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := uploads.Get(cancelled.ID); err != ErrUploadNotFound {
+        t.Errorf("cancelled upload: error = %v, want %v", err, ErrUploadNotFound)
+    }
+
+    stale, err := uploads.Create("/data/docs", "b.txt", 10, "2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    time.Sleep(10 * time.Millisecond)
+    uploads.PurgeExpired()
+    if _, err := uploads.Get(stale.ID); err != ErrUploadNotFound {
+        t.Errorf("expired upload: error = %v, want %v", err, ErrUploadNotFound)
+    }
+    entries, err := os.ReadDir(diskPath(fs, internalDirName+"/"+uploadsDir))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 0 {
+        t.Errorf("%d files left in the staging folder", len(entries))
+    }
+}
+
+func TestParseUploadMetadata(t *testing.T) {
+    meta, err := ParseUploadMetadata("filename cGxhbi50eHQ=, is_confidential")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if meta["filename"] != "plan.txt" {
+        t.Errorf("filename = %q, want %q", meta["filename"], "plan.txt")
+    }
+    if value, ok := meta["is_confidential"]; !ok || value != "" {
+        t.Errorf("key without a value = %q, %v", value, ok)
+    }
+    if _, err := ParseUploadMetadata("filename not-base64!"); err == nil {
+        t.Error("invalid base64 was accepted")
+    }
+}
@@ -0,0 +1,21 @@
+package utils
+
+import (
+    "context"
+    "nfs-dashboard-backend/models"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// WithUser returns a copy of ctx carrying the authenticated user.
+func WithUser(ctx context.Context, user *models.User) context.Context {
+    return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the authenticated user stored in ctx, or nil.
+func UserFromContext(ctx context.Context) *models.User {
+    user, _ := ctx.Value(userContextKey).(*models.User)
+    return user
+}
@@ -45,6 +45,9 @@ const FileItem: React.FC<FileItemProps> = ({
   const handleDoubleClick = () => {
     if (file.type === 'folder' && onNavigate) {
       onNavigate(file.path);
+    } else if (file.isArchive && onNavigate) {
+      // Archives are browsed like folders through "<archive>!" paths
+      onNavigate(file.path + '!');
     } else {
       // Preview file
       onAction('preview', file);
@@ -10,10 +10,30 @@ const DEFAULT_SETTINGS = {
   max_storage_per_user: 5,
   enable_audit_log: false,
   session_timeout: 30,
+  trash_retention_days: 30,
+  max_versions: 10,
+  version_retention_days: 90,
+  content_type_overrides: {} as Record<string, string>,
+};
+
+// Overrides are edited one ".ext=type" per line
+const formatOverrides = (overrides: Record<string, string> = {}) =>
+  Object.entries(overrides).map(([ext, type]) => `${ext}=${type}`).join('\n');
+
+const parseOverrides = (text: string): Record<string, string> => {
+  const overrides: Record<string, string> = {};
+  text.split('\n').forEach(line => {
+    const [ext, ...type] = line.split('=');
+    if (ext.trim() && type.join('=').trim()) {
+      overrides[ext.trim()] = type.join('=').trim();
+    }
+  });
+  return overrides;
 };
 
 const SystemSettings: React.FC = () => {
   const [settings, setSettings] = useState<any>(DEFAULT_SETTINGS);
+  const [overridesText, setOverridesText] = useState('');
   const [loading, setLoading] = useState(true);
 
   useEffect(() => {
@@ -22,6 +42,7 @@ const SystemSettings: React.FC = () => {
       .then(res => res.json())
       .then(data => {
         setSettings({ ...DEFAULT_SETTINGS, ...data });
+        setOverridesText(formatOverrides(data.content_type_overrides));
         setLoading(false);
       })
       .catch(() => {
@@ -38,7 +59,7 @@ const SystemSettings: React.FC = () => {
         'Content-Type': 'application/json',
         Authorization: token || ''
       },
-      body: JSON.stringify(settings)
+      body: JSON.stringify({ ...settings, content_type_overrides: parseOverrides(overridesText) })
     });
     // Optionally show a success message
   };
@@ -124,6 +145,70 @@ const SystemSettings: React.FC = () => {
             />
           </div>
 
+          <div>
+            <label className="block text-sm font-medium text-gray-700 mb-2">
+              Trash Retention (days, 0 = keep)
+            </label>
+            <input
+              type="number"
+              value={settings.trash_retention_days}
+              onChange={(e) =>
+                setSettings({
+                  ...settings,
+                  trash_retention_days: Number(e.target.value),
+                })
+              }
+              className="w-full px-3 py-2 border border-gray-300 rounded-md"
+            />
+          </div>
+
+          <div>
+            <label className="block text-sm font-medium text-gray-700 mb-2">
+              Versions Kept Per File (0 = off)
+            </label>
+            <input
+              type="number"
+              value={settings.max_versions}
+              onChange={(e) =>
+                setSettings({
+                  ...settings,
+                  max_versions: Number(e.target.value),
+                })
+              }
+              className="w-full px-3 py-2 border border-gray-300 rounded-md"
+            />
+          </div>
+
+          <div>
+            <label className="block text-sm font-medium text-gray-700 mb-2">
+              Version Retention (days, 0 = keep)
+            </label>
+            <input
+              type="number"
+              value={settings.version_retention_days}
+              onChange={(e) =>
+                setSettings({
+                  ...settings,
+                  version_retention_days: Number(e.target.value),
+                })
+              }
+              className="w-full px-3 py-2 border border-gray-300 rounded-md"
+            />
+          </div>
+
+          <div className="md:col-span-2">
+            <label className="block text-sm font-medium text-gray-700 mb-2">
+              Content Type Overrides (one .ext=type per line)
+            </label>
+            <textarea
+              rows={3}
+              value={overridesText}
+              placeholder=".log=text/plain"
+              onChange={(e) => setOverridesText(e.target.value)}
+              className="w-full px-3 py-2 border border-gray-300 rounded-md font-mono text-sm"
+            />
+          </div>
+
           <div>
             <label className="flex items-center space-x-2">
               <input
@@ -1,10 +1,32 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import { X, Download, FileText, Image, Video, File, Archive, Music } from 'lucide-react';
 import { FileItem } from '../../types';
-import { formatFileSize, getFileTypeIcon } from '../../utils/fileUtils';
+import { fetchObjectUrl, formatFileSize, getFileTypeIcon } from '../../utils/fileUtils';
 
 const BACKEND_URL = import.meta.env.VITE_BACKEND_URL || '';
 
+const IMAGE_EXTENSIONS = ['jpg', 'jpeg', 'png', 'gif', 'webp', 'svg'];
+const VIDEO_EXTENSIONS = ['mp4', 'webm', 'ogg'];
+const AUDIO_EXTENSIONS = ['mp3', 'wav', 'ogg'];
+const TEXT_EXTENSIONS = ['txt', 'md', 'csv', 'json', 'xml', 'html', 'css', 'js'];
+// JPEG, PNG and GIF images are shown as server-side thumbnails
+const THUMBNAIL_EXTENSIONS = ['jpg', 'jpeg', 'png', 'gif'];
+
+const getFileExtension = (fileName: string): string => {
+  return fileName.split('.').pop()?.toLowerCase() || '';
+};
+
+// The backend URL a file's preview is loaded from, or null if it has none.
+const getPreviewSource = (file: FileItem): string | null => {
+  const ext = getFileExtension(file.name);
+  if (THUMBNAIL_EXTENSIONS.includes(ext)) {
+    return `${BACKEND_URL}/api/files/thumbnail?path=${encodeURIComponent(file.path)}&size=512`;
+  }
+  const previewable = [...IMAGE_EXTENSIONS, ...VIDEO_EXTENSIONS, ...AUDIO_EXTENSIONS, ...TEXT_EXTENSIONS, 'pdf'];
+  if (!previewable.includes(ext)) return null;
+  return `${BACKEND_URL}/api/files/download?path=${encodeURIComponent(file.path)}`;
+};
+
 interface FilePreviewModalProps {
   isOpen: boolean;
   onClose: () => void;
@@ -18,6 +40,36 @@ const FilePreviewModal: React.FC<FilePreviewModalProps> = ({
   onDownload,
   file,
 }) => {
+  // Media elements cannot send the Authorization header, so the preview is
+  // fetched with the token and shown from an object URL.
+  const [previewUrl, setPreviewUrl] = useState<string | null>(null);
+  const [previewFailed, setPreviewFailed] = useState(false);
+  const source = isOpen && file && file.type === 'file' ? getPreviewSource(file) : null;
+
+  useEffect(() => {
+    setPreviewUrl(null);
+    setPreviewFailed(false);
+    if (!source) return;
+    let objectUrl: string | null = null;
+    let cancelled = false;
+    fetchObjectUrl(source)
+      .then(url => {
+        if (cancelled) {
+          URL.revokeObjectURL(url);
+          return;
+        }
+        objectUrl = url;
+        setPreviewUrl(url);
+      })
+      .catch(() => {
+        if (!cancelled) setPreviewFailed(true);
+      });
+    return () => {
+      cancelled = true;
+      if (objectUrl) URL.revokeObjectURL(objectUrl);
+    };
+  }, [source]);
+
   if (!isOpen || !file || file.type !== 'file') return null;
 
   const getFileIcon = () => {
@@ -38,23 +90,16 @@ const FilePreviewModal: React.FC<FilePreviewModalProps> = ({
     }
   };
 
-  const getFileExtension = (fileName: string): string => {
-    return fileName.split('.').pop()?.toLowerCase() || '';
-  };
-
   const isImage = () => {
-    const ext = getFileExtension(file.name);
-    return ['jpg', 'jpeg', 'png', 'gif', 'webp', 'svg'].includes(ext);
+    return IMAGE_EXTENSIONS.includes(getFileExtension(file.name));
   };
 
   const isVideo = () => {
-    const ext = getFileExtension(file.name);
-    return ['mp4', 'webm', 'ogg'].includes(ext);
+    return VIDEO_EXTENSIONS.includes(getFileExtension(file.name));
   };
 
   const isAudio = () => {
-    const ext = getFileExtension(file.name);
-    return ['mp3', 'wav', 'ogg'].includes(ext);
+    return AUDIO_EXTENSIONS.includes(getFileExtension(file.name));
   };
 
   const isPdf = () => {
@@ -62,8 +107,7 @@ const FilePreviewModal: React.FC<FilePreviewModalProps> = ({
   };
 
   const isText = () => {
-    const ext = getFileExtension(file.name);
-    return ['txt', 'md', 'csv', 'json', 'xml', 'html', 'css', 'js'].includes(ext);
+    return TEXT_EXTENSIONS.includes(getFileExtension(file.name));
   };
 
   const formatDate = (dateStr: string) => {
@@ -71,15 +115,21 @@ const FilePreviewModal: React.FC<FilePreviewModalProps> = ({
     return date.toLocaleDateString() + ' ' + date.toLocaleTimeString();
   };
 
-  // Construct the download/preview URL
-  const fileUrl = `${BACKEND_URL}/api/files/download?path=${encodeURIComponent(file.path)}`;
-
   const renderPreview = () => {
-    if (isImage()) {
+    if (source && !previewUrl) {
+      return (
+        <div className="flex items-center justify-center bg-gray-100 rounded-lg h-64">
+          {getFileIcon()}
+          <p className="ml-3 text-gray-500">
+            {previewFailed ? 'Preview could not be loaded' : 'Loading preview...'}
+          </p>
+        </div>
+      );
+    } else if (isImage()) {
       return (
         <div className="flex items-center justify-center bg-gray-100 rounded-lg h-64">
           <img
-            src={fileUrl}
+            src={previewUrl ?? undefined}
             alt={file.name}
             className="max-h-60 max-w-full object-contain rounded"
             style={{ background: '#fff' }}
@@ -89,14 +139,14 @@ const FilePreviewModal: React.FC<FilePreviewModalProps> = ({
     } else if (isVideo()) {
       return (
         <div className="flex items-center justify-center bg-gray-100 rounded-lg h-64">
-          <video controls className="max-h-60 max-w-full rounded" src={fileUrl}>
+          <video controls className="max-h-60 max-w-full rounded" src={previewUrl ?? undefined}>
           </video>
         </div>
       );
     } else if (isAudio()) {
       return (
         <div className="flex items-center justify-center bg-gray-100 rounded-lg h-20">
-          <audio controls className="w-full" src={fileUrl}>
+          <audio controls className="w-full" src={previewUrl ?? undefined}>
           </audio>
         </div>
       );
@@ -104,7 +154,7 @@ const FilePreviewModal: React.FC<FilePreviewModalProps> = ({
       return (
         <div className="flex items-center justify-center bg-gray-100 rounded-lg h-64">
           <iframe
-            src={fileUrl}
+            src={previewUrl ?? undefined}
             title={file.name}
             className="w-full h-60 rounded"
             style={{ background: '#fff' }}
@@ -116,7 +166,7 @@ const FilePreviewModal: React.FC<FilePreviewModalProps> = ({
       return (
         <div className="flex items-center justify-center bg-gray-100 rounded-lg h-64">
           <iframe
-            src={fileUrl}
+            src={previewUrl ?? undefined}
             title={file.name}
             className="w-full h-60 rounded"
             style={{ background: '#fff' }}
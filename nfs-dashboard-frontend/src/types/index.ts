@@ -7,6 +7,7 @@ export interface FileItem {
   size: number;
   lastModified: string;
   path: string;
+  isArchive?: boolean;
 }
 
 export interface BreadcrumbItem {
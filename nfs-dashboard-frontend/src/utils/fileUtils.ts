@@ -54,6 +54,7 @@ export const getFiles = async (path: string): Promise<FileItem[]> => {
     size: item.size ?? 0,
     lastModified: item.lastModified ?? '',
     path: item.path,
+    isArchive: !!item.is_archive,
   }));
 };
 
@@ -93,11 +94,23 @@ export const downloadFile = async (file: FileItem): Promise<void> => {
   window.URL.revokeObjectURL(downloadUrl);
 };
 
+// Fetches a file with the token and returns an object URL for media elements,
+// which cannot send the Authorization header. Revoke it when done.
+export const fetchObjectUrl = async (url: string): Promise<string> => {
+  const token = localStorage.getItem('token');
+  const res = await fetch(url, {
+    headers: { Authorization: token || '' }
+  });
+  if (!res.ok) throw new Error('Failed to load file');
+  return window.URL.createObjectURL(await res.blob());
+};
+
 export const uploadFile = async (path: string, file: File): Promise<FileItem> => {
   const token = localStorage.getItem('token');
   const formData = new FormData();
-  formData.append('file', file);
+  // The server streams the upload, so the target path must precede the file.
   formData.append('path', path);
+  formData.append('file', file);
   const res = await fetch(`${BACKEND_URL}/api/files/upload`, {
     method: 'POST',
     headers: { Authorization: token || '' },